@@ -1,7 +1,9 @@
 package assembler
 
 import (
+	"bytes"
 	"gosics/vm"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -298,12 +300,50 @@ func TestPUSH(t *testing.T) {
 	as.Label("SRC")
 	as.DD(0x1234)
 
-	c := t_createComputerAndRun(&as, 9)
+	c := t_createComputerAndRun(&as, 10)
 	assert.Equal(t, vm.Operand(0x1234), c.Peek(vm.MaxAddress-1))
 	assert.Equal(t, vm.Operand(-4), t_peek(&c, &as, "__SP"))
 	assert.Equal(t, t_resolve(&as, "SRC"), c.IP())
 }
 
+func TestPUSHRuntimeIsEmittedAtTheEnd(t *testing.T) {
+	as := New()
+	as.PUSH(Label("SRC"))
+	as.Label("SRC")
+	as.DD(0x1234)
+	as.Assemble()
+
+	assert.Equal(t, Address(0x0E), as.labels["__start"])
+	assert.True(t, as.labels["__push"] > as.labels["SRC"])
+	assert.True(t, as.labels["__SP"] > as.labels["__push"])
+	_, ok := as.labels["__pop"]
+	assert.False(t, ok)
+}
+
+func TestRuntimeIsNotEmittedIfNotUsed(t *testing.T) {
+	as := New()
+	as.HLT()
+	assert.Equal(t, 0x0E+8, len(as.Assemble()))
+	assert.Empty(t, as.routines)
+}
+
+func TestRuntimeIsEmittedOnce(t *testing.T) {
+	as := New()
+	as.PUSH(Label("SRC"))
+	as.Label("SRC")
+	as.DD(0x1234)
+	size := len(as.Assemble())
+	assert.Equal(t, size, len(as.Assemble()))
+}
+
+func TestRuntimeObject(t *testing.T) {
+	obj, err := RuntimeObject(vm.DefaultConfig, "__pop", "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, []Label{"__SP", "__SP_base", "__SP_limit", "__pop", "__pop_ret",
+		"__push_operand", "__stack_fault", "__stack_underflow"}, obj.Exports)
+	assert.Contains(t, obj.Imports, ONE)
+}
+
 func TestPOP(t *testing.T) {
 	as := New()
 	as.PUSH(Label("SRC"))
@@ -313,8 +353,166 @@ func TestPOP(t *testing.T) {
 	as.Label("DST")
 	as.DD(0x0000)
 
-	c := t_createComputerAndRun(&as, 9+10)
+	c := t_createComputerAndRun(&as, 10+11)
 	assert.Equal(t, vm.Operand(0x1234), t_peek(&c, &as, "DST"))
 	assert.Equal(t, vm.Operand(-2), t_peek(&c, &as, "__SP"))
 	assert.Equal(t, t_resolve(&as, "SRC"), c.IP())
 }
+
+// listing and symbols
+
+func TestSymbols(t *testing.T) {
+	as := New()
+	as.Label("FOO")
+	as.DD(0x1234)
+	symbols := as.Symbols()
+	a, ok := symbols.Lookup("FOO")
+	assert.True(t, ok)
+	assert.Equal(t, t_resolve(&as, "FOO"), a)
+	a, ok = symbols.Lookup(string(ONE))
+	assert.True(t, ok)
+	assert.Equal(t, vm.Address(8), a)
+}
+
+func TestListing(t *testing.T) {
+	as := New()
+	as.Label("LOOP")
+	as.MOV(Label("SRC"), Label("DST"))
+	as.JMP(Label("LOOP"))
+	as.Label("SRC")
+	as.DD(0x1234, 1, 2, 3, 4)
+	as.Label("DST")
+
+	var buf bytes.Buffer
+	assert.Nil(t, as.Listing(&buf))
+	lines := strings.Split(buf.String(), "\n")
+	assert.Equal(t, "0000  0008 000a 000c 000e  SBNZ __ONE, __ZERO, __JUNK, __start", lines[0])
+	assert.Equal(t, "                           __ONE:", lines[1])
+	assert.Equal(t, "0008  0001                 DD 0x0001", lines[2])
+
+	listing := buf.String()[strings.Index(buf.String(), "LOOP:")-27:]
+	expected := `                           LOOP:
+                           __start:
+                           ; MOV SRC, DST
+000e  001e 000a 0028 0016    SBNZ SRC, __ZERO, DST, __label_0001
+                           ; JMP LOOP
+0016  0008 000a 000c 000e    SBNZ __ONE, __ZERO, __JUNK, LOOP
+                           SRC:
+001e  1234 0001 0002 0003  DD 0x1234 0x0001 0x0002 0x0003 0x0004
+0026  0004
+                           DST:
+`
+	assert.Equal(t, expected, listing)
+}
+
+func TestAssembleOnce(t *testing.T) {
+	as := New(WithOptimization(true))
+	as.PUSH(Imm(5))
+	as.JMP(Label("end"))
+	as.Label("end")
+	as.HLT()
+	program := as.Assemble()
+	assembled, records := as.assembled, len(as.records)
+	program[0] = 0xFF
+	var buf bytes.Buffer
+	as.Listing(&buf)
+	as.Symbols()
+	as.MemoryMap()
+	as.Warnings()
+	as.Lint()
+	as.Banks()
+	assert.True(t, assembled == as.assembled)
+	assert.Equal(t, records, len(as.records))
+	assert.Equal(t, uint8(0), as.Assemble()[0])
+
+	// emitting discards it
+	as.Label("X")
+	as.DD(7)
+	assert.Nil(t, as.assembled)
+	assert.Equal(t, len(program)+2, len(as.Assemble()))
+	_, ok := as.Symbols().Lookup("X")
+	assert.True(t, ok)
+}
+
+func TestBLTZ(t *testing.T) {
+	data := []struct {
+		value  uint16
+		branch bool
+	}{
+		{0x0000, false},
+		{0x0001, false},
+		{0x1234, false},
+		{0x7FFF, false},
+		{0x8000, true},
+		{0xFFFF, true},
+		{0xABCD, true},
+	}
+	for i, d := range data {
+		t.Logf("Iteration %d, %#v", i, d)
+		as := New()
+		as.BLTZ(Label("OP"), Label("DST"))
+		as.HLT()
+		as.Label("DST")
+		as.MOV(ONE, Label("BRANCHED"))
+		as.HLT()
+		as.Label("OP")
+		as.DD(d.value)
+		as.Label("BRANCHED")
+		as.DD(0)
+
+		c := t_runUntilHalted(&as)
+		assert.True(t, c.Halted())
+		assert.Equal(t, d.branch, t_peek(&c, &as, "BRANCHED") == 1)
+		assert.Equal(t, vm.Operand(int16(d.value)), t_peek(&c, &as, "OP"))
+	}
+}
+
+func TestBLEZ(t *testing.T) {
+	data := []struct {
+		value  uint16
+		branch bool
+	}{
+		{0x0000, true},
+		{0x0001, false},
+		{0x7FFF, false},
+		{0x8000, true},
+		{0xFFFF, true},
+	}
+	for i, d := range data {
+		t.Logf("Iteration %d, %#v", i, d)
+		as := New()
+		as.BLEZ(Label("OP"), Label("DST"))
+		as.HLT()
+		as.Label("DST")
+		as.MOV(ONE, Label("BRANCHED"))
+		as.HLT()
+		as.Label("OP")
+		as.DD(d.value)
+		as.Label("BRANCHED")
+		as.DD(0)
+
+		c := t_runUntilHalted(&as)
+		assert.True(t, c.Halted())
+		assert.Equal(t, d.branch, t_peek(&c, &as, "BRANCHED") == 1)
+	}
+}
+
+func TestSUBLEQ(t *testing.T) {
+	as := New()
+	as.SUBLEQ(Label("A"), Label("B"), Label("DST"))
+	as.SUBLEQ(Label("A"), Label("B"), Label("DST"))
+	as.HLT()
+	as.Label("DST")
+	as.MOV(ONE, Label("BRANCHED"))
+	as.HLT()
+	as.Label("A")
+	as.DD(3)
+	as.Label("B")
+	as.DD(5)
+	as.Label("BRANCHED")
+	as.DD(0)
+
+	c := t_runUntilHalted(&as)
+	assert.Equal(t, vm.Operand(-1), t_peek(&c, &as, "B"))
+	assert.Equal(t, vm.Operand(1), t_peek(&c, &as, "BRANCHED"))
+}
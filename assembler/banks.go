@@ -0,0 +1,259 @@
+package assembler
+
+import (
+	"fmt"
+	"gosics/vm"
+	"sort"
+	"strings"
+)
+
+// Banked memory
+//
+// With WithBanks the program can place code and data in named banks
+// of a bank switched memory (see vm.Banks). Code and data in a bank
+// are assembled at the addresses of the window and stored apart, see
+// Banks. Switching banks while executing code in the window would
+// change the code under our feet, so the far jump and call macro
+// instructions switch banks from a trampoline in the main memory,
+// the runtime routine __far_jump. The trampoline, like the rest of the
+// runtime routines and the constant pool, is used from every bank: Err
+// reports it if the window covers them.
+
+// BankImage the contents of a bank, to be loaded at the start of the
+// window (see vm.Computer.LoadBank)
+type BankImage struct {
+	Name   string
+	Number int
+	Code   []uint8
+}
+
+// Bank selects where the following code and data are placed: in the
+// named bank, or in the main memory if name is empty. Banks are
+// numbered from 1 in the order their names are first used, bank 0 is
+// the main memory behind the window. Requires WithBanks.
+func (self *Assembler) Bank(name string) {
+	if self.bank == name {
+		return
+	}
+	number, ok := self.bankNumber(name)
+	if !ok {
+		return
+	}
+	self.bank_ips[self.bank] = self.ip
+	self.bank = name
+	defer func() {
+		text := "bank " + name
+		if name == "" {
+			text = "main memory"
+		}
+		self.records = append(self.records, record{address: self.ip, pos: self.pos(), text: text, comment: true})
+	}()
+	if name == "" {
+		self.ip = self.bank_ips[name]
+		self.delta = 0
+		return
+	}
+	ip, ok := self.bank_ips[name]
+	if !ok {
+		ip = Address(self.banks.Window)
+	}
+	self.ip = ip
+	self.delta = self.bankBase(number) - Address(self.banks.Window)
+}
+
+// bankNumber return the number of the bank, allocating one for new
+// names. Records an error if the bank can't be allocated.
+func (self *Assembler) bankNumber(name string) (int, bool) {
+	if n, ok := self.bank_nums[name]; ok {
+		return n, true
+	}
+	if self.banks == nil {
+		self.errs = append(self.errs, fmt.Errorf("bank %s: banks not configured", name))
+		return 0, false
+	}
+	n := len(self.bank_nums)
+	if n >= self.banks.Count {
+		self.errs = append(self.errs, fmt.Errorf("bank %s: too many banks", name))
+		return 0, false
+	}
+	self.bank_nums[name] = n
+	return n, true
+}
+
+// banked return true if the banks are configured. Records an error
+// otherwise.
+func (self *Assembler) banked(macro string) bool {
+	if self.banks == nil {
+		self.errs = append(self.errs, fmt.Errorf("%s: banks not configured", macro))
+		return false
+	}
+	return true
+}
+
+// bankBase return the position in memory of the bank
+func (self *Assembler) bankBase(number int) Address {
+	return Address(self.config.MaxAddress()) + 1 + Address(number-1)*Address(self.banks.Size)
+}
+
+// bankSize return the bytes used in the bank
+func (self *Assembler) bankSize(name string) Address {
+	ip := self.bank_ips[name]
+	if name == self.bank {
+		ip = self.ip
+	}
+	if ip < Address(self.banks.Window) {
+		return 0
+	}
+	return ip - Address(self.banks.Window)
+}
+
+// Banks return the contents of the banks, sorted by number. Intended
+// to be called after Assemble.
+func (self *Assembler) Banks() []BankImage {
+	image := self.image()
+	var res []BankImage
+	for name, n := range self.bank_nums {
+		if n == 0 {
+			continue
+		}
+		base := self.bankBase(n)
+		code := make([]uint8, self.bankSize(name))
+		if int(base) < len(image) {
+			copy(code, image[base:])
+		}
+		res = append(res, BankImage{name, n, code})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Number < res[j].Number })
+	return res
+}
+
+// bankErrors checks the banks don't overflow the window and the code
+// and data used from every bank, the preamble, the runtime routines
+// and the constant pool, are outside the window
+func (self *Assembler) bankErrors() []error {
+	if self.banks == nil {
+		return nil
+	}
+	var errs []error
+	var names []string
+	for name := range self.bank_nums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name != "" && self.bankSize(name) > Address(self.banks.Size) {
+			errs = append(errs, fmt.Errorf("bank %s overflows the window", name))
+		}
+	}
+	window := Address(self.banks.Window)
+	end := window + Address(self.banks.Size)
+	inside := make(map[string]bool)
+	for _, r := range self.records {
+		if !r.internal || r.comment || r.pos != r.address || r.address >= end || r.address+r.size <= window {
+			continue
+		}
+		if name := self.owner(r.address); !inside[name] {
+			inside[name] = true
+			errs = append(errs, fmt.Errorf("%s inside the bank window", name))
+		}
+	}
+	return errs
+}
+
+// owner return the name of the internal code or data at p of the main
+// memory: the closest reserved label defined before, or "preamble"
+func (self *Assembler) owner(p Address) string {
+	var name Label
+	var at Address
+	for l, a := range self.labels {
+		if a > p || self.label_pos[l] != a || !strings.HasPrefix(string(l), "__") || self.generated(l) {
+			continue
+		}
+		if name == "" || a > at || a == at && l < name {
+			name, at = l, a
+		}
+	}
+	if name == "" {
+		return "preamble"
+	}
+	return string(name)
+}
+
+// FARJMP jump to 'dst' in the named bank, "" for the main memory.
+// Switches banks through __far_jump, so it can be used from any bank.
+func (self *Assembler) FARJMP(bank string, dst Labeler) {
+	defer self.beginMacro("FARJMP", Label(bank), dst)()
+	if !self.banked("FARJMP") {
+		return
+	}
+	number, ok := self.bankNumber(bank)
+	if !ok {
+		return
+	}
+	data := self.uniqLabel()
+	target := self.uniqLabel()
+	self.MOV(data, Label("__far_bank"))
+	self.MOV(target, Label("__far_target"))
+	self.JMP(Label("__far_jump"))
+	self.Label(data)
+	self.DW(uint32(number))
+	self.Label(target)
+	self.addresses(dst)
+}
+
+// FARCALL call the subroutine at 'dst' in the named bank. The current
+// bank and the return address are pushed into the stack, the
+// subroutine must return with FARRET.
+func (self *Assembler) FARCALL(bank string, dst Labeler) {
+	defer self.beginMacro("FARCALL", Label(bank), dst)()
+	if !self.banked("FARCALL") {
+		return
+	}
+	data := self.uniqLabel()
+	ret := self.uniqLabel()
+	self.PUSH(Address(self.banks.Select))
+	self.PUSH(data)
+	self.FARJMP(bank, dst)
+	self.Label(data)
+	self.addresses(ret)
+	self.Label(ret)
+}
+
+// FARRET return from a subroutine called with FARCALL, restoring the
+// caller's bank
+func (self *Assembler) FARRET() {
+	defer self.beginMacro("FARRET")()
+	if !self.banked("FARRET") {
+		return
+	}
+	self.POP(Label("__far_target"))
+	self.POP(Label("__far_bank"))
+	self.JMP(Label("__far_jump"))
+}
+
+// emitFar emits the trampoline used to switch banks: selects the bank
+// in __far_bank and jumps to __far_target
+func (self *Assembler) emitFar() {
+	defer self.selfModifying()()
+	self.Label(Label("__far_jump"))
+	self.SBNZ(Label("__far_bank"), ZERO, Address(self.banks.Select), self.at(4))
+	// copy the target in the D operand of the next instruction
+	self.SBNZ(Label("__far_target"), ZERO, self.at(7), self.at(4))
+	self.SBNZ(ONE, ZERO, JUNK, HLT)
+	self.Label(Label("__far_bank"))
+	self.DD(0)
+	self.Label(Label("__far_target"))
+	self.DD(0)
+}
+
+// WithBanks enables the banked memory, see Bank. Must follow
+// WithConfig.
+func WithBanks(banks vm.Banks) Option {
+	return func(a *Assembler) error {
+		if err := banks.Validate(a.config); err != nil {
+			return err
+		}
+		a.banks = &banks
+		return nil
+	}
+}
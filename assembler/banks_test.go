@@ -0,0 +1,167 @@
+package assembler
+
+import (
+	"gosics/vm"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// t_banks 4 banks of 4K at 0x4000
+var t_banks = vm.Banks{Window: 0x4000, Size: 0x1000, Count: 4, Select: 0x3FFE}
+
+// t_runBanked like t_runUntilHalted for banked programs
+func t_runBanked(t *testing.T, a *Assembler) vm.Computer {
+	c := vm.Computer{}
+	assert.Nil(t, c.SetBanks(t_banks))
+	c.LoadMemory(a.Assemble())
+	for _, b := range a.Banks() {
+		assert.Nil(t, c.LoadBank(b.Number, b.Code))
+	}
+	for i := 0; i < 10000 && !c.Halted(); i++ {
+		c.Step()
+	}
+	return c
+}
+
+func TestFarJumpsAndCalls(t *testing.T) {
+	as := New(WithBanks(t_banks))
+	as.FARCALL("double", Label("DOUBLE"))
+	as.FARJMP("finish", Label("FINISH"))
+	as.Label("BACK")
+	as.HLT()
+	as.Label("VALUE")
+	as.DD(21)
+	as.Label("RESULT")
+	as.DD(0)
+
+	as.Bank("double")
+	as.Label("DOUBLE")
+	as.ADD(Label("VALUE"), Label("VALUE"), Label("VALUE"))
+	as.FARRET()
+
+	as.Bank("finish")
+	as.Label("FINISH")
+	as.ADD(Label("VALUE"), Label("OFFSET"), Label("RESULT"))
+	as.FARJMP("", Label("BACK"))
+	as.Label("OFFSET")
+	as.DD(100)
+
+	c := t_runBanked(t, &as)
+	assert.Nil(t, as.Err())
+	assert.Nil(t, c.Fault())
+	assert.True(t, c.Halted())
+	assert.Equal(t, vm.Operand(42), t_peek(&c, &as, "VALUE"))
+	assert.Equal(t, vm.Operand(142), t_peek(&c, &as, "RESULT"))
+	assert.Equal(t, 0, c.Bank())
+
+	banks := as.Banks()
+	assert.Equal(t, 2, len(banks))
+	assert.Equal(t, "double", banks[0].Name)
+	assert.Equal(t, 1, banks[0].Number)
+	assert.Equal(t, Address(0x4000), as.labels["DOUBLE"])
+	assert.Equal(t, Address(0x4000), as.labels["FINISH"])
+	assert.True(t, as.labels["__far_jump"] < 0x4000)
+}
+
+func TestBanksRequireWithBanks(t *testing.T) {
+	as := New()
+	as.Bank("one")
+	assert.Equal(t, "bank one: banks not configured", as.Err().Error())
+}
+
+func TestFarJumpsRequireWithBanks(t *testing.T) {
+	as := New()
+	as.FARJMP("", Label("X"))
+	as.Label("X")
+	as.FARCALL("one", Label("X"))
+	as.FARRET()
+	as.Assemble()
+	err := as.Err()
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "FARJMP: banks not configured; FARCALL: banks not configured; FARRET: banks not configured", err.Error())
+	}
+	assert.False(t, as.routines["far"])
+
+	as = New()
+	as.JMP(Label("__far_jump"))
+	as.Assemble()
+	assert.Contains(t, as.Err().Error(), "__far_jump: banks not configured")
+
+	_, err = RuntimeObject(vm.Config{}, "__far_jump")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "__far_jump: banks not configured", err.Error())
+	}
+}
+
+func TestTooManyBanks(t *testing.T) {
+	as := New(WithBanks(t_banks))
+	for _, name := range []string{"one", "two", "three", "four"} {
+		as.Bank(name)
+	}
+	assert.Equal(t, "bank four: too many banks", as.Err().Error())
+}
+
+func TestBankOverflow(t *testing.T) {
+	as := New(WithBanks(t_banks))
+	as.Bank("one")
+	as.DB(make([]uint8, 0x1001)...)
+	as.Assemble()
+	assert.Equal(t, "bank one overflows the window", as.Err().Error())
+}
+
+func TestBankedModulesCantBeLinked(t *testing.T) {
+	as := NewModule(WithBanks(t_banks))
+	as.Bank("one")
+	_, err := as.Object()
+	assert.NotNil(t, err)
+}
+
+// t_farProgram a banked program calling a far subroutine, using the
+// stack, the trampoline and the constant pool
+func t_farProgram(banks vm.Banks) Assembler {
+	as := New(WithBanks(banks))
+	as.FARCALL("one", Label("SUB"))
+	as.HLT()
+	as.Bank("one")
+	as.Label("SUB")
+	as.ADD(Label("X"), Imm(3), Label("X"))
+	as.FARRET()
+	as.Label("X")
+	as.DD(0)
+	as.Assemble()
+	return as
+}
+
+func TestRuntimeOutsideTheWindow(t *testing.T) {
+	as := t_farProgram(t_banks)
+	assert.Nil(t, as.Err())
+	for _, c := range []struct {
+		label  Label
+		offset Address
+		err    string
+	}{
+		// the trampoline straddles the window edge
+		{"__far_jump", 8, "__far_jump inside the bank window"},
+		{"__far_target", 0, "__far_target inside the bank window"},
+		{"__push", 0, "__push inside the bank window"},
+		{"__SP", 0, "__SP inside the bank window"},
+		{"__imm_0003", 0, "__imm_0003 inside the bank window"},
+		{"__ONE", 0, "__ONE inside the bank window"},
+	} {
+		banks := t_banks
+		banks.Window = vm.Address(as.labels[c.label] + c.offset)
+		banks.Select = 0xFFFE
+		bad := t_farProgram(banks)
+		if assert.NotNil(t, bad.Err(), c.label) {
+			assert.Contains(t, bad.Err().Error(), c.err, c.label)
+		}
+	}
+
+	// right after the runtime
+	banks := t_banks
+	banks.Window = vm.Address(len(as.Assemble()))
+	banks.Select = 0xFFFE
+	as = t_farProgram(banks)
+	assert.Nil(t, as.Err())
+}
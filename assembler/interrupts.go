@@ -0,0 +1,95 @@
+package assembler
+
+import (
+	"fmt"
+	"gosics/vm"
+)
+
+// Interrupts
+//
+// With WithInterrupts the program can program the timer interrupt of
+// the computer (see vm.Interrupts): TIMER and VECTOR set the period
+// and the handler, EI and DI enable and disable the interrupts and
+// the handler returns with RETI. The handler runs with the interrupts
+// disabled and must preserve any state used by the interrupted code,
+// JUNK included.
+
+// interrupts return the interrupt registers. Records an error if the
+// interrupts are not configured.
+func (self *Assembler) interrupts(macro string) (*vm.Interrupts, bool) {
+	if self.ints == nil {
+		self.errs = append(self.errs, fmt.Errorf("%s: interrupts not configured", macro))
+		return nil, false
+	}
+	return self.ints, true
+}
+
+// TIMER sets the number of steps between interrupts to the content
+// of 'a', 0 stops the timer
+func (self *Assembler) TIMER(a Labeler) {
+	defer self.beginMacro("TIMER", a)()
+	if regs, ok := self.interrupts("TIMER"); ok {
+		self.MOV(a, Address(regs.Timer))
+	}
+}
+
+// VECTOR sets the interrupt handler to 'handler'
+func (self *Assembler) VECTOR(handler Labeler) {
+	defer self.beginMacro("VECTOR", handler)()
+	regs, ok := self.interrupts("VECTOR")
+	if !ok {
+		return
+	}
+	data := self.uniqLabel()
+	exit := self.uniqLabel()
+	self.MOV(data, Address(regs.Vector))
+	self.JMP(exit)
+	self.Label(data)
+	self.addresses(handler)
+	self.Label(exit)
+}
+
+// EI enable interrupts
+func (self *Assembler) EI() {
+	defer self.beginMacro("EI")()
+	if regs, ok := self.interrupts("EI"); ok {
+		self.MOV(ONE, Address(regs.Enable))
+	}
+}
+
+// DI disable interrupts
+func (self *Assembler) DI() {
+	defer self.beginMacro("DI")()
+	if regs, ok := self.interrupts("DI"); ok {
+		self.MOV(ZERO, Address(regs.Enable))
+	}
+}
+
+// RETI return from the interrupt handler: enables the interrupts and
+// jumps to the saved IP. The computer doesn't take interrupts right
+// after enabling them, so the jump can't be interrupted.
+func (self *Assembler) RETI() {
+	defer self.beginMacro("RETI")()
+	regs, ok := self.interrupts("RETI")
+	if !ok {
+		return
+	}
+	// copy the saved IP in the D operand of the jump
+	self.SBNZ(Address(regs.SavedIP), ZERO, self.at(11), self.at(4))
+	self.SBNZ(ONE, ZERO, Address(regs.Enable), self.at(4))
+	// doesn't touch JUNK, the interrupted code may be using it
+	defer self.selfModifying()()
+	self.SBNZ(ONE, ZERO, Address(regs.Enable), HLT)
+}
+
+// WithInterrupts enables the timer interrupt macro instructions. Must
+// follow WithConfig.
+func WithInterrupts(interrupts vm.Interrupts) Option {
+	return func(a *Assembler) error {
+		if err := interrupts.Validate(a.config); err != nil {
+			return err
+		}
+		a.ints = &interrupts
+		return nil
+	}
+}
@@ -0,0 +1,63 @@
+package assembler
+
+import (
+	"gosics/vm"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// t_interrupts the registers at 0x0F00
+var t_interrupts = vm.Interrupts{Timer: 0x0F00, Vector: 0x0F02, SavedIP: 0x0F04, Enable: 0x0F06}
+
+func TestTimerInterrupts(t *testing.T) {
+	as := New(WithInterrupts(t_interrupts))
+	as.VECTOR(Label("HANDLER"))
+	as.TIMER(Label("PERIOD"))
+	as.EI()
+	as.Label("LOOP")
+	as.INC(Label("COUNT"))
+	as.JMP(Label("LOOP"))
+	as.Label("HANDLER")
+	as.MOV(JUNK, Label("SAVED"))
+	as.INC(Label("TICKS"))
+	as.BEQ(Label("TICKS"), Label("LIMIT"), Label("STOP"))
+	as.MOV(Label("SAVED"), JUNK)
+	as.RETI()
+	as.Label("STOP")
+	as.HLT()
+	for _, l := range []string{"COUNT", "TICKS", "SAVED"} {
+		as.Label(Label(l))
+		as.DD(0)
+	}
+	as.Label("PERIOD")
+	as.DD(7)
+	as.Label("LIMIT")
+	as.DD(5)
+	assert.Nil(t, as.Err())
+
+	c := vm.Computer{}
+	assert.Nil(t, c.SetInterrupts(t_interrupts))
+	c.LoadMemory(as.Assemble())
+	c.SetMemoryMap(as.MemoryMap())
+	for i := 0; i < 1000 && !c.Halted(); i++ {
+		c.Step()
+	}
+	assert.Nil(t, c.Fault())
+	assert.True(t, c.Halted())
+	assert.Equal(t, vm.Operand(5), t_peek(&c, &as, "TICKS"))
+	// each INC takes 3 steps, the loop 4
+	count := t_peek(&c, &as, "COUNT")
+	assert.True(t, count > 5 && count < 4*7, "count %d", count)
+}
+
+func TestInterruptsNotConfigured(t *testing.T) {
+	as := New()
+	as.EI()
+	as.RETI()
+	as.HLT()
+
+	err := as.Err()
+	assert.NotNil(t, err)
+	assert.Equal(t, "EI: interrupts not configured; RETI: interrupts not configured", err.Error())
+}
@@ -0,0 +1,493 @@
+package assembler
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// Library routines
+//
+// Routines every program ends up writing, emitted like the runtime
+// routines, at the end of the program and only if used. Each macro
+// instruction copies its arguments into the parameters of the
+// routine, __name_0, __name_1..., and the return address into
+// __name_ret, jumps to the routine and copies its results, __name_r0,
+// __name_r1..., when it returns.
+//
+// Buffers and strings are passed by address, the labels pointing to
+// them, the rest of the arguments by value, the cells holding them
+// or an Imm. Strings are zero-terminated, one character per word, as
+// stored by DS. The routines overwrite __JUNK.
+//
+// The steps of each macro instruction, the call included, are
+// documented with it, the optimizer removes one. The routines testing
+// the sign of a value rely on BLTZ, hundreds of steps per test.
+
+// ref an argument of a library routine passed by address
+type ref struct {
+	Labeler
+}
+
+// param return the label of the i-th parameter of the routine
+func param(name string, i int) Label {
+	return Label(fmt.Sprintf("__%s_%d", name, i))
+}
+
+// result return the label of the i-th result of the routine
+func result(name string, i int) Label {
+	return Label(fmt.Sprintf("__%s_r%d", name, i))
+}
+
+// libraryLabels return the labels defined by the library routine:
+// the entry point, the return address, the parameters and the
+// results
+func libraryLabels(name string, params, results int, others ...Label) []Label {
+	res := []Label{Label("__" + name), Label("__" + name + "_ret")}
+	for i := 0; i < params; i++ {
+		res = append(res, param(name, i))
+	}
+	for i := 0; i < results; i++ {
+		res = append(res, result(name, i))
+	}
+	return append(res, others...)
+}
+
+// callLibrary calls the library routine, see the Library routines
+// section
+func (self *Assembler) callLibrary(name string, args []Labeler, results ...Labeler) {
+	ret := self.uniqLabel()
+	data := self.uniqLabel()
+	var refs []Labeler
+	var refLabels []Label
+	for i, a := range args {
+		if r, ok := a.(ref); ok {
+			l := self.uniqLabel()
+			refs = append(refs, r.Labeler)
+			refLabels = append(refLabels, l)
+			a = l
+		}
+		self.MOV(a, param(name, i))
+	}
+	self.MOV(data, Label("__"+name+"_ret"))
+	self.JMP(Label("__" + name))
+	self.Label(data)
+	self.addresses(ret)
+	for i, l := range refLabels {
+		self.Label(l)
+		self.addresses(refs[i])
+	}
+	self.Label(ret)
+	for i, r := range results {
+		self.MOV(result(name, i), r)
+	}
+}
+
+// emitLibrary emits a library routine: the code emitted by body,
+// jumping to done, or falling through, to return, and the cells of
+// the parameters, the results and the scratch cells
+func (self *Assembler) emitLibrary(name string, params, results int, scratch []Label, body func(done Label)) {
+	defer self.selfModifying()()
+	done := self.uniqLabel()
+	self.Label(Label("__" + name))
+	body(done)
+	self.Label(done)
+	self.addresses(ONE, ZERO, JUNK)
+	self.Label(Label("__" + name + "_ret"))
+	self.DD(0xFFFF)
+	cells := append([]Label{}, scratch...)
+	for i := 0; i < params; i++ {
+		cells = append(cells, param(name, i))
+	}
+	for i := 0; i < results; i++ {
+		cells = append(cells, result(name, i))
+	}
+	for _, l := range cells {
+		self.Label(l)
+		self.DD(0)
+	}
+}
+
+// load copies the word pointed by ptr into dst, self-modifying
+func (self *Assembler) load(ptr, dst Labeler) {
+	// copy the pointer in the A parameter of the next instruction
+	self.SBNZ(ptr, ZERO, self.at(4), self.at(4))
+	self.SBNZ(maxAddress-1, ZERO, dst, self.at(4))
+}
+
+// store copies src into the word pointed by ptr, self-modifying
+func (self *Assembler) store(src, ptr Labeler) {
+	// copy the pointer in the C parameter of the next instruction
+	self.SBNZ(ptr, ZERO, self.at(6), self.at(4))
+	self.SBNZ(src, ZERO, maxAddress-1, self.at(4))
+}
+
+// advance moves the pointer to the next word
+func (self *Assembler) advance(ptr Labeler) {
+	self.SBNZ(ptr, self.minusWord(), ptr, self.at(4))
+}
+
+// minusWord return the constant -word size, adding it to an address
+// advances to the next word
+func (self *Assembler) minusWord() Imm {
+	return Imm(-int32(self.word()))
+}
+
+// ------------------------------------------------------ memory
+
+// MEMCPY copies n words from the buffer at src to the buffer at dst.
+// Takes 4n + 9 steps, 10 if n is zero.
+func (self *Assembler) MEMCPY(src, dst, n Labeler) {
+	defer self.beginMacro("MEMCPY", src, dst, n)()
+	self.callLibrary("memcpy", []Labeler{ref{src}, ref{dst}, n})
+}
+
+// emitMemcpy emits the routine of MEMCPY
+func (self *Assembler) emitMemcpy() {
+	src, dst, n := param("memcpy", 0), param("memcpy", 1), param("memcpy", 2)
+	self.emitLibrary("memcpy", 3, 0, nil, func(done Label) {
+		copy := self.uniqLabel()
+		// copy the pointers in the A and C parameters of copy
+		self.SBNZ(src, ZERO, self.at(16), self.at(4))
+		self.SBNZ(dst, ZERO, self.at(14), self.at(4))
+		self.SBNZ(n, ZERO, JUNK, copy)
+		self.JMP(done)
+		self.Label(copy)
+		self.SBNZ(maxAddress-1, ZERO, maxAddress-1, self.at(4))
+		self.SBNZ(self.at(-4), self.minusWord(), self.at(-4), self.at(4))
+		self.SBNZ(self.at(-6), self.minusWord(), self.at(-6), self.at(4))
+		self.SBNZ(n, ONE, n, copy)
+	})
+}
+
+// MEMSET stores value into n words of the buffer at dst. Takes 3n +
+// 8 steps, 9 if n is zero.
+func (self *Assembler) MEMSET(dst, value, n Labeler) {
+	defer self.beginMacro("MEMSET", dst, value, n)()
+	self.callLibrary("memset", []Labeler{ref{dst}, value, n})
+}
+
+// emitMemset emits the routine of MEMSET
+func (self *Assembler) emitMemset() {
+	dst, value, n := param("memset", 0), param("memset", 1), param("memset", 2)
+	self.emitLibrary("memset", 3, 0, nil, func(done Label) {
+		set := self.uniqLabel()
+		// copy the pointer in the C parameter of set
+		self.SBNZ(dst, ZERO, self.at(14), self.at(4))
+		self.SBNZ(n, ZERO, JUNK, set)
+		self.JMP(done)
+		self.Label(set)
+		self.SBNZ(value, ZERO, maxAddress-1, self.at(4))
+		self.SBNZ(self.at(-2), self.minusWord(), self.at(-2), self.at(4))
+		self.SBNZ(n, ONE, n, set)
+	})
+}
+
+// ------------------------------------------------------ strings
+
+// STRLEN stores in dst the length of the string at s. Takes 3n + 9
+// steps for n characters.
+func (self *Assembler) STRLEN(s, dst Labeler) {
+	defer self.beginMacro("STRLEN", s, dst)()
+	self.callLibrary("strlen", []Labeler{ref{s}}, dst)
+}
+
+// emitStrlen emits the routine of STRLEN
+func (self *Assembler) emitStrlen() {
+	s, n := param("strlen", 0), result("strlen", 0)
+	self.emitLibrary("strlen", 1, 1, nil, func(done Label) {
+		load := self.uniqLabel()
+		count := self.uniqLabel()
+		// copy the pointer in the A parameter of load
+		self.SBNZ(s, ZERO, self.at(8), self.at(4))
+		self.MOV(ZERO, n)
+		self.Label(load)
+		self.SBNZ(maxAddress-1, ZERO, JUNK, count)
+		self.JMP(done)
+		self.Label(count)
+		self.SBNZ(self.at(-8), self.minusWord(), self.at(-8), self.at(4))
+		self.SBNZ(n, Imm(-1), n, load)
+	})
+}
+
+// STRCMP compares the strings at a and b, stores in dst the
+// difference of the first characters not equal, a's minus b's, or 0
+// if the strings are equal. Takes 5n + 10 steps when the strings
+// differ at character n, 5n + 12 if they are equal and n characters
+// long.
+func (self *Assembler) STRCMP(a, b, dst Labeler) {
+	defer self.beginMacro("STRCMP", a, b, dst)()
+	self.callLibrary("strcmp", []Labeler{ref{a}, ref{b}}, dst)
+}
+
+// emitStrcmp emits the routine of STRCMP
+func (self *Assembler) emitStrcmp() {
+	a, b, res := param("strcmp", 0), param("strcmp", 1), result("strcmp", 0)
+	self.emitLibrary("strcmp", 2, 1, nil, func(done Label) {
+		cmp := self.uniqLabel()
+		advance := self.uniqLabel()
+		// copy the pointers in the A and B parameters of cmp and
+		// the A parameter of the next instruction
+		self.SBNZ(a, ZERO, self.at(12), self.at(4))
+		self.SBNZ(b, ZERO, self.at(9), self.at(4))
+		self.SBNZ(a, ZERO, self.at(8), self.at(4))
+		self.Label(cmp)
+		self.SBNZ(maxAddress-1, maxAddress-1, res, done)
+		// equal, the end of both strings?
+		self.SBNZ(maxAddress-1, ZERO, JUNK, advance)
+		self.JMP(done)
+		self.Label(advance)
+		self.SBNZ(self.at(-12), self.minusWord(), self.at(-12), self.at(4))
+		self.SBNZ(self.at(-15), self.minusWord(), self.at(-15), self.at(4))
+		self.SBNZ(self.at(-16), self.minusWord(), self.at(-16), cmp)
+	})
+}
+
+// ------------------------------------------------------ conversions
+
+// ITOA stores the decimal representation of the value of a, preceded
+// by '-' if negative, as a string at buf, that must hold up to the
+// number of digits plus 2 words. Slow, each digit d takes d + 1 sign
+// tests: 0 takes 3927 steps, 12345 11728 and -32768 17963 with 16
+// bits words.
+func (self *Assembler) ITOA(a, buf Labeler) {
+	defer self.beginMacro("ITOA", a, buf)()
+	self.callLibrary("itoa", []Labeler{a, ref{buf}})
+}
+
+// powers return the powers of 10 fitting in a positive operand,
+// descending, but 1
+func (self *Assembler) powers() []uint32 {
+	max := uint64(self.config.MaxAddress()) >> 1
+	var res []uint32
+	for p := uint64(10); p <= max; p *= 10 {
+		res = append([]uint32{uint32(p)}, res...)
+	}
+	return res
+}
+
+// emitItoa emits the routine of ITOA
+func (self *Assembler) emitItoa() {
+	a, buf := param("itoa", 0), param("itoa", 1)
+	// the value is kept negative, -32768 has no positive counterpart
+	n, p, d, t, started, pp := self.uniqLabel(), self.uniqLabel(), self.uniqLabel(),
+		self.uniqLabel(), self.uniqLabel(), self.uniqLabel()
+	table := self.uniqLabel()
+	tableAddress := self.uniqLabel()
+	self.emitLibrary("itoa", 2, 0, []Label{n, p, d, t, started, pp}, func(done Label) {
+		negative := self.uniqLabel()
+		digits := self.uniqLabel()
+		loop := self.uniqLabel()
+		count := self.uniqLabel()
+		take := self.uniqLabel()
+		emit := self.uniqLabel()
+		leading := self.uniqLabel()
+		next := self.uniqLabel()
+		units := self.uniqLabel()
+
+		self.NEG(a, n)
+		self.BLTZ(a, negative)
+		self.JMP(digits)
+		self.Label(negative)
+		self.MOV(a, n)
+		self.store(Char('-'), buf)
+		self.advance(buf)
+		self.Label(digits)
+		self.MOV(ZERO, started)
+		self.MOV(tableAddress, pp)
+		// a digit per power of 10, the number of times it can be
+		// added to n without becoming positive
+		self.Label(loop)
+		self.load(pp, p)
+		self.BEQ(p, ZERO, units)
+		self.MOV(Char('0'), d)
+		self.Label(count)
+		self.ADD(n, p, t)
+		self.BLEZ(t, take)
+		self.BEQ(started, ZERO, leading)
+		self.Label(emit)
+		self.store(d, buf)
+		self.advance(buf)
+		self.MOV(ONE, started)
+		self.JMP(next)
+		self.Label(leading)
+		self.BEQ(d, Char('0'), next)
+		self.JMP(emit)
+		self.Label(take)
+		self.MOV(t, n)
+		self.INC(d)
+		self.JMP(count)
+		self.Label(next)
+		self.SBNZ(pp, self.minusWord(), pp, loop)
+		self.Label(units)
+		self.SUB(Char('0'), n, d)
+		self.store(d, buf)
+		self.advance(buf)
+		self.store(ZERO, buf)
+	})
+	self.Label(table)
+	self.DW(append(self.powers(), 0)...)
+	self.Label(tableAddress)
+	self.addresses(table)
+}
+
+// ATOI stores in dst the value of the decimal number in the string at
+// buf: an optional '-' followed by digits, up to the first character
+// not a digit. Overflows wrap around. Takes 27 steps plus k + 16 per
+// digit k, 2 more if negative.
+func (self *Assembler) ATOI(buf, dst Labeler) {
+	defer self.beginMacro("ATOI", buf, dst)()
+	self.callLibrary("atoi", []Labeler{ref{buf}}, dst)
+}
+
+// emitAtoi emits the routine of ATOI
+func (self *Assembler) emitAtoi() {
+	buf, res := param("atoi", 0), result("atoi", 0)
+	c, d, t, negative := self.uniqLabel(), self.uniqLabel(), self.uniqLabel(), self.uniqLabel()
+	self.emitLibrary("atoi", 1, 1, []Label{c, d, t, negative}, func(done Label) {
+		minus := self.uniqLabel()
+		digits := self.uniqLabel()
+		digit := self.uniqLabel()
+		end := self.uniqLabel()
+
+		// the value is accumulated negative, -32768 has no positive
+		// counterpart
+		self.MOV(ZERO, res)
+		self.MOV(ZERO, negative)
+		self.load(buf, c)
+		self.BEQ(c, Char('-'), minus)
+		self.JMP(digits)
+		self.Label(minus)
+		self.MOV(ONE, negative)
+		self.advance(buf)
+		self.Label(digits)
+		self.load(buf, c)
+		self.SUB(c, Char('0'), d)
+		for k := int32(0); k < 10; k++ {
+			self.BEQ(d, Imm(k), digit)
+		}
+		self.JMP(end)
+		self.Label(digit)
+		// res = res * 10 - d
+		self.ADD(res, res, t)
+		self.ADD(t, t, t)
+		self.ADD(t, res, t)
+		self.ADD(t, t, res)
+		self.SUB(res, d, res)
+		self.advance(buf)
+		self.JMP(digits)
+		self.Label(end)
+		self.BEQ(negative, ONE, done)
+		self.NEG(res, res)
+	})
+}
+
+// ------------------------------------------------------ arithmetic
+
+// ABS stores in dst the absolute value of a. The lowest value has no
+// positive counterpart and is left as is. About 780 steps with 16
+// bits words, see BLTZ.
+func (self *Assembler) ABS(a, dst Labeler) {
+	defer self.beginMacro("ABS", a, dst)()
+	self.callLibrary("abs", []Labeler{a}, dst)
+}
+
+// emitAbs emits the routine of ABS
+func (self *Assembler) emitAbs() {
+	a, res := param("abs", 0), result("abs", 0)
+	self.emitLibrary("abs", 1, 1, nil, func(done Label) {
+		negative := self.uniqLabel()
+		self.BLTZ(a, negative)
+		self.MOV(a, res)
+		self.JMP(done)
+		self.Label(negative)
+		self.NEG(a, res)
+	})
+}
+
+// MIN stores in dst the lowest of a and b. Takes two sign tests if
+// the signs differ, three if not: about 1550 or 2330 steps with 16
+// bits words, see BLTZ.
+func (self *Assembler) MIN(a, b, dst Labeler) {
+	defer self.beginMacro("MIN", a, b, dst)()
+	self.callLibrary("minmax", []Labeler{a, b}, dst)
+}
+
+// MAX stores in dst the highest of a and b, see MIN
+func (self *Assembler) MAX(a, b, dst Labeler) {
+	defer self.beginMacro("MAX", a, b, dst)()
+	self.callLibrary("minmax", []Labeler{a, b}, JUNK, dst)
+}
+
+// emitMinmax emits the routine of MIN and MAX, the results are the
+// lowest and the highest
+func (self *Assembler) emitMinmax() {
+	a, b := param("minmax", 0), param("minmax", 1)
+	min, max := result("minmax", 0), result("minmax", 1)
+	self.emitLibrary("minmax", 2, 2, nil, func(done Label) {
+		negative := self.uniqLabel()
+		same := self.uniqLabel()
+		aMin := self.uniqLabel()
+		bMin := self.uniqLabel()
+
+		// a - b overflows if the signs differ
+		self.BLTZ(a, negative)
+		self.BLTZ(b, bMin)
+		self.JMP(same)
+		self.Label(negative)
+		self.BLTZ(b, same)
+		self.JMP(aMin)
+		self.Label(same)
+		self.SUB(a, b, min)
+		self.BLTZ(min, aMin)
+		self.Label(bMin)
+		self.MOV(b, min)
+		self.MOV(a, max)
+		self.JMP(done)
+		self.Label(aMin)
+		self.MOV(a, min)
+		self.MOV(b, max)
+	})
+}
+
+// ------------------------------------------------------ random numbers
+
+// randState the state of the pseudo-random number generator
+const randState = Label("__rand_state")
+
+// randMultiplier the multiplier of the generator, truncated to the
+// word size
+const randMultiplier = 69069
+
+// RAND stores in dst the next number of a linear congruential
+// generator, state * 69069 + 1 truncated to the word size, with
+// period 2^bits. Not suitable for statistics, the lower bits have
+// short periods. Takes 44 steps with 16 bits words.
+func (self *Assembler) RAND(dst Labeler) {
+	defer self.beginMacro("RAND", dst)()
+	self.callLibrary("rand", nil, dst)
+}
+
+// SRAND sets the state of the generator of RAND to the value of seed,
+// 0 by default. Takes 1 step.
+func (self *Assembler) SRAND(seed Labeler) {
+	defer self.beginMacro("SRAND", seed)()
+	self.MOV(seed, randState)
+}
+
+// emitRand emits the routine of RAND and its state
+func (self *Assembler) emitRand() {
+	res := result("rand", 0)
+	self.emitLibrary("rand", 0, 1, []Label{randState}, func(done Label) {
+		// multiply, shifting and adding from the highest bit
+		m := uint32(randMultiplier) & uint32(self.config.MaxAddress())
+		self.MOV(randState, res)
+		for i := bits.Len32(m) - 2; i >= 0; i-- {
+			self.ADD(res, res, res)
+			if m&(1<<uint(i)) != 0 {
+				self.ADD(res, randState, res)
+			}
+		}
+		self.INC(res)
+		self.MOV(res, randState)
+	})
+}
@@ -0,0 +1,323 @@
+package assembler
+
+import (
+	"gosics/vm"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// t_call runs the program, with the memory map, until it halts.
+// Returns the computer and the steps executed from the label "call"
+// to the label "return".
+func t_call(t *testing.T, a *Assembler) (vm.Computer, int) {
+	c := vm.Computer{}
+	c.LoadMemory(a.Assemble())
+	c.SetMemoryMap(a.MemoryMap())
+	assert.Nil(t, a.Err())
+	call, ret := t_resolve(a, "call"), t_resolve(a, "return")
+	for i := 0; i < 100000 && c.IP() != call; i++ {
+		c.Step()
+	}
+	steps := 0
+	for ; steps < 100000 && c.IP() != ret; steps++ {
+		c.Step()
+	}
+	c.Run(100000)
+	assert.True(t, c.Halted())
+	assert.Nil(t, c.Fault())
+	return c, steps
+}
+
+// t_words return n words of the memory of c starting at the label l
+func t_words(c *vm.Computer, a *Assembler, l string, n int) []vm.Operand {
+	var res []vm.Operand
+	for i := 0; i < n; i++ {
+		res = append(res, c.Peek(t_resolve(a, l)+vm.Address(2*i)))
+	}
+	return res
+}
+
+func TestMEMCPY(t *testing.T) {
+	for n := 0; n < 4; n++ {
+		as := New()
+		as.Label("call")
+		as.MEMCPY(Label("SRC"), Label("DST"), Imm(n))
+		as.Label("return")
+		as.HLT()
+		as.Label("SRC")
+		as.DD(1, 2, 3, 4)
+		as.Label("DST")
+		as.DD(9, 9, 9, 9)
+
+		c, steps := t_call(t, &as)
+		expected := []vm.Operand{1, 2, 3, 9}[:n]
+		assert.Equal(t, append(expected, []vm.Operand{9, 9, 9, 9}[n:]...), t_words(&c, &as, "DST", 4))
+		if n == 0 {
+			assert.Equal(t, 10, steps)
+		} else {
+			assert.Equal(t, 4*n+9, steps)
+		}
+	}
+}
+
+func TestLibraryOptimized(t *testing.T) {
+	as := New(WithOptimization(true))
+	as.Label("call")
+	as.MEMCPY(Label("SRC"), Label("DST"), ONE)
+	as.Label("return")
+	as.HLT()
+	as.Label("SRC")
+	as.DD(1)
+	as.Label("DST")
+	as.DD(9)
+
+	c, steps := t_call(t, &as)
+	assert.Equal(t, vm.Operand(1), t_peek(&c, &as, "DST"))
+	assert.Equal(t, 12, steps)
+	assert.Empty(t, as.Lint())
+}
+
+func TestMEMSET(t *testing.T) {
+	for n := 0; n < 4; n++ {
+		as := New()
+		as.Label("call")
+		as.MEMSET(Label("DST"), Imm(-1), Imm(n))
+		as.Label("return")
+		as.HLT()
+		as.Label("DST")
+		as.DD(9, 9, 9, 9)
+
+		c, steps := t_call(t, &as)
+		expected := []vm.Operand{-1, -1, -1, 9}[:n]
+		assert.Equal(t, append(expected, []vm.Operand{9, 9, 9, 9}[n:]...), t_words(&c, &as, "DST", 4))
+		if n == 0 {
+			assert.Equal(t, 9, steps)
+		} else {
+			assert.Equal(t, 3*n+8, steps)
+		}
+	}
+}
+
+func TestSTRLEN(t *testing.T) {
+	for _, s := range []string{"", "a", "Hello, world"} {
+		as := New()
+		as.Label("call")
+		as.STRLEN(Label("S"), Label("N"))
+		as.Label("return")
+		as.HLT()
+		as.Label("S")
+		as.DS(s, ZeroTerminated)
+		as.Label("N")
+		as.DD(9)
+
+		c, steps := t_call(t, &as)
+		assert.Equal(t, vm.Operand(len(s)), t_peek(&c, &as, "N"), s)
+		assert.Equal(t, 3*len(s)+9, steps, s)
+	}
+}
+
+func TestSTRCMP(t *testing.T) {
+	for _, c := range []struct {
+		a, b     string
+		expected vm.Operand
+		steps    int
+	}{
+		{"", "", 0, 12},
+		{"abc", "abc", 0, 27},
+		{"abc", "abd", -1, 20},
+		{"abd", "abc", 1, 20},
+		{"ab", "abc", -'c', 20},
+		{"b", "abc", 1, 10},
+	} {
+		as := New()
+		as.Label("call")
+		as.STRCMP(Label("A"), Label("B"), Label("R"))
+		as.Label("return")
+		as.HLT()
+		as.Label("A")
+		as.DS(c.a, ZeroTerminated)
+		as.Label("B")
+		as.DS(c.b, ZeroTerminated)
+		as.Label("R")
+		as.DD(9)
+
+		computer, steps := t_call(t, &as)
+		assert.Equal(t, c.expected, t_peek(&computer, &as, "R"), "%q %q", c.a, c.b)
+		assert.Equal(t, c.steps, steps, "%q %q", c.a, c.b)
+	}
+}
+
+func TestITOA(t *testing.T) {
+	for _, c := range []struct {
+		value    int32
+		expected string
+		steps    int
+	}{
+		{0, "0", 3927},
+		{7, "7", 3927},
+		{10, "10", 3940},
+		{12345, "12345", 11728},
+		{-1, "-1", 3930},
+		{-32768, "-32768", 17963},
+		{32767, "32767", 17960},
+	} {
+		as := New()
+		as.Label("call")
+		as.ITOA(Imm(c.value), Label("BUF"))
+		as.Label("return")
+		as.HLT()
+		as.Label("BUF")
+		as.DS("xxxxxxxx")
+
+		computer, steps := t_call(t, &as)
+		var s []rune
+		for _, w := range t_words(&computer, &as, "BUF", len(c.expected)+1) {
+			s = append(s, rune(w))
+		}
+		assert.Equal(t, c.expected+"\x00", string(s))
+		assert.Equal(t, c.steps, steps, c.expected)
+	}
+}
+
+func TestATOI(t *testing.T) {
+	for _, c := range []struct {
+		text     string
+		expected vm.Operand
+		steps    int
+	}{
+		{"", 0, 27},
+		{"0", 0, 43},
+		{"9", 9, 52},
+		{"123x", 123, 81},
+		{"-45", -45, 70},
+		{"-32768", -32768, 135},
+		{"32767", 32767, 132},
+		{"-", 0, 29},
+	} {
+		as := New()
+		as.Label("call")
+		as.ATOI(Label("BUF"), Label("N"))
+		as.Label("return")
+		as.HLT()
+		as.Label("BUF")
+		as.DS(c.text, ZeroTerminated)
+		as.Label("N")
+		as.DD(9)
+
+		computer, steps := t_call(t, &as)
+		assert.Equal(t, c.expected, t_peek(&computer, &as, "N"), c.text)
+		assert.Equal(t, c.steps, steps, c.text)
+	}
+}
+
+func TestConversionsWordSize(t *testing.T) {
+	for _, value := range []int32{-5, 0, 1234567} {
+		as := New(WithConfig(vm.Config{WordSize: 32}))
+		as.ITOA(Imm(value), Label("BUF"))
+		as.ATOI(Label("BUF"), Label("N"))
+		as.HLT()
+		as.Label("BUF")
+		as.DS("xxxxxxxxxxxx")
+		as.Label("N")
+		as.DD(0)
+
+		c := vm.Computer{}
+		assert.Nil(t, c.SetConfig(vm.Config{WordSize: 32}))
+		c.LoadMemory(as.Assemble())
+		assert.Nil(t, as.Err())
+		c.Run(1000000)
+		assert.True(t, c.Halted())
+		assert.Equal(t, vm.Operand(value), t_peek(&c, &as, "N"), "%d", value)
+	}
+}
+
+func TestABS(t *testing.T) {
+	for _, c := range []struct {
+		value, expected int32
+		steps           int
+	}{
+		{0, 0, 779},
+		{5, 5, 779},
+		{-5, 5, 778},
+		{-32768, -32768, 778},
+	} {
+		as := New()
+		as.Label("call")
+		as.ABS(Imm(c.value), Label("R"))
+		as.Label("return")
+		as.HLT()
+		as.Label("R")
+		as.DD(9)
+
+		computer, steps := t_call(t, &as)
+		assert.Equal(t, vm.Operand(c.expected), t_peek(&computer, &as, "R"), "%d", c.value)
+		assert.Equal(t, c.steps, steps, "%d", c.value)
+	}
+}
+
+func TestMINMAX(t *testing.T) {
+	for _, c := range []struct {
+		a, b  int32
+		steps int
+	}{
+		{1, 2, 2326},
+		{2, 1, 2327},
+		{3, 3, 2327},
+		{-3, 2, 1553},
+		{2, -3, 1553},
+		{-32768, 32767, 1553},
+		{32767, -32768, 1553},
+		{-5, -7, 2326},
+	} {
+		min, max := c.a, c.b
+		if min > max {
+			min, max = max, min
+		}
+		as := New()
+		as.Label("call")
+		as.MIN(Imm(c.a), Imm(c.b), Label("MIN"))
+		as.Label("return")
+		as.MAX(Imm(c.a), Imm(c.b), Label("MAX"))
+		as.HLT()
+		as.Label("MIN")
+		as.DD(9)
+		as.Label("MAX")
+		as.DD(9)
+
+		computer, steps := t_call(t, &as)
+		assert.Equal(t, vm.Operand(min), t_peek(&computer, &as, "MIN"), "%d %d", c.a, c.b)
+		assert.Equal(t, vm.Operand(max), t_peek(&computer, &as, "MAX"), "%d %d", c.a, c.b)
+		assert.Equal(t, c.steps, steps, "%d %d", c.a, c.b)
+	}
+}
+
+func TestRAND(t *testing.T) {
+	as := New()
+	as.SRAND(Imm(1))
+	as.Label("call")
+	as.RAND(Label("X"))
+	as.Label("return")
+	as.RAND(Label("Y"))
+	as.HLT()
+	as.Label("X")
+	as.DD(0)
+	as.Label("Y")
+	as.DD(0)
+
+	c, steps := t_call(t, &as)
+	x := uint16(69069 & 0xFFFF)
+	x = x*1 + 1
+	assert.Equal(t, vm.Operand(int16(x)), t_peek(&c, &as, "X"))
+	x = x*uint16(69069&0xFFFF) + 1
+	assert.Equal(t, vm.Operand(int16(x)), t_peek(&c, &as, "Y"))
+	assert.Equal(t, 44, steps)
+}
+
+func TestLibraryRuntimeObject(t *testing.T) {
+	obj, err := RuntimeObject(vm.Config{}, "__strlen")
+	assert.Nil(t, err)
+	for _, l := range []Label{"__strlen", "__strlen_0", "__strlen_r0", "__strlen_ret"} {
+		assert.Contains(t, obj.Exports, l)
+	}
+}
@@ -0,0 +1,170 @@
+package assembler
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// position a position in the program's source code, the Go code
+// calling the assembler
+type position struct {
+	file string
+	line int
+}
+
+// dir the directory of the assembler's source code
+var dir = func() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Dir(file)
+}()
+
+// caller return the position of the innermost call from outside the
+// assembler, the program's source code
+func caller() position {
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		f, more := frames.Next()
+		if filepath.Dir(f.File) != dir || strings.HasSuffix(f.File, "_test.go") {
+			return position{f.File, f.Line}
+		}
+		if !more {
+			return position{}
+		}
+	}
+}
+
+// where return the position in the program's source code of the code
+// being emitted, the line of the assembler source if any, see Source.
+// Unknown for the Go code unless WithLint.
+func (self *Assembler) where() position {
+	if self.line != nil {
+		return *self.line
+	}
+	if !self.lint {
+		return position{}
+	}
+	return caller()
+}
+
+// Diagnostic a probable mistake found by Lint
+type Diagnostic struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// String return the diagnostic as file:line: message
+func (self Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d: %s", self.File, self.Line, self.Message)
+}
+
+// source return the text of the top level instruction or directive
+// emitting the record
+func (self *record) source() string {
+	if self.macro != "" {
+		return self.macro
+	}
+	return self.text
+}
+
+// jumps return true if the record is an instruction subtracting
+// ZERO from ONE, it always branches
+func (self *record) jumps() bool {
+	return self.operands != nil && self.operands[0] == Labeler(ONE) && self.operands[1] == Labeler(ZERO)
+}
+
+// Lint assembles the program and returns the probable mistakes found
+// in the instructions and labels, sorted by position in the source
+// code:
+//
+// - code after an unconditional jump, like JMP or HLT, without a
+// label pointing to it, it's unreachable.
+//
+// - labels defined but never referenced, but the exported ones.
+//
+// - writes to __ONE, __ZERO or the constant pool.
+//
+// - reads of __JUNK not written before by the same macro instruction,
+// every macro instruction may overwrite it.
+//
+// - instructions falling through into data.
+//
+// - branches to labels pointing to data.
+//
+// The positions are the calls emitting the code, recorded WithLint, or
+// the lines of the source, see Source. Macro instructions
+// are reported as a whole and trusted to be correct inside. The preamble and the runtime routines
+// aren't checked.
+func (self *Assembler) Lint() []Diagnostic {
+	self.image()
+	var res []Diagnostic
+	report := func(src position, format string, args ...interface{}) {
+		res = append(res, Diagnostic{src.file, src.line, fmt.Sprintf(format, args...)})
+	}
+
+	at := make(map[Address]*record) // records by position in memory
+	for i := range self.records {
+		r := &self.records[i]
+		if r.size > 0 {
+			at[r.pos] = r
+		}
+	}
+	labeled := make(map[Address]bool)
+	for _, p := range self.label_pos {
+		labeled[p] = true
+	}
+
+	junk := make(map[int]bool) // expansions writing JUNK
+	for i := range self.records {
+		r := &self.records[i]
+		if r.internal || r.comment || r.operands == nil {
+			continue
+		}
+		a, b, c, d := r.operands[0], r.operands[1], r.operands[2], r.operands[3]
+		if constant(c) {
+			report(r.src, "%s writes to the constant %s", r.source(), c)
+		}
+		if a != b && (a == Labeler(JUNK) || b == Labeler(JUNK)) && (r.call == 0 || !junk[r.call]) {
+			report(r.src, "%s reads %s written outside the instruction", r.source(), JUNK)
+		}
+		if c == Labeler(JUNK) && r.call != 0 {
+			junk[r.call] = true
+		}
+		next := at[r.pos+r.size]
+		switch {
+		case r.jumps():
+			inside := r.call != 0 && next != nil && next.call == r.call // returning from the runtime
+			if next != nil && next.operands != nil && !next.internal && !labeled[next.pos] && !inside {
+				report(next.src, "%s is unreachable, follows %s", next.source(), r.source())
+			}
+		case next != nil && next.operands == nil:
+			report(r.src, "%s falls through into data at %04x", r.source(), uint32(next.address))
+		}
+		if l, ok := d.(Label); ok && a != b {
+			if p, ok := self.label_pos[l]; ok && at[p] != nil && at[p].operands == nil {
+				report(r.src, "%s branches to the data label %s", r.source(), l)
+			}
+		}
+	}
+
+	for l, src := range self.label_src {
+		if !self.used[l] && !self.exports[l] {
+			report(src, "label %s defined but never referenced", l)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].File != res[j].File {
+			return res[i].File < res[j].File
+		}
+		if res[i].Line != res[j].Line {
+			return res[i].Line < res[j].Line
+		}
+		return res[i].Message < res[j].Message
+	})
+	return res
+}
@@ -0,0 +1,134 @@
+package assembler
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// t_line return the line of the caller
+func t_line() int {
+	_, _, line, _ := runtime.Caller(1)
+	return line
+}
+
+// t_lint return the diagnostics as line: message
+func t_lint(t *testing.T, as *Assembler) map[int]string {
+	res := make(map[int]string)
+	for _, d := range as.Lint() {
+		assert.Equal(t, "lint_test.go", filepath.Base(d.File))
+		res[d.Line] = d.Message
+	}
+	return res
+}
+
+func TestLintCleanProgram(t *testing.T) {
+	as := New(WithLint(true))
+	as.PUSH(Label("SRC"))
+	as.POP(Label("DST"))
+	as.BLTZ(Label("DST"), Label("negative"))
+	as.ADD(Label("SRC"), Label("DST"), Label("DST"))
+	as.BEQ(Label("DST"), ZERO, Label("negative"))
+	as.NOP()
+	as.HLT()
+	as.Label("negative")
+	as.NOT(Label("DST"), Label("DST"))
+	as.HLT()
+	as.Label("SRC")
+	as.DD(0x1234)
+	as.Label("DST")
+	as.DD(0)
+
+	assert.Empty(t, as.Lint())
+}
+
+func TestLintUnreachableCode(t *testing.T) {
+	as := New(WithLint(true))
+	as.JMP(Label("end"))
+	line := t_line() + 1
+	as.INC(Label("X"))
+	as.DEC(Label("X"))
+	as.Label("end")
+	as.HLT()
+	as.Label("X")
+	as.DD(0)
+
+	assert.Equal(t, map[int]string{line: "INC X is unreachable, follows JMP end"}, t_lint(t, &as))
+}
+
+func TestLintUnusedLabels(t *testing.T) {
+	as := New(WithLint(true))
+	line := t_line() + 1
+	as.Label("unused")
+	as.Label("exported")
+	as.Export("exported")
+	as.HLT()
+
+	assert.Equal(t, map[int]string{line: "label unused defined but never referenced"}, t_lint(t, &as))
+}
+
+func TestLintWritesToConstants(t *testing.T) {
+	as := New(WithLint(true))
+	line := t_line() + 1
+	as.MOV(Label("X"), ONE)
+	as.HLT()
+	as.Label("X")
+	as.DD(0)
+
+	assert.Equal(t, map[int]string{line: "MOV X, __ONE writes to the constant __ONE"}, t_lint(t, &as))
+}
+
+func TestLintJunkAcrossMacros(t *testing.T) {
+	as := New(WithLint(true))
+	as.NEG(Label("X"), JUNK)
+	line := t_line() + 1
+	as.MOV(JUNK, Label("X"))
+	as.HLT()
+	as.Label("X")
+	as.DD(0)
+
+	assert.Equal(t, map[int]string{line: "MOV __JUNK, X reads __JUNK written outside the instruction"}, t_lint(t, &as))
+}
+
+func TestLintFallThroughIntoData(t *testing.T) {
+	as := New(WithLint(true))
+	line := t_line() + 1
+	as.INC(Label("X"))
+	as.Label("X")
+	as.DD(0)
+
+	assert.Equal(t, map[int]string{line: "INC X falls through into data at 001e"}, t_lint(t, &as))
+}
+
+func TestLintBranchToData(t *testing.T) {
+	as := New(WithLint(true))
+	line := t_line() + 1
+	as.BEQ(Label("X"), ZERO, Label("X"))
+	as.HLT()
+	as.Label("X")
+	as.DD(0)
+
+	assert.Equal(t, map[int]string{line: "BEQ X, __ZERO, X branches to the data label X"}, t_lint(t, &as))
+}
+
+func TestLintModules(t *testing.T) {
+	as := NewModule(WithLint(true))
+	as.Label("main")
+	as.Export("main")
+	as.JMP(Label("imported"))
+
+	assert.Empty(t, as.Lint())
+}
+
+func TestLintWithoutPositions(t *testing.T) {
+	as := New()
+	as.HLT()
+	as.INC(Label("X"))
+	as.HLT()
+	as.Label("X")
+	as.DD(0)
+
+	assert.Equal(t, []Diagnostic{{"", 0, "INC X is unreachable, follows HLT"}}, as.Lint())
+}
@@ -6,29 +6,34 @@
 // - stores SBNZ instructions, maybe with unresolved references to
 // addresses
 //
-// - provides directives DB and DD to store data in memory
+// - provides directives DB, DD and DS to store data and text in
+// memory
 //
 // Example:
-//    ass := assembler.New()
 //
-//    OP1 := assembler.Label("OP1")
-//    OP2 := assembler.Label("OP2")
-//    DST := assembler.Label("DST")
+//	ass := assembler.New()
 //
-//    ass.SBNZ(OP1, OP2, DST, assembler.HLT)
-//    ass.Label(OP1)
-//    ass.DD(0x01)
-//    ass.Label(OP2)
-//    ass.DD(0x02)
-//    ass.Label(DST)
-//    ass.DD(0x00)
+//	OP1 := assembler.Label("OP1")
+//	OP2 := assembler.Label("OP2")
+//	DST := assembler.Label("DST")
 //
+//	ass.SBNZ(OP1, OP2, DST, assembler.HLT)
+//	ass.Label(OP1)
+//	ass.DD(0x01)
+//	ass.Label(OP2)
+//	ass.DD(0x02)
+//	ass.Label(DST)
+//	ass.DD(0x00)
 package assembler
 
 import (
+	"bytes"
 	"container/list"
 	"fmt"
 	"gosics/vm"
+	"io"
+	"sort"
+	"strings"
 )
 
 // Label symbolic name for an address
@@ -37,11 +42,18 @@ type Label string
 // Address type for adresses
 type Address vm.Address
 
+// maxAddress the highest address in the default configuration
 const maxAddress = Address(vm.MaxAddress)
 
-// HLT is the address to jump to in order to halt the computer
+// HLT is the address to jump to in order to halt the computer. It's
+// the highest address of the default configuration, assembled as the
+// highest address of the configuration being used.
 const HLT = maxAddress
 
+// DefaultLabelPrefix the prefix of the labels generated by the macro
+// instructions, see WithLabelPrefix
+const DefaultLabelPrefix = "__label_"
+
 // ONE is a label to a memory position containing a 1
 const ONE = Label("__ONE")
 
@@ -56,29 +68,129 @@ const JUNK = Label("__JUNK")
 
 // Assembler in memory assembler
 type Assembler struct {
-	ip         Address
-	labels     map[Label]Address
-	unresolved map[Label]*list.List
-	memory     [vm.MemorySize]uint8
-	label_cnt  int
+	ip           Address
+	labels       map[Label]Address
+	unresolved   map[Label]*list.List
+	memory       []uint8
+	label_cnt    int
+	records      []record
+	macro        string // top level macro instruction being expanded
+	macro_junk   bool   // JUNK is an argument of the macro instruction
+	macro_cnt    int
+	depth        int
+	relocs       []Address // positions holding addresses within the program
+	exports      map[Label]bool
+	runtime      bool // emit the runtime routines when assembling
+	routines     map[string]bool
+	stack        Stack
+	origin       Address
+	constants    bool
+	preamble     Preamble
+	label_prefix string
+	config       vm.Config
+	errs         []error
+	// banked memory, see Bank
+	banks     *vm.Banks
+	bank      string             // bank being assembled, "" for the main memory
+	bank_ips  map[string]Address // IP of each bank when not being assembled
+	bank_nums map[string]int
+	delta     Address           // position in memory of IP minus IP
+	label_pos map[Label]Address // position in memory of the labels
+	// memory map, see MemoryMap
+	regions        vm.MemoryMap
+	self_modifying bool
+	read_only      bool
+	instructions   []instruction  // see Warnings
+	ints           *vm.Interrupts // timer interrupt, see WithInterrupts
+	// source positions, see Lint
+	internal  bool     // emitting the preamble or the runtime routines
+	src       position // of the top level macro instruction
+	label_src map[Label]position
+	line      *position      // being assembled, see Source
+	used      map[Label]bool // labels referenced
+	optimize  bool           // see WithOptimization
+	lint      bool           // record the callers, see WithLint
+	pool      map[Label]Imm  // constant pool, see Imm
+	assembled *assembly      // see image, discarded when emitting
+}
+
+// assembly the program assembled, kept until more code or labels are
+// emitted so that Assemble and the rest of the accessors don't
+// assemble it again
+type assembly struct {
+	image   []uint8
+	symbols vm.SymbolTable
+	listing []byte
+}
+
+// record keeps track of a chunk of memory emitted by a directive or
+// an opcode. Used to build the listing.
+type record struct {
+	address Address
+	pos     Address // position in memory, differs from address in banks
+	size    Address
+	text    string
+	macro   string // macro instruction that emitted the chunk, if any
+	call    int    // identifies each expansion of a macro instruction
+	comment bool   // shown as a comment, doesn't emit memory
+	// see Lint
+	operands []Labeler // of the SBNZ instructions, nil for data
+	data     []Labeler // of the addresses emitted as data
+	scratch  bool      // emitted by a macro instruction not given JUNK
+	src      position  // in the program's source code
+	internal bool
 }
 
-// The labeler interface is provided by all types that can be used as
+// The Labeler interface is provided by all types that can be used as
 // addresses in an assembler program, either literal addresses
 // (Address) or symbolic addresses (Label).
-type labeler interface {
+type Labeler interface {
 	getAddress(a *Assembler) Address
+	String() string
 }
 
-// getAddress return the address for a literal address
+// getAddress return the address for a literal address, HLT is the
+// highest address of the configuration
 func (self Address) getAddress(a *Assembler) Address {
+	if self == HLT {
+		return Address(a.config.MaxAddress())
+	}
 	return self
 }
 
+// String return the address in hexadecimal, HLT by name
+func (self Address) String() string {
+	if self == HLT {
+		return "HLT"
+	}
+	return fmt.Sprintf("0x%04X", uint32(self))
+}
+
+// String return the name of the label
+func (self Label) String() string {
+	return string(self)
+}
+
+// local is an address within the program being assembled. Macro
+// instructions use it, instead of a literal Address, for addresses
+// computed from the IP so that they can be relocated.
+type local Address
+
+// getAddress return the address
+func (self local) getAddress(a *Assembler) Address {
+	return Address(self)
+}
+
+// String return the address in hexadecimal
+func (self local) String() string {
+	return Address(self).String()
+}
+
 // getAddress perform a lookup for the label in the label table and
 // return the corresponding address. If the label is not found adds it
 // to the unresolved-labels table and return a fake address.
 func (self Label) getAddress(a *Assembler) Address {
+	a.used[self] = true
 	address, ok := a.labels[self]
 	if ok {
 		return address
@@ -88,135 +200,481 @@ func (self Label) getAddress(a *Assembler) Address {
 		l = list.New()
 		a.unresolved[self] = l
 	}
-	l.PushBack(a.ip)
+	l.PushBack(a.pos())
 	return Address(vm.MaxAddress)
 }
 
-// New create a new Assembler instance and initializes internal
-// structures. Don't create an Assembler directly!!
-func New() Assembler {
+// newAssembler create an empty Assembler
+func newAssembler() Assembler {
 	ass := Assembler{}
 	ass.labels = make(map[Label]Address)
 	ass.unresolved = make(map[Label]*list.List)
-	start := Label("__start")
-	ass.SBNZ(ONE, ZERO, JUNK, start)
-
-	ass.Label(ONE)
-	ass.DD(1)
-	ass.Label(ZERO)
-	ass.DD(0)
-	ass.Label(JUNK)
-	ass.DD(0)
-
-	ass.Label(Label("__push_operand"))
-	ass.DD(0xFABA)
-	ass.Label(Label("__SP"))
-	ass.DD(uint16(maxAddress - 1))
-	ass.Label(Label("__push"))
-	// copy SP in the C parameter of the next instruction
-	ass.SBNZ(Label("__SP"), ZERO, ass.ip+12, ass.ip+8)
-	// copy value from __push_operand to the stack. The C operand has
-	// been overwriten so that it point to the top of the stack
-	ass.SBNZ(Label("__push_operand"), ZERO, maxAddress-1, ass.ip+8)
-	// decrease the stack pointer twice
-	ass.SBNZ(Label("__SP"), ONE, Label("__SP"), ass.ip+8)
-	ass.SBNZ(Label("__SP"), ONE, Label("__SP"), ass.ip+8)
-	// "return" to the caller. He caller must copy in __push_ret the
-	// return address
-	ass.DD(uint16(ass.labels[ONE]), uint16(ass.labels[ZERO]), uint16(ass.labels[JUNK]))
-	ass.Label(Label("__push_ret"))
-	ass.DD(uint16(0xFFFF))
-
-	ass.Label(Label("__pop"))
-	// increase the stack pointer twice, first we need -1 (SP - -1 ==
-	// SP + 1)
-	ass.SBNZ(ZERO, ONE, JUNK, ass.ip+8)
-	ass.SBNZ(Label("__SP"), JUNK, Label("__SP"), ass.ip+8)
-	ass.SBNZ(Label("__SP"), JUNK, Label("__SP"), ass.ip+8)
-	// copy SP in the A parameter of the next instruction
-	ass.SBNZ(Label("__SP"), ZERO, ass.ip+8, ass.ip+8)
-	// copy the value from the stack to __push_operand
-	ass.SBNZ(maxAddress-1, ZERO, Label("__push_operand"), ass.ip+8)
-	// return to the "caller"
-	ass.DD(uint16(ass.labels[ONE]), uint16(ass.labels[ZERO]), uint16(ass.labels[JUNK]))
-	ass.Label(Label("__pop_ret"))
-	ass.DD(uint16(0xFFFF))
+	ass.exports = make(map[Label]bool)
+	ass.routines = make(map[string]bool)
+	ass.bank_ips = make(map[string]Address)
+	ass.bank_nums = map[string]int{"": 0}
+	ass.label_pos = make(map[Label]Address)
+	ass.label_src = make(map[Label]position)
+	ass.used = make(map[Label]bool)
+	ass.pool = make(map[Label]Imm)
+	ass.stack = DefaultStack
+	ass.constants = true
+	ass.label_prefix = DefaultLabelPrefix
+	return ass
+}
+
+// configure applies the options, errors are reported by Err
+func (self *Assembler) configure(opts []Option) {
+	for _, opt := range opts {
+		if err := opt(self); err != nil {
+			self.errs = append(self.errs, err)
+		}
+	}
+	if self.stack == DefaultStack {
+		self.stack = defaultStack(self.config)
+	}
+}
 
+// NewModule create a new Assembler for a module, a part of a program
+// intended to be linked with other modules. Unlike New it doesn't
+// insert the preamble, references to the reserved labels are
+// resolved when linking. Accepts the same options as New, the
+// options affecting the preamble are ignored.
+func NewModule(opts ...Option) Assembler {
+	ass := newAssembler()
+	ass.configure(opts)
+	// provided by the preamble when linking
+	ass.routines["constants"] = true
+	return ass
+}
+
+// New create a new Assembler instance and initializes internal
+// structures. Don't create an Assembler directly!! The options allow
+// to customize the memory layout, see Option.
+func New(opts ...Option) Assembler {
+	ass := newAssembler()
+	ass.runtime = true
+	ass.configure(opts)
+	if !ass.constants {
+		// never emit them, the program must define the labels
+		ass.routines["constants"] = true
+	}
+	ass.ip = ass.origin
+	start := Label("__start")
+	ass.internal = true
+	if ass.preamble == JumpPreamble {
+		ass.SBNZ(ONE, ZERO, JUNK, start)
+		if ass.constants {
+			ass.emitConstants()
+		}
+	}
 	ass.Label(start)
+	ass.internal = false
+	ass.Export(start)
+	if ass.constants {
+		ass.Export(ONE, ZERO, JUNK)
+	}
 	return ass
 }
 
+// word return the size of a word, in bytes
+func (self *Assembler) word() Address {
+	return Address(self.config.Bytes())
+}
+
+// at return the address n words after IP, or before if n is
+// negative. Intended for macro instructions referencing their own
+// code, 4 words per instruction.
+func (self *Assembler) at(n int) local {
+	return local(int(self.ip) + n*int(self.word()))
+}
+
+// pos return the position in memory of IP. Differs from IP when
+// assembling a bank, banks are stored after the main memory.
+func (self *Assembler) pos() Address {
+	return self.ip + self.delta
+}
+
+// emit stores the bytes at IP, updates IP
+func (self *Assembler) emit(bytes ...uint8) {
+	if end := int(self.pos()) + len(bytes); end > len(self.memory) {
+		self.memory = append(self.memory, make([]uint8, end-len(self.memory))...)
+	}
+	copy(self.memory[self.pos():], bytes)
+	self.ip += Address(len(bytes))
+	self.assembled = nil
+}
+
 // uniqLabel create a unique label. It's intended to be used in macro
 // instructions that use other macro instructions and need to branch.
 // Avoids the requirement of knowing before hand how much instructions
 // to skip.
 func (self *Assembler) uniqLabel() Label {
 	self.label_cnt++
-	label := Label(fmt.Sprintf("__label_%04d", self.label_cnt))
+	label := Label(fmt.Sprintf("%s%04d", self.label_prefix, self.label_cnt))
 	return label
 }
 
+// generated return true if the label has been created by uniqLabel
+func (self *Assembler) generated(l Label) bool {
+	return strings.HasPrefix(string(l), self.label_prefix)
+}
+
 // Label define a label pointing to the current IP.
 // TODO: maybe the argument can be just a string
 func (self *Assembler) Label(label Label) {
 	self.labels[label] = self.ip
+	self.label_pos[label] = self.pos()
+	self.assembled = nil
+	if self.depth == 0 && !self.internal {
+		self.label_src[label] = self.where()
+	}
+}
+
+// Export makes the labels visible to other modules when linking
+func (self *Assembler) Export(labels ...Label) {
+	for _, l := range labels {
+		self.exports[l] = true
+	}
 }
 
 // TODO: define methods GetStorage and FreeStorage for allocating
 // temporary storage in a stack. Intended to be used for macro
 // instructions that require temporary storage.
 
+// beginMacro marks the start of the expansion of a macro instruction
+// and returns a function that marks its end, intended to be deferred.
+// Only top level macro instructions are shown in the listing, the
+// ones used to implement other macros are hidden.
+func (self *Assembler) beginMacro(name string, args ...Labeler) func() {
+	self.depth++
+	if self.depth == 1 {
+		names := make([]string, len(args))
+		for i, a := range args {
+			names[i] = a.String()
+		}
+		self.macro = strings.TrimSpace(name + " " + strings.Join(names, ", "))
+		self.macro_cnt++
+		self.macro_junk = false
+		for _, a := range args {
+			self.macro_junk = self.macro_junk || a == Labeler(JUNK)
+		}
+		if !self.internal {
+			self.src = self.where()
+		}
+	}
+	return func() {
+		self.depth--
+		if self.depth == 0 {
+			self.macro = ""
+		}
+	}
+}
+
+// record annotates the memory emitted since address start, the
+// operands of the SBNZ instructions are kept for Lint
+func (self *Assembler) record(start Address, text string, operands ...Labeler) {
+	r := record{address: start, pos: start + self.delta, size: self.ip - start, text: text,
+		operands: operands, internal: self.internal}
+	switch {
+	case self.depth > 0:
+		r.macro = self.macro
+		r.call = self.macro_cnt
+		r.src = self.src
+		r.scratch = !self.macro_junk
+	case !self.internal:
+		r.src = self.where()
+	}
+	self.records = append(self.records, r)
+}
+
 // Assemble resolves unresolved program addresses and retuns a valid
-// program.
+// program. The runtime routines used by the program, and the
+// constant pool, are emitted at the end of the program. The contents of the banks are returned by
+// Banks.
 func (self *Assembler) Assemble() []uint8 {
-	res := make([]uint8, self.ip)
-	copy(res, self.memory[:self.ip])
+	return append([]uint8(nil), self.image()[:self.ip]...)
+}
+
+// image assembles the program and returns the memory, including the
+// banks. The program is assembled once, until more is emitted, the
+// memory returned must not be modified.
+func (self *Assembler) image() []uint8 {
+	if self.bank != "" {
+		self.Bank("")
+	}
+	if self.assembled != nil {
+		return self.assembled.image
+	}
+	if self.runtime {
+		self.emitRuntime()
+	}
+	self.emitPool()
+	self.peephole()
+	res := make([]uint8, len(self.memory))
+	if len(res) < int(self.ip) {
+		res = make([]uint8, self.ip)
+	}
+	copy(res, self.memory)
 	for lab, lst := range self.unresolved {
-		// TODO: manage undefined labels
+		// undefined labels are reported by Err
 		a := self.labels[lab]
-		ah := uint8(a >> 8)
-		al := uint8(a & 0xFF)
 		for e := lst.Front(); e != nil; e = e.Next() {
-			i := e.Value.(Address)
-			res[i] = ah
-			res[i+1] = al
+			self.config.PutWord(res[e.Value.(Address):], vm.Address(a))
 		}
 	}
+	self.assembled = &assembly{image: res}
 	return res
 }
 
+// Err return the errors found while assembling: invalid options,
+// labels referenced but never defined, programs too big for the
+// memory and programs overlapping the stack they use. Intended to be
+// called after Assemble. For modules undefined labels aren't errors,
+// they are imported.
+func (self *Assembler) Err() error {
+	errs := append(errorList{}, self.errs...)
+	if uint64(self.ip) > self.config.MemorySize() {
+		errs = append(errs, fmt.Errorf("program too big"))
+	}
+	// the stack and the word below, see Stack.Guard, may start below 0
+	word := int64(self.word())
+	limit := int64(self.stack.Base) - word*int64(self.stack.Size)
+	if self.routines["stack"] && limit < int64(self.ip) && int64(self.stack.Base)+word > int64(self.origin) {
+		errs = append(errs, fmt.Errorf("stack overlaps the program"))
+	}
+	errs = append(errs, self.bankErrors()...)
+	if self.runtime {
+		var undefined []string
+		for l := range self.unresolved {
+			if _, ok := self.labels[l]; !ok {
+				undefined = append(undefined, string(l))
+			}
+		}
+		sort.Strings(undefined)
+		for _, l := range undefined {
+			errs = append(errs, fmt.Errorf("undefined label %s", l))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// errorList several errors
+type errorList []error
+
+func (self errorList) Error() string {
+	msgs := make([]string, len(self))
+	for i, e := range self {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Symbols assembles the program and return its symbol table
+func (self *Assembler) Symbols() vm.SymbolTable {
+	self.image()
+	if self.assembled.symbols == nil {
+		symbols := make(map[string]vm.Address, len(self.labels))
+		for l, a := range self.labels {
+			symbols[string(l)] = vm.Address(a)
+		}
+		self.assembled.symbols = vm.NewSymbolTable(symbols)
+	}
+	return append(vm.SymbolTable(nil), self.assembled.symbols...)
+}
+
+// Listing assembles the program and writes a listing to w. Each line
+// contains the address, the contents of memory and the source code
+// that generated them, in the form:
+//
+//	                           __start:
+//	                           ; MOV OP1, CNT
+//	006a  00b2 000a 00b8 0072    SBNZ OP1, __ZERO, CNT, __label_0001
+//
+// Labels are shown before the first line at their address and macro
+// instructions before the code they expand to. Labels generated by
+// the macro instructions are omitted.
+func (self *Assembler) Listing(w io.Writer) error {
+	program := self.image()
+	if self.assembled.listing == nil {
+		var buf bytes.Buffer
+		self.listing(&buf, program)
+		self.assembled.listing = buf.Bytes()
+	}
+	_, err := w.Write(self.assembled.listing)
+	return err
+}
+
+// listing writes the listing of the assembled program
+func (self *Assembler) listing(w io.Writer, program []uint8) {
+	// labels by position, banks may reuse addresses
+	labels := make(map[Address][]string)
+	for l, a := range self.label_pos {
+		if !self.generated(l) {
+			labels[a] = append(labels[a], string(l))
+		}
+	}
+	// an instruction, 4 words, per line
+	word := self.word()
+	digits := 2 * int(word)
+	if digits < 4 {
+		digits = 4
+	}
+	columns := 4*(2*int(word)+1) - 1
+	margin := digits + 2 + columns + 2
+	printLabels := func(a Address) {
+		names := labels[a]
+		sort.Strings(names)
+		for _, l := range names {
+			fmt.Fprintf(w, "%*s%s:\n", margin, "", l)
+		}
+		delete(labels, a)
+	}
+	call := 0
+	for _, r := range self.records {
+		if r.comment {
+			fmt.Fprintf(w, "%*s; %s\n", margin, "", r.text)
+			continue
+		}
+		printLabels(r.pos)
+		indent := ""
+		if r.call != 0 {
+			indent = "  "
+			if r.call != call {
+				fmt.Fprintf(w, "%*s; %s\n", margin, "", r.macro)
+			}
+		}
+		call = r.call
+		text := indent + r.text
+		for offset := Address(0); offset < r.size; offset += 4 * word {
+			var words []string
+			for i := offset; i < offset+4*word && i < r.size; i += word {
+				var hex strings.Builder
+				for j := r.pos + i; j < r.pos+i+word && j < r.pos+r.size; j++ {
+					fmt.Fprintf(&hex, "%02x", program[j])
+				}
+				words = append(words, hex.String())
+			}
+			line := fmt.Sprintf("%0*x  %-*s  %s", digits, uint32(r.address+offset), columns, strings.Join(words, " "), text)
+			fmt.Fprintf(w, "%s\n", strings.TrimRight(line, " "))
+			text = ""
+		}
+	}
+	// labels pointing past the last record
+	rest := make([]Address, 0, len(labels))
+	for a := range labels {
+		rest = append(rest, a)
+	}
+	sort.Slice(rest, func(i, j int) bool { return rest[i] < rest[j] })
+	for _, a := range rest {
+		printLabels(a)
+	}
+}
+
 //////////////////////////////////////////////////////////////////////////
 // Assembler directives
 
 // DB insert a sequence of bytes into memory at IP, updates IP
 func (self *Assembler) DB(bytes ...uint8) {
-	for _, b := range bytes {
-		self.memory[self.ip] = b
-		self.ip++
+	start := self.ip
+	self.emit(bytes...)
+	self.record(start, "DB "+formatValues("0x%02X", len(bytes), func(i int) uint { return uint(bytes[i]) }))
+	self.protect(start, dataRegion)
+}
+
+// addresses insert a sequence of addresses into memory at IP,
+// updates IP. Unlike DD it accepts labels.
+func (self *Assembler) addresses(addrs ...Labeler) {
+	start := self.ip
+	names := make([]string, len(addrs))
+	for i, a := range addrs {
+		self.emitAddress(a)
+		names[i] = a.String()
+	}
+	self.record(start, "DD "+strings.Join(names, " "))
+	self.records[len(self.records)-1].data = addrs
+	self.protect(start, dataRegion)
+}
+
+// emitAddress store the address of v at IP, updates IP. Keeps track of
+// the positions holding addresses within the program, they must be
+// relocated when linking.
+func (self *Assembler) emitAddress(v Labeler) {
+	switch v.(type) {
+	case Address:
+	default:
+		self.relocs = append(self.relocs, self.pos())
 	}
+	self.emitWord(uint32(v.getAddress(self)))
 }
 
-// DD insert a sequence of 16 bits values into memory at IP, updates
-// IP
+// emitWord store the word at IP, according to the configuration,
+// updates IP
+func (self *Assembler) emitWord(w uint32) {
+	b := make([]uint8, self.word())
+	self.config.PutWord(b, vm.Address(w))
+	self.emit(b...)
+}
+
+// DD insert a sequence of 16 bits words into memory at IP, updates
+// IP. The size of the words depends on the configuration, 16 bits by
+// default, values are truncated to the word size. See DW for wider
+// words.
 func (self *Assembler) DD(words ...uint16) {
+	start := self.ip
+	for _, d := range words {
+		self.emitWord(uint32(d))
+	}
+	self.record(start, "DD "+formatValues("0x%04X", len(words), func(i int) uint { return uint(words[i]) }))
+	self.protect(start, dataRegion)
+}
+
+// DW insert a sequence of words into memory at IP, updates IP. Like
+// DD, the values may be as wide as the largest word size.
+func (self *Assembler) DW(words ...uint32) {
+	start := self.ip
 	for _, d := range words {
-		self.memory[self.ip] = uint8(d >> 8)
-		self.memory[self.ip+1] = uint8(d & 0xFF)
-		self.ip += 2
+		self.emitWord(d)
+	}
+	self.record(start, "DW "+formatValues("0x%04X", len(words), func(i int) uint { return uint(words[i]) }))
+	self.protect(start, dataRegion)
+}
+
+// formatValues formats n values, separated by spaces
+func formatValues(format string, n int, value func(i int) uint) string {
+	res := make([]string, n)
+	for i := range res {
+		res[i] = fmt.Sprintf(format, value(i))
 	}
+	return strings.Join(res, " ")
 }
 
 //////////////////////////////////////////////////////////////////////////
 // Assembler opcodes
 
 // SBNZ adds a new SBNZ instruction to the program and advances the
-// IP.
-func (self *Assembler) SBNZ(a, b, c, d labeler) {
-	for _, v := range [4]labeler{a, b, c, d} {
-		addr := v.getAddress(self)
-		self.memory[self.ip] = uint8(addr >> 8)
-		self.memory[self.ip+1] = uint8(addr & 0xFF)
-		self.ip += 2
+// IP. Writing to ONE, ZERO or an Imm is an error, every macro
+// instruction relies on them.
+func (self *Assembler) SBNZ(a, b, c, d Labeler) {
+	start := self.ip
+	for _, v := range [4]Labeler{a, b, c, d} {
+		self.emitAddress(v)
+	}
+	text := fmt.Sprintf("SBNZ %s, %s, %s, %s", a, b, c, d)
+	source := text
+	if self.depth > 0 {
+		source = self.macro
+	}
+	if constant(c) {
+		self.errs = append(self.errs, fmt.Errorf("%s at %04x writes to the constant %s", source, uint32(start), c))
+	}
+	self.record(start, text, a, b, c, d)
+	self.protect(start, codeRegion)
+	if self.delta == 0 {
+		self.instructions = append(self.instructions, instruction{start, source, self.self_modifying})
 	}
 }
 
@@ -227,7 +685,8 @@ func (self *Assembler) SBNZ(a, b, c, d labeler) {
 // the execution continues in the next instruction.
 
 // MOV copy content of 'a' to 'b'.
-func (self *Assembler) MOV(src, dst labeler) {
+func (self *Assembler) MOV(src, dst Labeler) {
+	defer self.beginMacro("MOV", src, dst)()
 	label := self.uniqLabel()
 	self.SBNZ(src, ZERO, dst, label)
 	self.Label(label)
@@ -236,27 +695,93 @@ func (self *Assembler) MOV(src, dst labeler) {
 // ----------------------------------------------------- flow control
 
 // JMP incoditional jump to 'a'
-func (self *Assembler) JMP(a labeler) {
+func (self *Assembler) JMP(a Labeler) {
+	defer self.beginMacro("JMP", a)()
 	self.SBNZ(ONE, ZERO, JUNK, a)
 }
 
 // BEQ branch execution to 'c' if contents of 'a' and 'b' are equal.
-func (self *Assembler) BEQ(a, b, dst labeler) {
+func (self *Assembler) BEQ(a, b, dst Labeler) {
+	defer self.beginMacro("BEQ", a, b, dst)()
 	label := self.uniqLabel()
 	self.SBNZ(a, b, JUNK, label)
 	self.JMP(dst)
 	self.Label(label)
 }
 
+// BLTZ branch execution to 'dst' if the content of 'a' is negative.
+//
+// SBNZ can only test for zero, so the sign bit is extracted clearing
+// the other bits one at a time: bit i is tested shifting the value
+// (n - 1 - i) positions to the left, n bits per word, with the lower
+// bits already cleared the result is zero only if the bit is zero.
+// That's slow, about 700 steps with 16 bits words.
+func (self *Assembler) BLTZ(a, dst Labeler) {
+	defer self.beginMacro("BLTZ", a, dst)()
+	x := self.uniqLabel()   // the value, bits cleared one at a time
+	bit := self.uniqLabel() // the bit being tested
+	k := self.uniqLabel()   // n - 1 - i
+	j := self.uniqLabel()   // inner loop counter
+	w := self.uniqLabel()   // x shifted left k bits
+	cn := self.uniqLabel()  // n - 1
+	outer := self.uniqLabel()
+	inner := self.uniqLabel()
+	tested := self.uniqLabel()
+	cleared := self.uniqLabel()
+	done := self.uniqLabel()
+	exit := self.uniqLabel()
+
+	self.MOV(a, x)
+	self.MOV(ONE, bit)
+	self.MOV(cn, k)
+	self.Label(outer)
+	self.BEQ(k, ZERO, done)
+	self.MOV(x, w)
+	self.MOV(k, j)
+	self.Label(inner)
+	self.BEQ(j, ZERO, tested)
+	self.ADD(w, w, w)
+	self.DEC(j)
+	self.JMP(inner)
+	self.Label(tested)
+	self.BEQ(w, ZERO, cleared)
+	self.SUB(x, bit, x)
+	self.Label(cleared)
+	self.ADD(bit, bit, bit)
+	self.DEC(k)
+	self.JMP(outer)
+	self.Label(done)
+	// only the sign bit remains
+	self.BEQ(x, ZERO, exit)
+	self.JMP(dst)
+	for _, l := range []Label{x, bit, k, j, w} {
+		self.Label(l)
+		self.DD(0)
+	}
+	self.Label(cn)
+	self.DW(uint32(8*self.word() - 1))
+	self.Label(exit)
+}
+
+// BLEZ branch execution to 'dst' if the content of 'a' is less than
+// or equal to zero. Slow, see BLTZ.
+func (self *Assembler) BLEZ(a, dst Labeler) {
+	defer self.beginMacro("BLEZ", a, dst)()
+	self.BEQ(a, ZERO, dst)
+	self.BLTZ(a, dst)
+}
+
 // ------------------------------------------------- assorted opcodes
 
 // HLT halt execution
 func (self *Assembler) HLT() {
-	self.SBNZ(ONE, ZERO, JUNK, maxAddress)
+	defer self.beginMacro("HLT")()
+	self.SBNZ(ONE, ZERO, JUNK, HLT)
 }
 
 // NOP do nothing
 func (self *Assembler) NOP() {
+	defer self.beginMacro("NOP")()
 	label := self.uniqLabel()
 	self.SBNZ(JUNK, JUNK, JUNK, label)
 	self.Label(label)
@@ -266,7 +791,8 @@ func (self *Assembler) NOP() {
 
 // NEG negate the content of src and store the result in dst. src and
 // dst may point to the same address.
-func (self *Assembler) NEG(src, dst labeler) {
+func (self *Assembler) NEG(src, dst Labeler) {
+	defer self.beginMacro("NEG", src, dst)()
 	label := self.uniqLabel()
 	self.SBNZ(ZERO, src, dst, label)
 	self.Label(label)
@@ -274,7 +800,8 @@ func (self *Assembler) NEG(src, dst labeler) {
 
 // ADD add content of a to content of b and store the result in
 // dst. a, b and c may point to the same data address.
-func (self *Assembler) ADD(a, b, dst labeler) {
+func (self *Assembler) ADD(a, b, dst Labeler) {
+	defer self.beginMacro("ADD", a, b, dst)()
 	label := self.uniqLabel()
 	self.NEG(b, JUNK)
 	self.SBNZ(a, JUNK, dst, label)
@@ -282,19 +809,22 @@ func (self *Assembler) ADD(a, b, dst labeler) {
 }
 
 // SUB substract content of b from a and stores the result in dst.
-func (self *Assembler) SUB(a, b, dst labeler) {
+func (self *Assembler) SUB(a, b, dst Labeler) {
+	defer self.beginMacro("SUB", a, b, dst)()
 	label := self.uniqLabel()
 	self.SBNZ(a, b, dst, label)
 	self.Label(label)
 }
 
 // INC increments content of 'a'
-func (self *Assembler) INC(a labeler) {
+func (self *Assembler) INC(a Labeler) {
+	defer self.beginMacro("INC", a)()
 	self.ADD(a, ONE, a)
 }
 
 // DEC decrement content of 'a'
-func (self *Assembler) DEC(a labeler) {
+func (self *Assembler) DEC(a Labeler) {
+	defer self.beginMacro("DEC", a)()
 	label := self.uniqLabel()
 	self.SBNZ(a, ONE, a, label)
 	self.Label(label)
@@ -319,27 +849,29 @@ func (self *Assembler) DEC(a labeler) {
 //
 // stack management is a bit tricky
 
-func (self *Assembler) PUSH(a labeler) {
+func (self *Assembler) PUSH(a Labeler) {
+	defer self.beginMacro("PUSH", a)()
 	data := self.uniqLabel()
 	exit := self.uniqLabel()
-	self.SBNZ(a, ZERO, Label("__push_operand"), self.ip+8)
-	self.SBNZ(data, ZERO, Label("__push_ret"), self.ip+8)
+	self.SBNZ(a, ZERO, Label("__push_operand"), self.at(4))
+	self.SBNZ(data, ZERO, Label("__push_ret"), self.at(4))
 	self.SBNZ(ONE, ZERO, JUNK, Label("__push"))
 	self.SBNZ(ONE, ZERO, JUNK, exit)
 	self.Label(data)
-	self.DD(uint16(self.ip - 8))
+	self.addresses(self.at(-4))
 	self.Label(exit)
 }
 
-func (self *Assembler) POP(a labeler) {
+func (self *Assembler) POP(a Labeler) {
+	defer self.beginMacro("POP", a)()
 	data := self.uniqLabel()
 	exit := self.uniqLabel()
-	self.SBNZ(data, ZERO, Label("__pop_ret"), self.ip+8)
+	self.SBNZ(data, ZERO, Label("__pop_ret"), self.at(4))
 	self.SBNZ(ONE, ZERO, JUNK, Label("__pop"))
-	self.SBNZ(Label("__push_operand"), ZERO, a, self.ip+8)
+	self.SBNZ(Label("__push_operand"), ZERO, a, self.at(4))
 	self.SBNZ(ONE, ZERO, JUNK, exit)
 	self.Label(data)
-	self.DD(uint16(self.ip - 16))
+	self.addresses(self.at(-8))
 	self.Label(exit)
 }
 
@@ -347,17 +879,20 @@ func (self *Assembler) POP(a labeler) {
 
 // NOT perform the bitwise not on the contents of 'a' and stores the
 // result in 'b'.
-func (self *Assembler) NOT(a, b labeler) {
+func (self *Assembler) NOT(a, b Labeler) {
+	defer self.beginMacro("NOT", a, b)()
 	self.ADD(a, ONE, b)
 	self.NEG(b, b)
 }
 
-// // --------------------------------------------- emulating other OISC
+// --------------------------------------------- emulating other OISC
 
-// // SUBLEQ Subtract and branch if less than or equal to zero OISC.
-// // Substrat content of address 'a' from content of 'b' and stores the
-// // result en address 'c'. If the result is less than or equal to zero
-// // jump to address 'c'.
-// func (self *Assembler) SUBLEQ(a, b vm.DataAddress, c Address) {
-// 	// not sure how to test 'value < 0'
-// }
+// SUBLEQ Subtract and branch if less than or equal to zero OISC.
+// Substrat content of address 'a' from content of 'b' and stores the
+// result en address 'b'. If the result is less than or equal to zero
+// jump to address 'c'.
+func (self *Assembler) SUBLEQ(a, b, c Labeler) {
+	defer self.beginMacro("SUBLEQ", a, b, c)()
+	self.SUB(b, a, b)
+	self.BLEZ(b, c)
+}
@@ -0,0 +1,103 @@
+package assembler
+
+import (
+	"encoding/json"
+	"fmt"
+	"gosics/vm"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Object is a relocatable module, the output of assembling a module
+// created with NewModule. Its code is assembled as if it was loaded
+// at address 0, the linker is responsible for placing it in memory,
+// relocating the addresses and resolving the references to other
+// modules.
+type Object struct {
+	// Name identifies the object in error messages and symbol
+	// tables, usually the file name.
+	Name string `json:"name"`
+	// Code contents of memory
+	Code []uint8 `json:"code"`
+	// Symbols all the labels defined in the module
+	Symbols map[Label]Address `json:"symbols"`
+	// Exports labels visible to other modules
+	Exports []Label `json:"exports"`
+	// Imports positions in Code referencing labels defined in other
+	// modules
+	Imports map[Label][]Address `json:"imports"`
+	// Relocations positions in Code holding addresses within the
+	// module
+	Relocations []Address `json:"relocations"`
+	// Config word size and byte order of the code
+	Config vm.Config `json:"config"`
+}
+
+// Object assembles the module and returns it as a relocatable
+// object. Labels referenced but not defined in the module are
+// imported. Fails if an exported label is not defined, and for
+// modules assembled WithOrigin or using banks.
+func (self *Assembler) Object() (*Object, error) {
+	if len(self.bank_nums) > 1 {
+		return nil, fmt.Errorf("banked modules can't be linked")
+	}
+	if self.origin != 0 {
+		// the linker places the code of the modules at its own
+		// addresses
+		return nil, fmt.Errorf("modules with an origin can't be linked")
+	}
+	obj := &Object{
+		Code:    self.Assemble(),
+		Symbols: make(map[Label]Address, len(self.labels)),
+		Imports: make(map[Label][]Address),
+		Config:  self.config,
+	}
+	for l, a := range self.labels {
+		obj.Symbols[l] = a
+	}
+	imported := make(map[Address]bool)
+	for l, lst := range self.unresolved {
+		if _, ok := self.labels[l]; ok {
+			continue
+		}
+		for e := lst.Front(); e != nil; e = e.Next() {
+			p := e.Value.(Address)
+			obj.Imports[l] = append(obj.Imports[l], p)
+			imported[p] = true
+		}
+	}
+	for _, p := range self.relocs {
+		if !imported[p] {
+			obj.Relocations = append(obj.Relocations, p)
+		}
+	}
+	var undefined []string
+	for l := range self.exports {
+		if _, ok := self.labels[l]; !ok {
+			undefined = append(undefined, string(l))
+			continue
+		}
+		obj.Exports = append(obj.Exports, l)
+	}
+	if len(undefined) > 0 {
+		sort.Strings(undefined)
+		return nil, fmt.Errorf("exported labels not defined: %s", strings.Join(undefined, ", "))
+	}
+	sort.Slice(obj.Exports, func(i, j int) bool { return obj.Exports[i] < obj.Exports[j] })
+	return obj, nil
+}
+
+// Write writes the object to w, in JSON format
+func (self *Object) Write(w io.Writer) error {
+	return json.NewEncoder(w).Encode(self)
+}
+
+// ReadObject reads an object written by Object.Write
+func ReadObject(r io.Reader) (*Object, error) {
+	obj := &Object{}
+	if err := json.NewDecoder(r).Decode(obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
@@ -0,0 +1,87 @@
+package assembler
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObjectImportsUndefinedLabels(t *testing.T) {
+	as := NewModule()
+	as.MOV(Label("SRC"), Label("DST"))
+	as.Label("DST")
+	as.DD(0)
+
+	obj, err := as.Object()
+	assert.Nil(t, err)
+	assert.Equal(t, map[Label][]Address{"SRC": {0}, ZERO: {2}}, obj.Imports)
+	// DST and the generated label
+	assert.Equal(t, []Address{4, 6}, obj.Relocations)
+	assert.Equal(t, Address(8), obj.Symbols["DST"])
+}
+
+func TestObjectExports(t *testing.T) {
+	as := NewModule()
+	as.Label("foo")
+	as.Label("bar")
+	as.Export("foo", "bar")
+	obj, err := as.Object()
+	assert.Nil(t, err)
+	assert.Equal(t, []Label{"bar", "foo"}, obj.Exports)
+
+	as.Export("baz")
+	_, err = as.Object()
+	assert.Equal(t, "exported labels not defined: baz", err.Error())
+}
+
+func TestObjectLiteralAddressesAreNotRelocated(t *testing.T) {
+	as := NewModule()
+	as.SBNZ(Address(0x10), Address(0x12), Address(0x14), HLT)
+	obj, err := as.Object()
+	assert.Nil(t, err)
+	assert.Nil(t, obj.Relocations)
+	assert.Empty(t, obj.Imports)
+}
+
+func TestObjectWriteAndRead(t *testing.T) {
+	as := NewModule()
+	as.PUSH(Label("SRC"))
+	as.Label("SRC")
+	as.DD(0x1234)
+	as.Export("SRC")
+	obj, err := as.Object()
+	assert.Nil(t, err)
+	obj.Name = "test"
+
+	var buf bytes.Buffer
+	assert.Nil(t, obj.Write(&buf))
+	read, err := ReadObject(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, obj, read)
+}
+
+// TestObjectIsReproducible checks the object doesn't depend on the
+// source code, only on the program
+func TestObjectIsReproducible(t *testing.T) {
+	var written [2]bytes.Buffer
+	for i := range written {
+		as := NewModule()
+		as.Label("unused")
+		as.HLT()
+		obj, err := as.Object()
+		assert.Nil(t, err)
+		assert.Nil(t, obj.Write(&written[i]))
+	}
+	assert.Equal(t, written[0].String(), written[1].String())
+	assert.NotContains(t, written[0].String(), "object_test.go")
+}
+
+func TestObjectWithOrigin(t *testing.T) {
+	as := NewModule(WithOrigin(0x100))
+	as.HLT()
+	_, err := as.Object()
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "modules with an origin can't be linked", err.Error())
+	}
+}
@@ -0,0 +1,97 @@
+package assembler
+
+import "gosics/vm"
+
+// Option customizes the assembler, see New
+type Option func(*Assembler) error
+
+// Preamble selects the code inserted at the beginning of the program
+type Preamble int
+
+const (
+	// JumpPreamble inserts the constants at the beginning of the
+	// program, preceded by a jump over them. The default.
+	JumpPreamble Preamble = iota
+	// NoPreamble doesn't insert any code, the program starts at the
+	// origin. The constants, if any, are emitted at the end of the
+	// program like the runtime routines.
+	NoPreamble
+)
+
+// WithOrigin sets the address of the first instruction. The assembled
+// program still starts at address 0, the memory before the origin is
+// filled with zeros.
+func WithOrigin(origin Address) Option {
+	return func(a *Assembler) error {
+		a.origin = origin
+		return nil
+	}
+}
+
+// WithConstants sets whether to emit the constants __ONE, __ZERO and
+// __JUNK. If disabled the program must define them, most macro
+// instructions depend on them.
+func WithConstants(emit bool) Option {
+	return func(a *Assembler) error {
+		a.constants = emit
+		return nil
+	}
+}
+
+// WithPreamble selects the preamble
+func WithPreamble(p Preamble) Option {
+	return func(a *Assembler) error {
+		a.preamble = p
+		return nil
+	}
+}
+
+// WithStack sets the location and size of the stack, see SetStack
+func WithStack(s Stack) Option {
+	return func(a *Assembler) error {
+		return a.SetStack(s)
+	}
+}
+
+// WithLabelPrefix sets the prefix of the labels generated by the
+// macro instructions, DefaultLabelPrefix by default
+func WithLabelPrefix(prefix string) Option {
+	return func(a *Assembler) error {
+		a.label_prefix = prefix
+		return nil
+	}
+}
+
+// WithConfig sets the word size and byte order of the target
+// computer, vm.DefaultConfig by default. Addresses, DD and the
+// instructions are encoded accordingly. Must precede the options
+// depending on the word size, like WithStack.
+func WithConfig(config vm.Config) Option {
+	return func(a *Assembler) error {
+		if err := config.Validate(); err != nil {
+			return err
+		}
+		a.config = config
+		return nil
+	}
+}
+
+// WithLint sets whether to record the positions in the Go code
+// emitting the program, reported by Lint, disabled by default: finding
+// them on every instruction is slow. The programs assembled with
+// Source have the positions in the source anyway.
+func WithLint(on bool) Option {
+	return func(a *Assembler) error {
+		a.lint = on
+		return nil
+	}
+}
+
+// WithOptimization sets whether to optimize the program when
+// assembling, disabled by default. See peephole.go for the details.
+func WithOptimization(on bool) Option {
+	return func(a *Assembler) error {
+		a.optimize = on
+		return nil
+	}
+}
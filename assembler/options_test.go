@@ -0,0 +1,209 @@
+package assembler
+
+import (
+	"bytes"
+	"gosics/vm"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithOrigin(t *testing.T) {
+	as := New(WithOrigin(0x100))
+	as.MOV(Label("SRC"), Label("DST"))
+	as.HLT()
+	as.Label("SRC")
+	as.DD(0x1234)
+	as.Label("DST")
+	as.DD(0)
+
+	assert.Equal(t, Address(0x100+0x0E), as.labels["__start"])
+	assert.Equal(t, Address(0x108), as.labels[ONE])
+	program := as.Assemble()
+	assert.Equal(t, make([]uint8, 0x100), program[:0x100])
+
+	c := t_runUntilHalted(&as)
+	assert.Nil(t, c.Fault())
+	assert.Equal(t, vm.Operand(0x1234), t_peek(&c, &as, "DST"))
+}
+
+func TestWithoutPreamble(t *testing.T) {
+	as := New(WithPreamble(NoPreamble))
+	assert.Equal(t, Address(0), as.labels["__start"])
+	as.MOV(Label("SRC"), Label("DST"))
+	as.HLT()
+	as.Label("SRC")
+	as.DD(0x1234)
+	as.Label("DST")
+	as.DD(0)
+
+	c := t_runUntilHalted(&as)
+	assert.Nil(t, as.Err())
+	assert.Equal(t, vm.Operand(0x1234), t_peek(&c, &as, "DST"))
+	// constants are emitted after the program
+	assert.True(t, as.labels[ZERO] > as.labels["DST"])
+}
+
+func TestWithoutConstants(t *testing.T) {
+	as := New(WithConstants(false), WithPreamble(NoPreamble))
+	as.MOV(Label("SRC"), Label("DST"))
+	as.Assemble()
+	assert.Equal(t, "undefined label DST; undefined label SRC; undefined label __ZERO", as.Err().Error())
+
+	as.Label("SRC")
+	as.DD(0x1234)
+	as.Label("DST")
+	as.DD(0)
+	as.Label(ZERO)
+	as.DD(0)
+	as.Assemble()
+	assert.Nil(t, as.Err())
+	_, ok := as.labels[ONE]
+	assert.False(t, ok)
+}
+
+func TestWithStack(t *testing.T) {
+	as := New(WithStack(Stack{Base: 0x1000, Size: 16}))
+	as.PUSH(Label("SRC"))
+	as.HLT()
+	as.Label("SRC")
+	as.DD(0x1234)
+
+	c := t_runUntilHalted(&as)
+	assert.Equal(t, vm.Operand(0x1234), c.Peek(0x1000))
+}
+
+func TestWithInvalidStack(t *testing.T) {
+	as := New(WithStack(Stack{Base: 0x1000}))
+	assert.Equal(t, "empty stack", as.Err().Error())
+	assert.Equal(t, DefaultStack, as.stack)
+}
+
+func TestWithLabelPrefix(t *testing.T) {
+	as := New(WithLabelPrefix("L"))
+	assert.Equal(t, Label("L0001"), as.uniqLabel())
+	assert.True(t, as.generated("L0002"))
+	assert.False(t, as.generated("__label_0002"))
+}
+
+// t_runWithConfig create a new Computer with the configuration, load
+// the program assembled by a and execute it until halted
+func t_runWithConfig(a *Assembler, config vm.Config) vm.Computer {
+	c := vm.Computer{}
+	c.SetConfig(config)
+	c.LoadMemory(a.Assemble())
+	for i := 0; i < 10000 && !c.Halted(); i++ {
+		c.Step()
+	}
+	return c
+}
+
+func TestWithConfig(t *testing.T) {
+	configs := []vm.Config{
+		{WordSize: 16, Order: vm.LittleEndian},
+		{WordSize: 24},
+		{WordSize: 32, Order: vm.LittleEndian},
+	}
+	for _, config := range configs {
+		as := New(WithConfig(config))
+		as.MOV(Label("SRC"), Label("DST"))
+		as.ADD(Label("DST"), Label("DST"), Label("DST"))
+		as.PUSH(Label("DST"))
+		as.POP(Label("OUT"))
+		as.HLT()
+		as.Label("SRC")
+		as.DW(0x123456)
+		as.Label("DST")
+		as.DD(0)
+		as.Label("OUT")
+		as.DD(0)
+
+		c := t_runWithConfig(&as, config)
+		assert.Nil(t, as.Err())
+		assert.True(t, c.Halted(), config)
+		expected := config.Wrap(0x123456 * 2)
+		assert.Equal(t, expected, t_peek(&c, &as, "DST"), config)
+		assert.Equal(t, expected, t_peek(&c, &as, "OUT"), config)
+		assert.Equal(t, Address(config.MaxAddress()+1-config.Bytes()), as.stack.Base)
+	}
+}
+
+func TestWithConfig8Bits(t *testing.T) {
+	config := vm.Config{WordSize: 8}
+	as := New(WithConfig(config))
+	as.BLTZ(Label("SRC"), Label("NEGATIVE"))
+	as.HLT()
+	as.Label("NEGATIVE")
+	as.MOV(ONE, Label("DST"))
+	as.HLT()
+	as.Label("SRC")
+	as.DD(0x80)
+	as.Label("DST")
+	as.DD(0)
+
+	c := t_runWithConfig(&as, config)
+	assert.Nil(t, as.Err())
+	assert.Equal(t, vm.Operand(1), t_peek(&c, &as, "DST"))
+	assert.Equal(t, Stack{Base: 0xFF, Size: 32}, as.stack)
+}
+
+func TestWithConfigLargeProgram(t *testing.T) {
+	config := vm.Config{WordSize: 24}
+	as := New(WithConfig(config))
+	as.MOV(Label("SRC"), Label("DST"))
+	as.HLT()
+	as.DB(make([]uint8, 0x10000)...)
+	as.Label("SRC")
+	as.DW(0x123456)
+	as.Label("DST")
+	as.DD(0)
+
+	c := t_runWithConfig(&as, config)
+	assert.Nil(t, as.Err())
+	assert.True(t, as.labels["DST"] > 0xFFFF)
+	assert.Equal(t, vm.Operand(0x123456), t_peek(&c, &as, "DST"))
+
+	as = New()
+	as.DB(make([]uint8, 0x10000)...)
+	as.Assemble()
+	assert.Equal(t, "program too big", as.Err().Error())
+}
+
+func TestHLTAndDWWithConfig(t *testing.T) {
+	config := vm.Config{WordSize: 32}
+	as := New(WithConfig(config))
+	var halt Address = HLT
+	as.SBNZ(Label("X"), ZERO, Label("Y"), halt)
+	as.Label("X")
+	as.DW(0xFFFFFFFF)
+	as.Label("Y")
+	as.DD(0)
+	as.Label("Z")
+	as.DD(0xFFFF)
+
+	c := t_runWithConfig(&as, config)
+	assert.Nil(t, as.Err())
+	assert.True(t, c.Halted())
+	assert.Equal(t, vm.Operand(-1), t_peek(&c, &as, "Y"))
+	assert.Equal(t, vm.Operand(0xFFFF), t_peek(&c, &as, "Z"))
+	var buf bytes.Buffer
+	assert.Nil(t, as.Listing(&buf))
+	assert.Contains(t, buf.String(), "SBNZ X, __ZERO, Y, HLT")
+	assert.Contains(t, buf.String(), "DW 0xFFFFFFFF")
+}
+
+func TestWithConfigListing(t *testing.T) {
+	as := New(WithConfig(vm.Config{WordSize: 32, Order: vm.LittleEndian}))
+	var buf bytes.Buffer
+	assert.Nil(t, as.Listing(&buf))
+	lines := strings.Split(buf.String(), "\n")
+	assert.Equal(t, "00000000  10000000 14000000 18000000 1c000000  SBNZ __ONE, __ZERO, __JUNK, __start", lines[0])
+	assert.Equal(t, "                                               __ONE:", lines[1])
+	assert.Equal(t, "00000010  01000000                             DD 0x0001", lines[2])
+}
+
+func TestWithInvalidConfig(t *testing.T) {
+	as := New(WithConfig(vm.Config{WordSize: 12}))
+	assert.Equal(t, "unsupported word size 12", as.Err().Error())
+}
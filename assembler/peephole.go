@@ -0,0 +1,356 @@
+package assembler
+
+import (
+	"fmt"
+	"gosics/vm"
+	"sort"
+	"strings"
+)
+
+// Peephole optimization
+//
+// The macro instructions are simple and composable at the price of
+// redundant code: MOV branches to the next instruction, ADD always
+// negates into __JUNK. If enabled with WithOptimization, the program
+// is rewritten when assembling:
+//
+// - NEG k, __JUNK followed by the subtraction of __JUNK, as emitted by
+// ADD, is merged into one instruction when k or the minuend is
+// __ZERO, unless other code branches to the subtraction.
+//
+// - branches to unconditional jumps branch to the target of the jump.
+//
+// - instructions continuing in the next one whatever the result and
+// writing into __JUNK a constant, like NOP or a jump to the next
+// instruction, or a scratch value not used anymore, are removed.
+// Scratch values are the ones written by the macro instructions not
+// given __JUNK as an argument.
+//
+// until there's nothing left to do. The instructions reading __JUNK
+// are never affected, the value left in __JUNK by the macro
+// instructions may change. The code after
+// the removed instructions is moved and the addresses relocated, as
+// the linker does, so literal addresses within the program, never
+// relocated, should be used with the optimization disabled. The
+// preamble, the runtime routines, self-modifying code, instructions
+// whose operands are referenced and banked programs are left alone.
+
+// readsJunk return true if the value of the instruction depends on
+// __JUNK
+func (self *record) readsJunk() bool {
+	a, b := self.operands[0], self.operands[1]
+	return a != b && (a == Labeler(JUNK) || b == Labeler(JUNK))
+}
+
+// resolve return the address of v, false for undefined labels
+func (self *Assembler) resolve(v Labeler) (Address, bool) {
+	switch i := v.(type) {
+	case Imm:
+		v = i.label(self)
+	case Char:
+		v = Imm(i).label(self)
+	}
+	if l, ok := v.(Label); ok {
+		a, ok := self.labels[l]
+		return a, ok
+	}
+	return v.getAddress(self), true
+}
+
+// continues return true if the instruction continues in the next one
+// whatever the result
+func (self *Assembler) continues(r *record) bool {
+	d, ok := self.resolve(r.operands[3])
+	return r.operands[0] == r.operands[1] || ok && d == r.address+4*self.word()
+}
+
+// wordAt return the word at position p of the memory
+func (self *Assembler) wordAt(p Address) Address {
+	return Address(self.config.Word(self.memory[p : p+self.word()]))
+}
+
+// unresolvedAt return the positions of the unresolved references
+func (self *Assembler) unresolvedAt() map[Address]bool {
+	res := make(map[Address]bool)
+	for _, lst := range self.unresolved {
+		for e := lst.Front(); e != nil; e = e.Next() {
+			res[e.Value.(Address)] = true
+		}
+	}
+	return res
+}
+
+// removable return true if the instruction has no effect but writing
+// into __JUNK a constant, like NOP or a jump to the next instruction,
+// or a scratch value not read by the next instruction
+func (self *Assembler) removable(r, next *record) bool {
+	scratch := r.operands[0] == r.operands[1] || r.jumps() || r.scratch
+	return r.operands[2] == Labeler(JUNK) && scratch && self.continues(r) && (next == nil || !next.readsJunk())
+}
+
+// entered return true if the instruction s, following r, is the
+// target of some reference but the branch of r. Code jumping to s
+// doesn't go through r and may leave anything in __JUNK.
+func (self *Assembler) entered(r, s *record, targets map[Address]int) bool {
+	own := 0
+	if d, ok := self.resolve(r.operands[3]); ok && d == s.address {
+		if _, fixed := r.operands[3].(Address); !fixed {
+			own = 1
+		}
+	}
+	return targets[s.address] > own
+}
+
+// peephole optimizes the program, see WithOptimization
+func (self *Assembler) peephole() {
+	if !self.optimize || len(self.bank_nums) > 1 {
+		return
+	}
+	for self.peepholePass() {
+	}
+	sort.Slice(self.relocs, func(i, j int) bool { return self.relocs[i] < self.relocs[j] })
+}
+
+// peepholePass applies the optimizations once, returns true if the
+// program changed
+func (self *Assembler) peepholePass() bool {
+	width := 4 * self.word()
+	var order []*record
+	code := make(map[Address]*record)
+	for i := range self.records {
+		if r := &self.records[i]; r.operands != nil {
+			order = append(order, r)
+			code[r.address] = r
+		}
+	}
+
+	// instructions left alone
+	fixed := make(map[Address]bool)
+	for _, in := range self.instructions {
+		fixed[in.address] = in.self_modifying
+	}
+	// references to each address, exported labels may be referenced
+	// by other modules
+	targets := make(map[Address]int)
+	unresolved := self.unresolvedAt()
+	for _, p := range self.relocs {
+		if !unresolved[p] {
+			targets[self.wordAt(p)]++
+		}
+	}
+	for l, lst := range self.unresolved {
+		if a, ok := self.labels[l]; ok {
+			targets[a] += lst.Len()
+		}
+	}
+	for l := range self.exports {
+		if a, ok := self.labels[l]; ok {
+			targets[a]++
+		}
+	}
+	for _, r := range order {
+		if r.internal {
+			fixed[r.address] = true
+		}
+		for k := Address(1); k < width; k++ {
+			if targets[r.address+k] > 0 {
+				fixed[r.address] = true
+			}
+		}
+	}
+
+	changed := false
+	for _, r := range order {
+		// NEG k, JUNK; SBNZ x, JUNK, c, d
+		s := code[r.address+width]
+		if fixed[r.address] || r.operands[0] != Labeler(ZERO) || r.operands[2] != Labeler(JUNK) ||
+			!self.continues(r) || s == nil || fixed[s.address] || self.entered(r, s, targets) ||
+			s.operands[1] != Labeler(JUNK) || s.operands[0] == Labeler(JUNK) {
+			continue
+		}
+		switch {
+		case r.operands[1] == Labeler(ZERO): // x - 0
+			self.setOperand(s, 1, ZERO)
+			changed = true
+		case s.operands[0] == Labeler(ZERO): // 0 - -k
+			self.setOperand(s, 0, r.operands[1])
+			self.setOperand(s, 1, ZERO)
+			changed = true
+		}
+	}
+
+	// instructions without effect, removed before redirecting the
+	// branches to them
+	var removed []Address
+	for _, r := range order {
+		if !fixed[r.address] && self.removable(r, code[r.address+width]) {
+			removed = append(removed, r.address)
+		}
+	}
+	if len(removed) > 0 {
+		self.removeInstructions(removed)
+		return true
+	}
+
+	// branches to jumps
+	for _, r := range order {
+		if fixed[r.address] || r.operands[0] == r.operands[1] {
+			continue
+		}
+		target := r.operands[3]
+		seen := map[Address]bool{r.address: true}
+		for {
+			t, ok := self.resolve(target)
+			j := code[t]
+			if !ok || j == nil || fixed[t] || !j.jumps() || j.operands[2] != Labeler(JUNK) {
+				break
+			}
+			if seen[t] { // a loop of jumps
+				target = r.operands[3]
+				break
+			}
+			seen[t] = true
+			// imported targets are trusted
+			if e, ok := self.resolve(j.operands[3]); ok && code[e] != nil && code[e].readsJunk() {
+				break
+			}
+			target = j.operands[3]
+		}
+		if target != r.operands[3] {
+			self.setOperand(r, 3, target)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// setOperand replaces the i-th operand of the instruction
+func (self *Assembler) setOperand(r *record, i int, v Labeler) {
+	p := r.address + Address(i)*self.word()
+	self.forget(func(q Address) bool { return q == p })
+	ip := self.ip
+	self.ip = p
+	self.emitAddress(v)
+	self.ip = ip
+	r.operands[i] = v
+	r.format()
+}
+
+// format updates the text of the instructions and the addresses
+// after rewriting them
+func (self *record) format() {
+	switch {
+	case self.operands != nil:
+		self.text = fmt.Sprintf("SBNZ %s, %s, %s, %s", self.operands[0], self.operands[1], self.operands[2], self.operands[3])
+	case self.data != nil:
+		names := make([]string, len(self.data))
+		for i, a := range self.data {
+			names[i] = a.String()
+		}
+		self.text = "DD " + strings.Join(names, " ")
+	}
+}
+
+// forget drops the relocations and unresolved references at the
+// positions matching f
+func (self *Assembler) forget(f func(p Address) bool) {
+	relocs := self.relocs[:0]
+	for _, q := range self.relocs {
+		if !f(q) {
+			relocs = append(relocs, q)
+		}
+	}
+	self.relocs = relocs
+	for _, lst := range self.unresolved {
+		for e := lst.Front(); e != nil; {
+			next := e.Next()
+			if f(e.Value.(Address)) {
+				lst.Remove(e)
+			}
+			e = next
+		}
+	}
+}
+
+// removeInstructions removes the instructions starting at the given
+// addresses, sorted, and moves the code after them. References to
+// a removed instruction point to the next one.
+func (self *Assembler) removeInstructions(starts []Address) {
+	width := 4 * self.word()
+	move := func(p Address) Address {
+		k := sort.Search(len(starts), func(i int) bool { return starts[i] >= p })
+		return p - Address(k)*width
+	}
+	inside := func(p Address) bool {
+		k := sort.Search(len(starts), func(i int) bool { return starts[i] > p })
+		return k > 0 && p < starts[k-1]+width
+	}
+
+	self.forget(inside)
+	unresolved := self.unresolvedAt()
+	for _, p := range self.relocs {
+		if v := self.wordAt(p); !unresolved[p] && v <= self.ip {
+			self.config.PutWord(self.memory[p:], vm.Address(move(v)))
+		}
+	}
+	memory := self.memory[:0]
+	for p, b := range self.memory {
+		if !inside(Address(p)) {
+			memory = append(memory, b)
+		}
+	}
+	self.memory = memory
+	for i, p := range self.relocs {
+		self.relocs[i] = move(p)
+	}
+	for _, lst := range self.unresolved {
+		for e := lst.Front(); e != nil; e = e.Next() {
+			e.Value = move(e.Value.(Address))
+		}
+	}
+	for l, a := range self.labels {
+		self.labels[l] = move(a)
+		self.label_pos[l] = move(a)
+	}
+
+	records := self.records[:0]
+	for _, r := range self.records {
+		if r.operands != nil && inside(r.address) {
+			continue
+		}
+		r.address, r.pos = move(r.address), move(r.pos)
+		for _, vs := range [][]Labeler{r.operands, r.data} {
+			for i, v := range vs {
+				if l, ok := v.(local); ok {
+					vs[i] = local(move(Address(l)))
+				}
+			}
+		}
+		r.format()
+		records = append(records, r)
+	}
+	self.records = records
+	instructions := self.instructions[:0]
+	for _, in := range self.instructions {
+		if !inside(in.address) {
+			in.address = move(in.address)
+			instructions = append(instructions, in)
+		}
+	}
+	self.instructions = instructions
+	regions := self.regions[:0]
+	for _, r := range self.regions {
+		from, to := move(Address(r.From)), move(Address(r.To)+1)
+		if from == to {
+			continue
+		}
+		r.From, r.To = vm.Address(from), vm.Address(to-1)
+		if n := len(regions); n > 0 && regions[n-1].To+1 == r.From && regions[n-1].Name == r.Name {
+			regions[n-1].To = r.To
+			continue
+		}
+		regions = append(regions, r)
+	}
+	self.regions = regions
+	self.ip = move(self.ip)
+}
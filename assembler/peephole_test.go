@@ -0,0 +1,273 @@
+package assembler
+
+import (
+	"bytes"
+	"gosics/vm"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// t_programs the programs of the macro instruction tests, halting,
+// with the data labels to compare
+var t_programs = map[string]func(as *Assembler){
+	"MOV": func(as *Assembler) {
+		as.MOV(Label("SRC"), Label("DST"))
+		as.HLT()
+	},
+	"JMP": func(as *Assembler) {
+		as.JMP(Label("next"))
+		as.Label("next")
+		as.INC(Label("DST"))
+		as.HLT()
+	},
+	"BEQ": func(as *Assembler) {
+		as.BEQ(Label("SRC"), Label("SRC"), Label("equal"))
+		as.INC(Label("DST"))
+		as.Label("equal")
+		as.BEQ(Label("SRC"), Label("DST"), Label("next"))
+		as.Label("next")
+		as.NOP()
+		as.HLT()
+	},
+	"NEG": func(as *Assembler) {
+		as.NEG(Label("SRC"), Label("DST"))
+		as.NEG(ZERO, Label("NEG"))
+		as.HLT()
+	},
+	"ADD": func(as *Assembler) {
+		as.ADD(Label("SRC"), Label("SRC"), Label("DST"))
+		as.ADD(Label("DST"), ZERO, Label("DST"))
+		as.ADD(ZERO, Label("SRC"), Label("NEG"))
+		as.ADD(ZERO, ZERO, Label("ZERO"))
+		as.HLT()
+	},
+	"SUB": func(as *Assembler) {
+		as.SUB(Label("SRC"), Label("NEG"), Label("DST"))
+		as.HLT()
+	},
+	"INC/DEC": func(as *Assembler) {
+		as.INC(Label("SRC"))
+		as.DEC(Label("DST"))
+		as.HLT()
+	},
+	"NOT": func(as *Assembler) {
+		as.NOT(Label("SRC"), Label("DST"))
+		as.HLT()
+	},
+	"PUSH/POP": func(as *Assembler) {
+		as.PUSH(Label("SRC"))
+		as.PUSH(Label("NEG"))
+		as.POP(Label("DST"))
+		as.POP(Label("ZERO"))
+		as.HLT()
+	},
+	"BLTZ/BLEZ": func(as *Assembler) {
+		as.BLTZ(Label("NEG"), Label("negative"))
+		as.HLT()
+		as.Label("negative")
+		as.INC(Label("DST"))
+		as.BLEZ(Label("SRC"), Label("end"))
+		as.INC(Label("DST"))
+		as.Label("end")
+		as.JMP(Label("halt"))
+		as.Label("halt")
+		as.HLT()
+	},
+	"SUBLEQ": func(as *Assembler) {
+		as.Label("loop")
+		as.SUBLEQ(Label("ONE"), Label("SRC"), Label("end"))
+		as.INC(Label("DST"))
+		as.JMP(Label("loop"))
+		as.Label("end")
+		as.HLT()
+	},
+}
+
+// t_data emits the data of the programs
+func t_data(as *Assembler) {
+	for _, d := range []struct {
+		label string
+		value uint16
+	}{{"SRC", 5}, {"DST", 0x1234}, {"NEG", 0xFFFD}, {"ZERO", 7}, {"ONE", 1}} {
+		as.Label(Label(d.label))
+		as.DD(d.value)
+	}
+}
+
+// t_run assembles the program, runs it and returns the computer and
+// the size of the program
+func t_run(program func(as *Assembler), opts ...Option) (vm.Computer, *Assembler, int) {
+	as := New(opts...)
+	program(&as)
+	t_data(&as)
+	size := len(as.Assemble())
+	c := t_runUntilHalted(&as)
+	return c, &as, size
+}
+
+func TestPeepholeEquivalence(t *testing.T) {
+	for name, program := range t_programs {
+		c1, as1, size1 := t_run(program)
+		c2, as2, size2 := t_run(program, WithOptimization(true))
+		assert.True(t, c1.Halted(), name)
+		assert.True(t, c2.Halted(), name)
+		assert.True(t, size2 <= size1, name)
+		for _, l := range []string{"SRC", "DST", "NEG", "ZERO", "ONE"} {
+			assert.Equal(t, t_peek(&c1, as1, l), t_peek(&c2, as2, l), "%s %s", name, l)
+		}
+		assert.Nil(t, as2.Err(), name)
+	}
+}
+
+// t_listing return the instructions in the listing of the program
+func t_listing(as *Assembler) []string {
+	var buf bytes.Buffer
+	as.Listing(&buf)
+	var res []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if i := strings.Index(line, "SBNZ"); i >= 0 && !strings.HasPrefix(line, "0000") {
+			res = append(res, line[i:])
+		}
+	}
+	return res
+}
+
+func TestPeepholeRemovesNOP(t *testing.T) {
+	as := New(WithOptimization(true))
+	as.NOP()
+	as.HLT()
+	as.NOP()
+
+	assert.Equal(t, []string{"SBNZ __ONE, __ZERO, __JUNK, HLT"}, t_listing(&as))
+	assert.Equal(t, 0x0E+8, len(as.Assemble()))
+}
+
+func TestPeepholeRemovesJumpsToTheNextInstruction(t *testing.T) {
+	as := New(WithOptimization(true))
+	as.JMP(Label("next"))
+	as.Label("next")
+	as.BEQ(Label("X"), Label("Y"), Label("end"))
+	as.Label("end")
+	as.HLT()
+
+	assert.Equal(t, []string{"SBNZ __ONE, __ZERO, __JUNK, HLT"}, t_listing(&as))
+	assert.Equal(t, Address(0x0E), as.labels["next"])
+	assert.Equal(t, Address(0x0E), as.labels["end"])
+}
+
+func TestPeepholeMergesNEG(t *testing.T) {
+	as := New(WithOptimization(true))
+	as.ADD(Label("X"), ZERO, Label("Y"))
+	as.ADD(ZERO, Label("X"), Label("Y"))
+	as.INC(Label("X"))
+	as.HLT()
+
+	assert.Equal(t, []string{
+		"SBNZ X, __ZERO, Y, __label_0001",
+		"SBNZ X, __ZERO, Y, __label_0003",
+		// -1 is not a constant
+		"SBNZ __ZERO, __ONE, __JUNK, __label_0006",
+		"SBNZ X, __JUNK, X, HLT",
+		"SBNZ __ONE, __ZERO, __JUNK, HLT",
+	}, t_listing(&as))
+}
+
+func TestPeepholeKeepsBranchTargets(t *testing.T) {
+	as := New(WithOptimization(true))
+	// JMP leaves 1 in __JUNK
+	as.JMP(Label("entry"))
+	as.NEG(ZERO, JUNK)
+	as.Label("entry")
+	as.SUB(ZERO, JUNK, Label("X"))
+	as.HLT()
+	as.Label("X")
+	as.DD(0)
+
+	c := t_runUntilHalted(&as)
+	assert.Equal(t, vm.Operand(-1), t_peek(&c, &as, "X"))
+	assert.Contains(t, t_listing(&as), "SBNZ __ZERO, __JUNK, X, HLT")
+}
+
+func TestPeepholeThreadsJumps(t *testing.T) {
+	as := New(WithOptimization(true))
+	as.BEQ(Label("X"), Label("Y"), Label("first"))
+	as.HLT()
+	as.Label("first")
+	as.JMP(Label("second"))
+	as.Label("second")
+	as.JMP(Label("third"))
+	as.Label("third")
+	as.JMP(Label("first"))
+
+	// the loop of jumps ends jumping to itself
+	assert.Equal(t, []string{
+		"SBNZ X, Y, __JUNK, HLT",
+		"SBNZ __ONE, __ZERO, __JUNK, first",
+		"SBNZ __ONE, __ZERO, __JUNK, HLT",
+		"SBNZ __ONE, __ZERO, __JUNK, first",
+	}, t_listing(&as))
+	assert.Equal(t, as.labels["first"], as.labels["third"])
+
+	as = New(WithOptimization(true))
+	as.MOV(Label("X"), Label("Y"))
+	as.JMP(Label("end"))
+	as.Label("end")
+	as.HLT()
+	assert.Equal(t, []string{
+		"SBNZ X, __ZERO, Y, HLT",
+		"SBNZ __ONE, __ZERO, __JUNK, HLT",
+	}, t_listing(&as))
+}
+
+func TestPeepholeKeepsWritesToJunk(t *testing.T) {
+	as := New(WithOptimization(true))
+	as.MOV(Label("X"), JUNK)
+	as.NEG(Label("X"), JUNK)
+	as.MOV(JUNK, Label("Y"))
+	as.HLT()
+
+	assert.Equal(t, 4, len(t_listing(&as)))
+}
+
+func TestPeepholeRelocates(t *testing.T) {
+	as := New(WithOptimization(true))
+	as.NOP()
+	as.PUSH(Label("X"))
+	as.POP(Label("Y"))
+	as.HLT()
+	as.Label("X")
+	as.DD(0x1234)
+	as.Label("Y")
+	as.DD(0)
+
+	c := t_runProtected(&as)
+	assert.Nil(t, c.Fault())
+	assert.Equal(t, vm.Operand(0x1234), t_peek(&c, &as, "Y"))
+	assert.Empty(t, as.Warnings())
+	assert.Equal(t, vm.Address(0x0E), as.MemoryMap()[3].From)
+}
+
+func TestWithOptimization(t *testing.T) {
+	as := New()
+	as.NOP()
+	as.HLT()
+	assert.Equal(t, 0x0E+16, len(as.Assemble()))
+
+	as = New(WithOptimization(true))
+	as.NOP()
+	as.HLT()
+	assert.Equal(t, 0x0E+8, len(as.Assemble()))
+}
+
+func TestPeepholeSymbols(t *testing.T) {
+	as := New(WithOptimization(true))
+	as.NOP()
+	as.Label("X")
+	as.HLT()
+
+	a, ok := as.Symbols().Lookup("X")
+	assert.True(t, ok)
+	assert.Equal(t, vm.Address(0x0E), a)
+}
@@ -0,0 +1,73 @@
+package assembler
+
+import (
+	"fmt"
+	"gosics/vm"
+	"sort"
+)
+
+// Constant pool
+//
+// Besides __ONE and __ZERO the programs had to define a label for
+// every constant they use. Imm operands are values instead: each
+// value is emitted once, after the runtime routines, in the constant
+// pool, read-only like __ONE and __ZERO, and the operand resolves to
+// its address like any other label. Values are truncated to the word
+// size, 0 and 1 are __ZERO and __ONE.
+
+// Imm an immediate operand, the address of a word holding the value
+type Imm int32
+
+// label return the label of the word holding the value
+func (self Imm) label(a *Assembler) Label {
+	w := Address(a.config.Wrap(vm.Operand(self))) & Address(a.config.MaxAddress())
+	switch w {
+	case 0:
+		return ZERO
+	case 1:
+		return ONE
+	}
+	return Label(fmt.Sprintf("__imm_%04X", uint32(w)))
+}
+
+// getAddress adds the value to the constant pool and return its
+// address
+func (self Imm) getAddress(a *Assembler) Address {
+	l := self.label(a)
+	if l != ONE && l != ZERO {
+		a.pool[l] = self
+	}
+	return l.getAddress(a)
+}
+
+// String return the value prefixed by #
+func (self Imm) String() string {
+	return fmt.Sprintf("#%d", int32(self))
+}
+
+// constant return true if v is read-only: __ONE, __ZERO, an Imm or
+// a Char
+func constant(v Labeler) bool {
+	switch v.(type) {
+	case Imm, Char:
+		return true
+	}
+	return v == Labeler(ONE) || v == Labeler(ZERO)
+}
+
+// emitPool emits the values of the Imm operands not emitted yet
+func (self *Assembler) emitPool() {
+	var labels []Label
+	for l := range self.pool {
+		if _, ok := self.labels[l]; !ok {
+			labels = append(labels, l)
+		}
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i] < labels[j] })
+	self.internal, self.read_only = true, true
+	for _, l := range labels {
+		self.Label(l)
+		self.DW(uint32(self.pool[l]))
+	}
+	self.internal, self.read_only = false, false
+}
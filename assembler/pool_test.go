@@ -0,0 +1,83 @@
+package assembler
+
+import (
+	"gosics/vm"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImm(t *testing.T) {
+	as := New()
+	as.MOV(Imm(5), Label("X"))
+	as.ADD(Label("X"), Imm(-3), Label("X"))
+	as.MOV(Imm(5), Label("Y"))
+	as.ADD(Label("Y"), Imm(1), Label("Y"))
+	as.HLT()
+	as.Label("X")
+	as.DD(0)
+	as.Label("Y")
+	as.DD(0)
+
+	c := t_runProtected(&as)
+	assert.Nil(t, c.Fault())
+	assert.Equal(t, vm.Operand(2), t_peek(&c, &as, "X"))
+	assert.Equal(t, vm.Operand(6), t_peek(&c, &as, "Y"))
+	assert.Nil(t, as.Err())
+
+	// one word per value, after the program, 1 is __ONE
+	n := len(as.Assemble())
+	assert.Equal(t, Address(n-4), as.labels["__imm_0005"])
+	assert.Equal(t, Address(n-2), as.labels["__imm_FFFD"])
+	assert.Equal(t, 2, len(as.pool))
+	assert.Equal(t, n, len(as.Assemble()))
+	assert.Equal(t, "constants", as.MemoryMap()[len(as.MemoryMap())-1].Name)
+	assert.Contains(t, t_listing(&as), "SBNZ #5, __ZERO, Y, __label_0004")
+}
+
+func TestImmWrap(t *testing.T) {
+	as := New()
+	as.MOV(Imm(0x10000), Label("X"))
+	as.MOV(Imm(0x10001), Label("X"))
+	as.MOV(Imm(0x1FFFF), Label("X"))
+	as.MOV(Imm(-1), Label("X"))
+	as.HLT()
+	as.Label("X")
+	as.DD(0)
+
+	program := as.Assemble()
+	assert.Equal(t, 1, len(as.pool))
+	assert.Equal(t, []uint8{0xFF, 0xFF}, program[as.labels["__imm_FFFF"]:][:2])
+	assert.Nil(t, as.Err())
+}
+
+func TestImmWrites(t *testing.T) {
+	as := New()
+	as.MOV(Label("X"), Imm(5))
+	as.HLT()
+	as.Label("X")
+	as.DD(0)
+
+	as.Assemble()
+	err := as.Err()
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "MOV X, #5 at 000e writes to the constant #5", err.Error())
+	}
+}
+
+func TestImmModules(t *testing.T) {
+	as := NewModule()
+	as.Label("main")
+	as.Export("main")
+	as.MOV(Imm(7), Label("X"))
+	as.HLT()
+	as.Label("X")
+	as.DD(0)
+
+	obj, err := as.Object()
+	assert.Nil(t, err)
+	assert.Contains(t, obj.Symbols, Label("__imm_0007"))
+	assert.NotContains(t, obj.Imports, Label("__imm_0007"))
+	assert.Contains(t, obj.Imports, ZERO)
+	assert.Empty(t, as.Lint())
+}
@@ -0,0 +1,103 @@
+package assembler
+
+import (
+	"fmt"
+	"gosics/vm"
+	"sort"
+)
+
+// Memory protection
+//
+// The assembler builds a memory map of the program (see
+// vm.MemoryMap) from what is emitted: instructions are executable and
+// read-only, data is writable and the constants __ONE, __ZERO and the
+// constant pool are read-only. Code flagged as self-modifying, like the runtime routines
+// used by PUSH and POP, can be written. Banks are not mapped.
+//
+// Warnings reports the instructions writing into code not flagged as
+// self-modifying, usually by accident, those writes fault with the
+// memory map.
+
+// kinds of regions
+const (
+	codeRegion = iota
+	dataRegion
+)
+
+// SelfModifying flags the code emitted from now on as self-modifying,
+// or not, writable in the memory map
+func (self *Assembler) SelfModifying(on bool) {
+	self.self_modifying = on
+}
+
+// selfModifying flags the code as self-modifying and returns a
+// function restoring the flag, intended to be deferred
+func (self *Assembler) selfModifying() func() {
+	prev := self.self_modifying
+	self.self_modifying = true
+	return func() { self.self_modifying = prev }
+}
+
+// protect adds the memory emitted since start to the memory map
+func (self *Assembler) protect(start Address, kind int) {
+	if self.delta != 0 || self.ip == start {
+		return
+	}
+	var r vm.Region
+	switch {
+	case self.self_modifying:
+		r = vm.Region{Name: "self-modifying code", Perm: vm.Read | vm.Write | vm.Execute}
+	case kind == codeRegion:
+		r = vm.Region{Name: "code", Perm: vm.Read | vm.Execute}
+	case self.read_only:
+		r = vm.Region{Name: "constants", Perm: vm.Read}
+	default:
+		r = vm.Region{Name: "data", Perm: vm.Read | vm.Write}
+	}
+	r.From, r.To = vm.Address(start), vm.Address(self.ip-1)
+	if n := len(self.regions); n > 0 {
+		last := &self.regions[n-1]
+		if last.To+1 == r.From && last.Name == r.Name {
+			last.To = r.To
+			return
+		}
+	}
+	self.regions = append(self.regions, r)
+}
+
+// MemoryMap assembles the program and returns its memory map, to be
+// used with vm.Computer.SetMemoryMap
+func (self *Assembler) MemoryMap() vm.MemoryMap {
+	self.image()
+	return append(vm.MemoryMap{}, self.regions...)
+}
+
+// instruction an instruction in the main memory, see Warnings
+type instruction struct {
+	address        Address
+	source         string // the instruction, or the macro instruction emitting it
+	self_modifying bool
+}
+
+// Warnings assembles the program and returns the instructions writing
+// into instructions not flagged as self-modifying, sorted by address.
+// Looks at the operands as assembled, not the values they may take
+// when running.
+func (self *Assembler) Warnings() []error {
+	image := self.image()
+	n := self.word()
+	width := 4 * n
+	code := append([]instruction{}, self.instructions...)
+	sort.Slice(code, func(i, j int) bool { return code[i].address < code[j].address })
+	var res []error
+	for _, in := range code {
+		c := Address(self.config.Word(image[in.address+2*n : in.address+3*n]))
+		for _, target := range code {
+			if !target.self_modifying && c < target.address+width && target.address < c+n {
+				res = append(res, fmt.Errorf("%s at %04x writes into the instruction at %04x (%s)",
+					in.source, uint32(in.address), uint32(target.address), target.source))
+			}
+		}
+	}
+	return res
+}
@@ -0,0 +1,163 @@
+package assembler
+
+import (
+	"gosics/vm"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// t_runProtected like t_runUntilHalted, with the memory map of the
+// program
+func t_runProtected(a *Assembler) vm.Computer {
+	c := vm.Computer{}
+	c.LoadMemory(a.Assemble())
+	c.SetMemoryMap(a.MemoryMap())
+	for i := 0; i < 10000 && !c.Halted(); i++ {
+		c.Step()
+	}
+	return c
+}
+
+func TestMemoryMap(t *testing.T) {
+	as := New()
+	as.MOV(Label("SRC"), Label("DST"))
+	as.HLT()
+	as.Label("SRC")
+	as.DD(0x1234)
+	as.Label("DST")
+	as.DD(0)
+
+	assert.Equal(t, vm.MemoryMap{
+		{Name: "code", From: 0x00, To: 0x07, Perm: vm.Read | vm.Execute},
+		{Name: "constants", From: 0x08, To: 0x0B, Perm: vm.Read},
+		{Name: "data", From: 0x0C, To: 0x0D, Perm: vm.Read | vm.Write},
+		{Name: "code", From: 0x0E, To: 0x1D, Perm: vm.Read | vm.Execute},
+		{Name: "data", From: 0x1E, To: 0x21, Perm: vm.Read | vm.Write},
+	}, as.MemoryMap())
+}
+
+func TestProtectedProgramsRun(t *testing.T) {
+	as := New()
+	as.PUSH(Label("SRC"))
+	as.POP(Label("DST"))
+	as.BLTZ(Label("NEG"), Label("NEGATIVE"))
+	as.HLT()
+	as.Label("NEGATIVE")
+	as.NOT(Label("DST"), Label("DST"))
+	as.HLT()
+	as.Label("SRC")
+	as.DD(0x1234)
+	as.Label("NEG")
+	as.DD(0xFFFF)
+	as.Label("DST")
+	as.DD(0)
+
+	c := t_runProtected(&as)
+	assert.Nil(t, c.Fault())
+	assert.True(t, c.Halted())
+	assert.Equal(t, ^vm.Operand(0x1234), t_peek(&c, &as, "DST"))
+}
+
+func TestWriteToConstantsFaults(t *testing.T) {
+	as := New()
+	as.MOV(ONE, ZERO)
+	as.HLT()
+
+	c := t_runProtected(&as)
+	f, ok := c.Fault().(*vm.Fault)
+	assert.True(t, ok)
+	assert.Equal(t, vm.Write, f.Access)
+	assert.Equal(t, "constants", f.Region.Name)
+	assert.Equal(t, vm.Address(0x0A), f.Address)
+	assert.Equal(t, vm.Address(0x0E), f.IP)
+	assert.Equal(t, vm.Operand(0), t_peek(&c, &as, string(ZERO)))
+}
+
+func TestSelfModifyingCode(t *testing.T) {
+	program := func(flag bool) Assembler {
+		as := New()
+		// patch the D operand of the jump below, to the HLT
+		as.MOV(Label("TARGET"), as.at(7))
+		as.SelfModifying(flag)
+		as.JMP(Label("__start"))
+		as.SelfModifying(false)
+		as.Label("END")
+		as.HLT()
+		as.Label("TARGET")
+		as.addresses(Label("END"))
+		return as
+	}
+
+	as := program(false)
+	assert.Equal(t, 1, len(as.Warnings()))
+	c := t_runProtected(&as)
+	assert.NotNil(t, c.Fault())
+	assert.Equal(t, "code", c.Fault().(*vm.Fault).Region.Name)
+
+	as = program(true)
+	assert.Nil(t, as.Warnings())
+	c = t_runProtected(&as)
+	assert.Nil(t, c.Fault())
+	assert.True(t, c.Halted())
+}
+
+func TestWarnings(t *testing.T) {
+	as := New()
+	as.PUSH(Label("SRC"))
+	as.POP(Label("__start"))
+	// the D operand of the last instruction of POP, followed by a word
+	as.SBNZ(Label("SRC"), ZERO, as.at(-2), HLT)
+	as.Label("SRC")
+	as.DD(1)
+
+	warnings := as.Warnings()
+	assert.Equal(t, 2, len(warnings))
+	assert.Equal(t, "POP __start at 0040 writes into the instruction at 000e (PUSH SRC)", warnings[0].Error())
+	assert.Equal(t, "SBNZ SRC, __ZERO, 0x004E, HLT at 0052 writes into the instruction at 0048 (POP __start)",
+		warnings[1].Error())
+}
+
+func TestNoWarnings(t *testing.T) {
+	as := New()
+	as.PUSH(Label("SRC"))
+	as.POP(Label("DST"))
+	as.ADD(Label("SRC"), Label("DST"), Label("DST"))
+	as.HLT()
+	as.Label("SRC")
+	as.DD(1)
+	as.Label("DST")
+	as.DD(0)
+	assert.Nil(t, as.Warnings())
+}
+
+func TestWritesToConstantsAreRejected(t *testing.T) {
+	as := New()
+	as.MOV(Label("SRC"), ZERO)
+	as.SBNZ(Label("SRC"), ZERO, ONE, HLT)
+	as.MOV(Label("SRC"), JUNK)
+	as.HLT()
+	as.Label("SRC")
+	as.DD(1)
+
+	err := as.Err()
+	assert.NotNil(t, err)
+	assert.Equal(t, "MOV SRC, __ZERO at 000e writes to the constant __ZERO; "+
+		"SBNZ SRC, __ZERO, __ONE, HLT at 0016 writes to the constant __ONE", err.Error())
+}
+
+func TestWatchConstants(t *testing.T) {
+	as := New()
+	// the address of __ZERO, not detected by the assembler
+	as.MOV(ONE, Address(0x0A))
+	as.HLT()
+	assert.Nil(t, as.Err())
+
+	c := vm.Computer{}
+	c.LoadMemory(as.Assemble())
+	c.WatchConstants(t_resolve(&as, string(ONE)), t_resolve(&as, string(ZERO)))
+	for i := 0; i < 100 && !c.Halted(); i++ {
+		c.Step()
+	}
+	assert.Equal(t, &vm.Fault{IP: 0x0E, Address: 0x0A, Reason: "constant modified"}, c.Fault())
+}
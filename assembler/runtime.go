@@ -0,0 +1,201 @@
+package assembler
+
+import (
+	"container/list"
+	"fmt"
+	"gosics/vm"
+)
+
+// Runtime routines
+//
+// Some macro instructions rely on support code, the runtime routines.
+// Routines are emitted at the end of the program when assembling,
+// and only if some label defined by them is referenced, so programs
+// don't pay for the routines they don't use.
+
+// routine describes a runtime routine
+type routine struct {
+	name   string
+	labels []Label // labels defined by the routine
+	emit   func(self *Assembler)
+}
+
+// routines registry of runtime routines. The order is relevant, it's
+// the order in which routines are emitted.
+var routines = []routine{
+	{"constants", []Label{ONE, ZERO, JUNK}, (*Assembler).emitConstants},
+	{"push", []Label{"__push", "__push_ret"}, (*Assembler).emitPush},
+	{"pop", []Label{"__pop", "__pop_ret"}, (*Assembler).emitPop},
+	{"stack", []Label{"__push_operand", "__SP", "__SP_base", "__SP_limit", StackFault},
+		(*Assembler).emitStack},
+	{"stack_overflow", []Label{"__stack_overflow"}, (*Assembler).emitStackOverflow},
+	{"stack_underflow", []Label{"__stack_underflow"}, (*Assembler).emitStackUnderflow},
+	{"far", []Label{"__far_jump", "__far_bank", "__far_target"}, (*Assembler).emitFar},
+	{"memcpy", libraryLabels("memcpy", 3, 0), (*Assembler).emitMemcpy},
+	{"memset", libraryLabels("memset", 3, 0), (*Assembler).emitMemset},
+	{"strlen", libraryLabels("strlen", 1, 1), (*Assembler).emitStrlen},
+	{"strcmp", libraryLabels("strcmp", 2, 1), (*Assembler).emitStrcmp},
+	{"itoa", libraryLabels("itoa", 2, 0), (*Assembler).emitItoa},
+	{"atoi", libraryLabels("atoi", 1, 1), (*Assembler).emitAtoi},
+	{"abs", libraryLabels("abs", 1, 1), (*Assembler).emitAbs},
+	{"minmax", libraryLabels("minmax", 2, 2), (*Assembler).emitMinmax},
+	{"rand", libraryLabels("rand", 0, 1, randState), (*Assembler).emitRand},
+}
+
+// referenced return true if some label of the routine is referenced
+// and not defined
+func (self *Assembler) referenced(r *routine) bool {
+	for _, l := range r.labels {
+		_, defined := self.labels[l]
+		if _, ok := self.unresolved[l]; ok && !defined {
+			return true
+		}
+	}
+	return false
+}
+
+// emitRuntime emits the routines referenced by the program, and the
+// ones referenced by them. Returns the labels defined by the emitted
+// routines.
+func (self *Assembler) emitRuntime() []Label {
+	self.internal = true
+	defer func() { self.internal = false }()
+	var res []Label
+	for changed := true; changed; {
+		changed = false
+		for i := range routines {
+			r := &routines[i]
+			if !self.routines[r.name] && self.referenced(r) {
+				self.routines[r.name] = true
+				if r.name == "far" && self.banks == nil {
+					self.errs = append(self.errs, fmt.Errorf("%s: banks not configured", r.labels[0]))
+					continue
+				}
+				r.emit(self)
+				res = append(res, r.labels...)
+				changed = true
+			}
+		}
+	}
+	return res
+}
+
+// RuntimeObject return an object with the runtime routines defining
+// the given labels, intended to be used by the linker. Labels not
+// defined by any routine are ignored.
+func RuntimeObject(config vm.Config, labels ...Label) (*Object, error) {
+	ass := NewModule(WithConfig(config))
+	for _, l := range labels {
+		// referenced from nowhere
+		ass.unresolved[l] = list.New()
+	}
+	ass.Export(ass.emitRuntime()...)
+	if err := ass.Err(); err != nil {
+		return nil, err
+	}
+	obj, err := ass.Object()
+	if err != nil {
+		return nil, err
+	}
+	obj.Name = "runtime"
+	return obj, nil
+}
+
+// emitConstants emits the constants used by most macro instructions.
+// Usually they are part of the preamble.
+func (self *Assembler) emitConstants() {
+	self.read_only = true
+	self.Label(ONE)
+	self.DD(1)
+	self.Label(ZERO)
+	self.DD(0)
+	self.read_only = false
+	self.Label(JUNK)
+	self.DD(0)
+}
+
+// emitStack emits the data used by PUSH and POP
+func (self *Assembler) emitStack() {
+	self.Label(Label("__push_operand"))
+	self.DD(0xFABA)
+	self.Label(Label("__SP"))
+	self.DW(uint32(self.stack.Base))
+	self.Label(Label("__SP_base"))
+	self.DW(uint32(self.stack.Base))
+	self.Label(Label("__SP_limit"))
+	self.DW(uint32(self.stack.limit(self.word())))
+	self.Label(StackFault)
+	self.DD(0)
+}
+
+// emitStackFault emits a handler that stores the marker in
+// __stack_fault and halts
+func (self *Assembler) emitStackFault(marker uint32) {
+	data := self.uniqLabel()
+	self.MOV(data, StackFault)
+	self.HLT()
+	self.Label(data)
+	self.DW(marker)
+}
+
+// emitStackOverflow emits the default handler for stack overflows
+func (self *Assembler) emitStackOverflow() {
+	self.Label(Label("__stack_overflow"))
+	self.emitStackFault(StackOverflow)
+}
+
+// emitStackUnderflow emits the default handler for stack underflows
+func (self *Assembler) emitStackUnderflow() {
+	self.Label(Label("__stack_underflow"))
+	self.emitStackFault(StackUnderflow)
+}
+
+// emitPush emits the support code for PUSH
+func (self *Assembler) emitPush() {
+	defer self.selfModifying()()
+	self.Label(Label("__push"))
+	// check for overflow, the stack is full when SP reaches the limit
+	ok := self.uniqLabel()
+	self.SBNZ(Label("__SP"), Label("__SP_limit"), JUNK, ok)
+	self.JMP(self.stack.overflow())
+	self.Label(ok)
+	// copy SP in the C parameter of the next instruction
+	self.SBNZ(Label("__SP"), ZERO, self.at(6), self.at(4))
+	// copy value from __push_operand to the stack. The C operand has
+	// been overwriten so that it point to the top of the stack
+	self.SBNZ(Label("__push_operand"), ZERO, maxAddress-1, self.at(4))
+	// decrease the stack pointer once per byte of the word
+	for i := Address(0); i < self.word(); i++ {
+		self.SBNZ(Label("__SP"), ONE, Label("__SP"), self.at(4))
+	}
+	// "return" to the caller. He caller must copy in __push_ret the
+	// return address
+	self.addresses(ONE, ZERO, JUNK)
+	self.Label(Label("__push_ret"))
+	self.DD(0xFFFF)
+}
+
+// emitPop emits the support code for POP
+func (self *Assembler) emitPop() {
+	defer self.selfModifying()()
+	self.Label(Label("__pop"))
+	// check for underflow, the stack is empty when SP is at the base
+	ok := self.uniqLabel()
+	self.SBNZ(Label("__SP"), Label("__SP_base"), JUNK, ok)
+	self.JMP(self.stack.underflow())
+	self.Label(ok)
+	// increase the stack pointer once per byte of the word, first we
+	// need -1 (SP - -1 == SP + 1)
+	self.SBNZ(ZERO, ONE, JUNK, self.at(4))
+	for i := Address(0); i < self.word(); i++ {
+		self.SBNZ(Label("__SP"), JUNK, Label("__SP"), self.at(4))
+	}
+	// copy SP in the A parameter of the next instruction
+	self.SBNZ(Label("__SP"), ZERO, self.at(4), self.at(4))
+	// copy the value from the stack to __push_operand
+	self.SBNZ(maxAddress-1, ZERO, Label("__push_operand"), self.at(4))
+	// return to the "caller"
+	self.addresses(ONE, ZERO, JUNK)
+	self.Label(Label("__pop_ret"))
+	self.DD(0xFFFF)
+}
@@ -0,0 +1,523 @@
+package assembler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Assembler source
+//
+// Source assembles programs written as text, one instruction or
+// directive per line, optionally preceded by a label. Comments start
+// with a semicolon:
+//
+//	LOOP:   DEC N           ; count down
+//	        BEQ N, ZERO, END
+//	        JMP LOOP
+//	END:    HLT
+//	N:      DD 10
+//
+// The operands are labels, literal addresses, immediate values (#5,
+// see Imm) and the names HLT, ONE, ZERO and JUNK. The instructions are
+// the macro instructions of the Assembler taking addresses, the
+// directives DB, DD, DW, DS and EXPORT.
+//
+// MACRO defines a macro instruction, the lines up to ENDM are
+// expanded when invoked replacing the parameters by the operands:
+//
+//	MACRO SWAP(a, b)
+//	        MOV a, T
+//	        MOV b, a
+//	        MOV T, b
+//	        JMP next
+//	T:      DD 0
+//	next:
+//	ENDM
+//
+//	        SWAP X, Y
+//
+// The labels defined by a macro are local to each expansion, renamed
+// with uniqLabel. Macros may invoke other macros, up to maxNesting
+// expansions deep, but not themselves: recursive macros are an error,
+// reported once, that stops the expansion. The expansion happens when
+// IP is known, macros can generate jumps.
+
+// maxNesting the deepest expansion of macros
+const maxNesting = 16
+
+// opcode an instruction available in the source
+type opcode struct {
+	operands int
+	emit     func(a *Assembler, o []Labeler)
+}
+
+func op0(f func(*Assembler)) opcode {
+	return opcode{0, func(a *Assembler, o []Labeler) { f(a) }}
+}
+
+func op1(f func(*Assembler, Labeler)) opcode {
+	return opcode{1, func(a *Assembler, o []Labeler) { f(a, o[0]) }}
+}
+
+func op2(f func(*Assembler, Labeler, Labeler)) opcode {
+	return opcode{2, func(a *Assembler, o []Labeler) { f(a, o[0], o[1]) }}
+}
+
+func op3(f func(*Assembler, Labeler, Labeler, Labeler)) opcode {
+	return opcode{3, func(a *Assembler, o []Labeler) { f(a, o[0], o[1], o[2]) }}
+}
+
+// opcodes the instructions available in the source, by name
+var opcodes = map[string]opcode{
+	"SBNZ": {4, func(a *Assembler, o []Labeler) { a.SBNZ(o[0], o[1], o[2], o[3]) }},
+	// synthesized instructions
+	"SUBLEQ": op3((*Assembler).SUBLEQ),
+	"MOV":    op2((*Assembler).MOV),
+	"JMP":    op1((*Assembler).JMP),
+	"BEQ":    op3((*Assembler).BEQ),
+	"BLTZ":   op2((*Assembler).BLTZ),
+	"BLEZ":   op2((*Assembler).BLEZ),
+	"HLT":    op0((*Assembler).HLT),
+	"NOP":    op0((*Assembler).NOP),
+	"NEG":    op2((*Assembler).NEG),
+	"ADD":    op3((*Assembler).ADD),
+	"SUB":    op3((*Assembler).SUB),
+	"INC":    op1((*Assembler).INC),
+	"DEC":    op1((*Assembler).DEC),
+	"NOT":    op2((*Assembler).NOT),
+	"PUSH":   op1((*Assembler).PUSH),
+	"POP":    op1((*Assembler).POP),
+	// interrupts
+	"TIMER":  op1((*Assembler).TIMER),
+	"VECTOR": op1((*Assembler).VECTOR),
+	"EI":     op0((*Assembler).EI),
+	"DI":     op0((*Assembler).DI),
+	"RETI":   op0((*Assembler).RETI),
+	// standard library
+	"MEMCPY": op3((*Assembler).MEMCPY),
+	"MEMSET": op3((*Assembler).MEMSET),
+	"STRLEN": op2((*Assembler).STRLEN),
+	"STRCMP": op3((*Assembler).STRCMP),
+	"ITOA":   op2((*Assembler).ITOA),
+	"ATOI":   op2((*Assembler).ATOI),
+	"ABS":    op2((*Assembler).ABS),
+	"MIN":    op3((*Assembler).MIN),
+	"MAX":    op3((*Assembler).MAX),
+	"RAND":   op1((*Assembler).RAND),
+	"SRAND":  op1((*Assembler).SRAND),
+}
+
+// sourceLine a line of the source without the comment
+type sourceLine struct {
+	text string
+	line int
+}
+
+// macroDef a macro instruction defined in the source
+type macroDef struct {
+	params []string
+	labels []string // defined in the body, local to each expansion
+	body   []sourceLine
+	line   int
+}
+
+// parser assembles the lines of a source
+type parser struct {
+	as      *Assembler
+	name    string
+	macros  map[string]*macroDef
+	def     *macroDef // being defined, until ENDM
+	defName string
+	depth   int             // nested expansions
+	active  map[string]bool // the macros being expanded
+	failed  bool            // a nesting error, stops the expansion
+}
+
+// Source assembles the program read from r, the source. name
+// identifies the source in the error messages and in the positions
+// reported by Lint.
+func (self *Assembler) Source(name string, r io.Reader) {
+	p := parser{as: self, name: name, macros: make(map[string]*macroDef), active: make(map[string]bool)}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		p.parse(sourceLine{stripComment(scanner.Text()), n})
+	}
+	self.line = nil
+	if err := scanner.Err(); err != nil {
+		self.errs = append(self.errs, fmt.Errorf("%s: %s", name, err))
+	}
+	if p.def != nil {
+		p.errorf(p.def.line, "MACRO %s without ENDM", p.defName)
+	}
+}
+
+// errorf records an error at the line n of the source
+func (self *parser) errorf(n int, format string, args ...interface{}) {
+	err := fmt.Errorf("%s:%d: %s", self.name, n, fmt.Sprintf(format, args...))
+	self.as.errs = append(self.as.errs, err)
+}
+
+// parse assembles a line, or adds it to the macro being defined. The
+// lines of an expansion are numbered as the invocation.
+func (self *parser) parse(l sourceLine) {
+	label, op, operands := splitLine(l.text)
+	if self.def != nil {
+		switch op {
+		case "ENDM":
+			self.def = nil
+		case "MACRO":
+			self.errorf(l.line, "MACRO %s inside MACRO %s", operands, self.defName)
+		default:
+			if label != "" {
+				self.def.labels = append(self.def.labels, label)
+			}
+			self.def.body = append(self.def.body, l)
+		}
+		return
+	}
+	self.as.line = &position{self.name, l.line}
+	if label != "" {
+		if !identifier(label) {
+			self.errorf(l.line, "invalid label %q", label)
+		} else {
+			self.as.Label(Label(label))
+		}
+	}
+	switch op {
+	case "":
+		if operands != "" {
+			self.errorf(l.line, "syntax error %s", operands)
+		}
+	case "MACRO":
+		self.define(l.line, operands)
+	case "ENDM":
+		self.errorf(l.line, "ENDM without MACRO")
+	case "DB", "DD", "DW":
+		self.data(l.line, op, operands)
+	case "DS":
+		self.text(l.line, operands)
+	case "EXPORT":
+		for _, s := range splitOperands(operands) {
+			if !identifier(s) {
+				self.errorf(l.line, "EXPORT: invalid label %q", s)
+				continue
+			}
+			self.as.Export(Label(s))
+		}
+	default:
+		self.instruction(l.line, op, splitOperands(operands))
+	}
+}
+
+// define starts the definition of a macro, name(params). The body of
+// invalid definitions is skipped too.
+func (self *parser) define(n int, text string) {
+	_, name, params := splitLine(text)
+	def := &macroDef{params: splitOperands(params), line: n}
+	self.def, self.defName = def, name
+	switch {
+	case !identifier(name):
+		self.errorf(n, "MACRO: invalid name %q", name)
+		return
+	case self.macros[name] != nil:
+		self.errorf(n, "MACRO %s already defined", name)
+		return
+	case opcodes[name].emit != nil:
+		self.errorf(n, "MACRO %s redefines an instruction", name)
+		return
+	}
+	for _, p := range def.params {
+		if !identifier(p) {
+			self.errorf(n, "MACRO %s: invalid parameter %q", name, p)
+			return
+		}
+	}
+	self.macros[name] = def
+}
+
+// instruction emits the instruction or expands the macro name
+func (self *parser) instruction(n int, name string, operands []string) {
+	if def, ok := self.macros[name]; ok {
+		self.expand(n, name, def, operands)
+		return
+	}
+	op, ok := opcodes[name]
+	if !ok {
+		self.errorf(n, "unknown instruction %s", name)
+		return
+	}
+	if len(operands) != op.operands {
+		self.errorf(n, "%s: %d operands expected, got %d", name, op.operands, len(operands))
+		return
+	}
+	args := make([]Labeler, len(operands))
+	for i, s := range operands {
+		a, err := operand(s)
+		if err != nil {
+			self.errorf(n, "%s: %s", name, err)
+			return
+		}
+		args[i] = a
+	}
+	op.emit(self.as, args)
+}
+
+// expand assembles the body of the macro, the parameters replaced by
+// the operands and the labels by unique ones
+func (self *parser) expand(n int, name string, def *macroDef, operands []string) {
+	if len(operands) != len(def.params) {
+		self.errorf(n, "%s: %d operands expected, got %d", name, len(def.params), len(operands))
+		return
+	}
+	switch {
+	case self.active[name]:
+		self.errorf(n, "%s: recursive macro", name)
+		self.failed = true
+		return
+	case self.depth >= maxNesting:
+		self.errorf(n, "%s: macros nested too deep", name)
+		self.failed = true
+		return
+	}
+	self.active[name] = true
+	self.depth++
+	defer func() {
+		delete(self.active, name)
+		self.depth--
+		if self.depth == 0 {
+			self.failed = false
+		}
+	}()
+	subst := make(map[string]string)
+	args := make([]Labeler, len(operands))
+	for i, p := range def.params {
+		subst[p] = operands[i]
+		if a, err := operand(operands[i]); err == nil {
+			args[i] = a
+		} else {
+			args[i] = Label(operands[i])
+		}
+	}
+	for _, l := range def.labels {
+		if _, ok := subst[l]; !ok {
+			subst[l] = string(self.as.uniqLabel())
+		}
+	}
+	defer self.as.beginMacro(name, args...)()
+	for _, l := range def.body {
+		if self.failed {
+			return
+		}
+		self.parse(sourceLine{substitute(l.text, subst), n})
+	}
+}
+
+// data emits the numbers of a DB, DD or DW directive
+func (self *parser) data(n int, directive string, operands string) {
+	bits := map[string]uint{"DB": 8, "DD": 16, "DW": 32}[directive]
+	var values []uint32
+	for _, s := range splitOperands(operands) {
+		v, err := number(s)
+		if err != nil || v < -1<<(bits-1) || v >= 1<<bits {
+			self.errorf(n, "%s: invalid value %s", directive, s)
+			return
+		}
+		values = append(values, uint32(v))
+	}
+	switch directive {
+	case "DB":
+		b := make([]uint8, len(values))
+		for i, v := range values {
+			b[i] = uint8(v)
+		}
+		self.as.DB(b...)
+	case "DD":
+		d := make([]uint16, len(values))
+		for i, v := range values {
+			d[i] = uint16(v)
+		}
+		self.as.DD(d...)
+	default:
+		self.as.DW(values...)
+	}
+}
+
+// text emits a DS directive, "text"[, flag...]
+func (self *parser) text(n int, operands string) {
+	quoted, err := strconv.QuotedPrefix(operands)
+	if err != nil {
+		self.errorf(n, "DS: invalid text %s", operands)
+		return
+	}
+	text, _ := strconv.Unquote(quoted)
+	rest := strings.TrimSpace(operands[len(quoted):])
+	var flags []StringFlag
+	if rest != "" {
+		if !strings.HasPrefix(rest, ",") {
+			self.errorf(n, "DS: invalid flags %s", rest)
+			return
+		}
+	next:
+		for _, s := range splitOperands(rest[1:]) {
+			for _, f := range []StringFlag{Packed, ZeroTerminated, LengthPrefixed} {
+				if s == f.String() {
+					flags = append(flags, f)
+					continue next
+				}
+			}
+			self.errorf(n, "DS: invalid flag %s", s)
+			return
+		}
+	}
+	self.as.DS(text, flags...)
+}
+
+// operand return the address named by s
+func operand(s string) (Labeler, error) {
+	switch s {
+	case "HLT":
+		return HLT, nil
+	case "ONE":
+		return ONE, nil
+	case "ZERO":
+		return ZERO, nil
+	case "JUNK":
+		return JUNK, nil
+	}
+	switch {
+	case strings.HasPrefix(s, "#"):
+		v, err := number(s[1:])
+		if err != nil || v < -1<<31 || v >= 1<<31 {
+			return nil, fmt.Errorf("invalid immediate value %s", s)
+		}
+		return Imm(v), nil
+	case identifier(s):
+		return Label(s), nil
+	}
+	v, err := number(s)
+	if err != nil || v < 0 || v >= 1<<32 {
+		return nil, fmt.Errorf("invalid address %s", s)
+	}
+	return Address(v), nil
+}
+
+// number parses a decimal, hexadecimal (0x), octal (0o) or binary
+// (0b) number, or a character between single quotes
+func number(s string) (int64, error) {
+	if strings.HasPrefix(s, "'") {
+		c, err := strconv.Unquote(s)
+		if err != nil || len([]rune(c)) != 1 {
+			return 0, fmt.Errorf("invalid character %s", s)
+		}
+		return int64([]rune(c)[0]), nil
+	}
+	return strconv.ParseInt(s, 0, 64)
+}
+
+// identifierByte return true if c may be part of an identifier
+func identifierByte(c byte) bool {
+	return c == '_' || c == '.' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// identifier return true if s is a valid name for a label, a macro or
+// a parameter
+func identifier(s string) bool {
+	if s == "" || s[0] >= '0' && s[0] <= '9' {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !identifierByte(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// stripComment removes the comment, from a semicolon outside quotes
+// to the end of the line, and the surrounding spaces
+func stripComment(line string) string {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0 && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ';':
+			return strings.TrimSpace(line[:i])
+		}
+	}
+	return strings.TrimSpace(line)
+}
+
+// splitLine splits a line into the label, the instruction and the
+// operands. The operands may be enclosed in parentheses.
+func splitLine(line string) (label, op, operands string) {
+	if i := strings.IndexByte(line, ':'); i >= 0 && !strings.ContainsAny(line[:i], "\"'") {
+		label, line = strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+	}
+	i := 0
+	for i < len(line) && identifierByte(line[i]) {
+		i++
+	}
+	op, operands = line[:i], strings.TrimSpace(line[i:])
+	if strings.HasPrefix(operands, "(") && strings.HasSuffix(operands, ")") {
+		operands = strings.TrimSpace(operands[1 : len(operands)-1])
+	}
+	return label, op, operands
+}
+
+// splitOperands return the comma separated operands
+func splitOperands(operands string) []string {
+	if operands == "" {
+		return nil
+	}
+	res := strings.Split(operands, ",")
+	for i := range res {
+		res[i] = strings.TrimSpace(res[i])
+	}
+	return res
+}
+
+// substitute replaces the identifiers of text found in subst, except
+// within quotes
+func substitute(text string, subst map[string]string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); {
+		switch c := text[i]; {
+		case c == '"' || c == '\'':
+			j := i + 1
+			for ; j < len(text) && text[j] != c; j++ {
+				if text[j] == '\\' {
+					j++
+				}
+			}
+			if j++; j > len(text) {
+				j = len(text)
+			}
+			b.WriteString(text[i:j])
+			i = j
+		case identifierByte(c):
+			j := i
+			for j < len(text) && identifierByte(text[j]) {
+				j++
+			}
+			word := text[i:j]
+			if s, ok := subst[word]; ok {
+				word = s
+			}
+			b.WriteString(word)
+			i = j
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,189 @@
+package assembler
+
+import (
+	"fmt"
+	"gosics/vm"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// t_source assembles the source
+func t_source(text string) Assembler {
+	as := New()
+	as.Source("test.s", strings.NewReader(text))
+	return as
+}
+
+func TestSource(t *testing.T) {
+	as := t_source(`
+; count down from 10
+LOOP:   DEC N           ; N--
+        BEQ N, ZERO, END
+        JMP LOOP
+END:    ADD N, #5, N
+        HLT
+N:      DD 10
+`)
+	expected := New()
+	expected.Label("LOOP")
+	expected.DEC(Label("N"))
+	expected.BEQ(Label("N"), ZERO, Label("END"))
+	expected.JMP(Label("LOOP"))
+	expected.Label("END")
+	expected.ADD(Label("N"), Imm(5), Label("N"))
+	expected.HLT()
+	expected.Label("N")
+	expected.DD(10)
+
+	assert.Equal(t, expected.Assemble(), as.Assemble())
+	assert.Nil(t, as.Err())
+	c := t_runUntilHalted(&as)
+	assert.True(t, c.Halted())
+	assert.Equal(t, vm.Operand(5), t_peek(&c, &as, "N"))
+}
+
+func TestSourceDirectives(t *testing.T) {
+	as := t_source(`
+        SBNZ ONE, ZERO, JUNK, HLT
+B:      DB 1, 0xFF, -1
+D:      DD -1, 'A', 0o17
+W:      DW 0x12345678
+S:      DS "a;b, c", ZeroTerminated
+        EXPORT B, S
+`)
+	mem := as.Assemble()
+	assert.Nil(t, as.Err())
+	b, d, w, s := t_resolve(&as, "B"), t_resolve(&as, "D"), t_resolve(&as, "W"), t_resolve(&as, "S")
+	assert.Equal(t, []uint8{1, 0xFF, 0xFF}, mem[b:b+3])
+	assert.Equal(t, []uint8{0xFF, 0xFF, 0, 'A', 0, 0o17}, mem[d:d+6])
+	assert.Equal(t, []uint8{0x56, 0x78}, mem[w:w+2])
+	assert.Equal(t, []uint8{0, 'a', 0, ';', 0, 'b', 0, ',', 0, ' ', 0, 'c', 0, 0}, mem[s:s+14])
+	assert.True(t, as.exports["B"])
+	assert.True(t, as.exports["S"])
+	assert.False(t, as.exports["W"])
+}
+
+func TestSourceMacro(t *testing.T) {
+	as := t_source(`
+MACRO SWAP(a, b)
+        MOV a, T
+        MOV b, a
+        MOV T, b
+        JMP next
+T:      DD 0
+next:
+ENDM
+
+        SWAP X, Y
+        SWAP Y, Z
+        HLT
+X:      DD 1
+Y:      DD 2
+Z:      DD 3
+`)
+	c := t_runUntilHalted(&as)
+	assert.Nil(t, as.Err())
+	assert.True(t, c.Halted())
+	assert.Equal(t, []vm.Operand{2, 3, 1}, t_words(&c, &as, "X", 3))
+	_, ok := as.labels["T"]
+	assert.False(t, ok)
+	assert.Contains(t, t_listing(&as), "SBNZ X, __ZERO, __label_0001, __label_0003")
+}
+
+func TestSourceMacroInvocations(t *testing.T) {
+	as := t_source(`
+MACRO ADDI(x, n)
+        ADD x, #n, x
+ENDM
+MACRO ADD3(x, n)
+again:  ADDI(x, n)
+ENDM
+        ADD3 X, 2
+        ADD3(X, 3)
+        HLT
+X:      DD 1
+`)
+	c := t_runUntilHalted(&as)
+	assert.Nil(t, as.Err())
+	assert.Equal(t, vm.Operand(6), t_peek(&c, &as, "X"))
+	assert.Equal(t, []string{"ADD3 X, 0x0002", "ADD3 X, 0x0003", "HLT"}, t_macros(&as))
+}
+
+// t_macros return the top level macro instructions of the listing
+func t_macros(as *Assembler) []string {
+	var res []string
+	as.Assemble()
+	for _, r := range as.records {
+		if r.macro != "" && !r.internal && (len(res) == 0 || res[len(res)-1] != r.macro) {
+			res = append(res, r.macro)
+		}
+	}
+	return res
+}
+
+func TestSourceRecursiveMacro(t *testing.T) {
+	for _, text := range []string{
+		"MACRO LOOP(x)\n INC x\n LOOP x\nENDM\n LOOP X\nX: DD 0",
+		"MACRO R\n R\n R\nENDM\n R",
+		"MACRO R\n R\n R\n R\nENDM\n R",
+		"MACRO A\n B\n B\nENDM\nMACRO B\n A\n A\nENDM\n A",
+	} {
+		as := t_source(text)
+		if assert.NotNil(t, as.Err(), text) {
+			assert.Regexp(t, `^test.s:\d+: [A-Z]+: recursive macro$`, as.Err().Error(), text)
+		}
+	}
+}
+
+func TestSourceNestedMacros(t *testing.T) {
+	text := "MACRO M0\n INC X\nENDM\n"
+	for i := 1; i <= maxNesting; i++ {
+		text += fmt.Sprintf("MACRO M%d\n M%d\nENDM\n", i, i-1)
+	}
+	as := t_source(text + " M15\n M16\n M15\n HLT\nX: DD 0")
+	assert.NotNil(t, as.Err())
+	assert.Equal(t, "test.s:53: M0: macros nested too deep", as.Err().Error())
+	c := t_runUntilHalted(&as)
+	assert.Equal(t, vm.Operand(2), t_peek(&c, &as, "X"))
+}
+
+func TestSourceErrors(t *testing.T) {
+	for _, c := range []struct {
+		text, err string
+	}{
+		{"FOO X", "test.s:1: unknown instruction FOO"},
+		{"MOV X", "test.s:1: MOV: 2 operands expected, got 1"},
+		{"JMP 0x1FFFFFFFF", "test.s:1: JMP: invalid address 0x1FFFFFFFF"},
+		{"JMP #x", "test.s:1: JMP: invalid immediate value #x"},
+		{"1X: HLT", "test.s:1: invalid label \"1X\""},
+		{"DB 256", "test.s:1: DB: invalid value 256"},
+		{"DD -32769", "test.s:1: DD: invalid value -32769"},
+		{"DS hello", "test.s:1: DS: invalid text hello"},
+		{"DS \"x\", Wide", "test.s:1: DS: invalid flag Wide"},
+		{"#5", "test.s:1: syntax error #5"},
+		{"\nENDM", "test.s:2: ENDM without MACRO"},
+		{"MACRO M(a)\nMOV a, a", "test.s:1: MACRO M without ENDM"},
+		{"MACRO M(a)\nMACRO N\nENDM", "test.s:2: MACRO N inside MACRO M"},
+		{"MACRO MOV(a)\nENDM", "test.s:1: MACRO MOV redefines an instruction"},
+		{"MACRO M\nENDM\nMACRO M\nENDM", "test.s:3: MACRO M already defined"},
+		{"MACRO M(a, 2)\nENDM", "test.s:1: MACRO M: invalid parameter \"2\""},
+		{"MACRO M(a)\nENDM\nM X, Y", "test.s:3: M: 1 operands expected, got 2"},
+	} {
+		as := t_source(c.text)
+		if assert.NotNil(t, as.Err(), c.text) {
+			assert.Equal(t, c.err, as.Err().Error(), c.text)
+		}
+	}
+}
+
+func TestSourceLint(t *testing.T) {
+	as := t_source(`
+        JMP end
+        INC X
+end:    HLT
+X:      DD 0
+`)
+	assert.Equal(t, []Diagnostic{{"test.s", 3, "INC X is unreachable, follows JMP end"}}, as.Lint())
+}
@@ -0,0 +1,95 @@
+package assembler
+
+import (
+	"fmt"
+	"gosics/vm"
+)
+
+// Stack describes the stack used by the PUSH and POP macro
+// instructions. The stack grows downwards, from Base to lower
+// addresses. PUSH and POP check for overflows and underflows at
+// runtime and jump to the handlers. The default handlers store a
+// marker, StackOverflow or StackUnderflow, in the word labeled
+// StackFault and halt the computer.
+type Stack struct {
+	// Base address of the first word pushed
+	Base Address
+	// Size capacity of the stack, in words
+	Size uint16
+	// Overflow label of the overflow handler, if empty use the
+	// default handler
+	Overflow Label
+	// Underflow label of the underflow handler, if empty use the
+	// default handler
+	Underflow Label
+}
+
+// DefaultStack 256 words at the top of the memory, in the default
+// configuration. For other configurations the default stack is at
+// the top of their memory.
+var DefaultStack = Stack{Base: maxAddress - 1, Size: 256}
+
+// defaultStack return the default stack for the configuration, at
+// most an eighth of the memory
+func defaultStack(config vm.Config) Stack {
+	size := uint64(DefaultStack.Size)
+	if max := config.MemorySize() / 8 / uint64(config.Bytes()); size > max {
+		size = max
+	}
+	top := Address(config.MaxAddress() + 1 - config.Bytes())
+	return Stack{Base: top, Size: uint16(size)}
+}
+
+// StackFault is a label to a memory position where the default stack
+// handlers store a marker before halting
+const StackFault = Label("__stack_fault")
+
+// Markers stored in StackFault by the default stack handlers
+const (
+	StackOverflow  = 1
+	StackUnderflow = 2
+)
+
+// limit return the value of the stack pointer when the stack is
+// full, for words of the given size in bytes
+func (self Stack) limit(word Address) Address {
+	return self.Base - word*Address(self.Size)
+}
+
+// Guard return the first and last address of the word just below the
+// stack in the given configuration. The computer may guard it in
+// order to detect writes past the end of the stack (see
+// vm.Computer.Guard).
+func (self Stack) Guard(config vm.Config) (Address, Address) {
+	word := Address(config.Bytes())
+	return self.limit(word), self.limit(word) + word - 1
+}
+
+func (self Stack) overflow() Label {
+	if self.Overflow != "" {
+		return self.Overflow
+	}
+	return Label("__stack_overflow")
+}
+
+func (self Stack) underflow() Label {
+	if self.Underflow != "" {
+		return self.Underflow
+	}
+	return Label("__stack_underflow")
+}
+
+// SetStack configures the location and size of the stack. Must be
+// called before assembling the program, and after configuring the
+// word size.
+func (self *Assembler) SetStack(stack Stack) error {
+	if stack.Size == 0 {
+		return fmt.Errorf("empty stack")
+	}
+	word := uint64(self.word())
+	if uint64(stack.Size)*word > uint64(stack.Base)+word {
+		return fmt.Errorf("stack does not fit at %s", stack.Base)
+	}
+	self.stack = stack
+	return nil
+}
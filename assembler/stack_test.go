@@ -0,0 +1,141 @@
+package assembler
+
+import (
+	"gosics/vm"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// t_runUntilHalted create a new Computer, load the program assembled
+// by a and execute it until halted
+func t_runUntilHalted(a *Assembler) vm.Computer {
+	c := vm.Computer{}
+	c.LoadMemory(a.Assemble())
+	for i := 0; i < 10000 && !c.Halted(); i++ {
+		c.Step()
+	}
+	return c
+}
+
+func TestSetStackValidatesTheStack(t *testing.T) {
+	as := New()
+	assert.NotNil(t, as.SetStack(Stack{Base: 0x1000}))
+	assert.NotNil(t, as.SetStack(Stack{Base: 0x0002, Size: 3}))
+	assert.Nil(t, as.SetStack(Stack{Base: 0x0004, Size: 3}))
+}
+
+func TestStackBase(t *testing.T) {
+	as := New()
+	as.SetStack(Stack{Base: 0x1000, Size: 16})
+	as.PUSH(Label("SRC"))
+	as.HLT()
+	as.Label("SRC")
+	as.DD(0x1234)
+
+	c := t_runUntilHalted(&as)
+	assert.Equal(t, vm.Operand(0x1234), c.Peek(0x1000))
+	assert.Equal(t, vm.Operand(0x0FFE), t_peek(&c, &as, "__SP"))
+}
+
+func TestStackOverflow(t *testing.T) {
+	as := New()
+	as.SetStack(Stack{Base: 0x1000, Size: 2})
+	as.PUSH(Label("SRC"))
+	as.PUSH(Label("SRC"))
+	as.PUSH(Label("SRC"))
+	as.HLT()
+	as.Label("SRC")
+	as.DD(0x1234)
+
+	c := t_runUntilHalted(&as)
+	assert.True(t, c.Halted())
+	assert.Equal(t, vm.Operand(StackOverflow), t_peek(&c, &as, string(StackFault)))
+	assert.Equal(t, vm.Operand(0), c.Peek(0x1000-4))
+}
+
+func TestStackUnderflow(t *testing.T) {
+	as := New()
+	as.PUSH(Label("SRC"))
+	as.POP(Label("SRC"))
+	as.POP(Label("SRC"))
+	as.HLT()
+	as.Label("SRC")
+	as.DD(0x1234)
+
+	c := t_runUntilHalted(&as)
+	assert.True(t, c.Halted())
+	assert.Equal(t, vm.Operand(StackUnderflow), t_peek(&c, &as, string(StackFault)))
+}
+
+func TestStackHandlers(t *testing.T) {
+	as := New()
+	as.SetStack(Stack{Base: 0x1000, Size: 1, Overflow: "overflow", Underflow: "underflow"})
+	as.POP(Label("DST"))
+	as.HLT()
+	as.Label("underflow")
+	as.PUSH(Label("DST"))
+	as.PUSH(Label("DST"))
+	as.HLT()
+	as.Label("overflow")
+	as.INC(Label("DST"))
+	as.HLT()
+	as.Label("DST")
+	as.DD(0x1234)
+
+	c := t_runUntilHalted(&as)
+	assert.Equal(t, vm.Operand(0x1235), t_peek(&c, &as, "DST"))
+	assert.Equal(t, vm.Operand(0), t_peek(&c, &as, string(StackFault)))
+	_, ok := as.labels["__stack_overflow"]
+	assert.False(t, ok)
+}
+
+func TestStackGuard(t *testing.T) {
+	as := New()
+	stack := Stack{Base: 0x1000, Size: 1}
+	as.SetStack(stack)
+	as.PUSH(Label("SRC"))
+	as.HLT()
+	as.Label("SRC")
+	as.DD(0x1234)
+
+	c := vm.Computer{}
+	c.LoadMemory(as.Assemble())
+	from, to := stack.Guard(vm.DefaultConfig)
+	assert.Equal(t, Address(0x0FFE), from)
+	assert.Equal(t, Address(0x0FFF), to)
+	c.Guard(vm.Address(from), vm.Address(to))
+	for !c.Halted() {
+		c.Step()
+	}
+	assert.Nil(t, c.Fault())
+}
+
+func TestStackOverlapsTheProgram(t *testing.T) {
+	as := New(WithStack(Stack{Base: 0x40, Size: 8}))
+	for i := 0; i < 10; i++ {
+		as.PUSH(Label("X"))
+	}
+	as.HLT()
+	as.Label("X")
+	as.DD(0)
+	as.Assemble()
+	if assert.NotNil(t, as.Err()) {
+		assert.Equal(t, "stack overlaps the program", as.Err().Error())
+	}
+
+	// below the origin
+	as = New(WithOrigin(0x20), WithStack(Stack{Base: 0x1E, Size: 15}))
+	as.PUSH(Label("X"))
+	as.HLT()
+	as.Label("X")
+	as.DD(0)
+	as.Assemble()
+	assert.Nil(t, as.Err())
+
+	// unused
+	as = New(WithStack(Stack{Base: 0x40, Size: 8}))
+	as.HLT()
+	as.Assemble()
+	assert.Nil(t, as.Err())
+}
@@ -0,0 +1,98 @@
+package assembler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Strings and characters
+//
+// DS stores text without hand-converted ASCII tables, the escape
+// sequences are the ones of Go string literals. By default each
+// character takes a word, the flags pack the characters in bytes,
+// UTF-8 encoded, and add a zero terminator or a length prefix, a word
+// holding the number of characters, or bytes if packed. Char is a
+// character as an operand, like an Imm with its code.
+
+// StringFlag selects how DS stores the text
+type StringFlag int
+
+const (
+	// Packed one byte per character, UTF-8 encoded
+	Packed StringFlag = 1 << iota
+	// ZeroTerminated followed by a zero, a word or a byte if packed
+	ZeroTerminated
+	// LengthPrefixed preceded by a word with the length
+	LengthPrefixed
+)
+
+// String return the name of the flag
+func (self StringFlag) String() string {
+	switch self {
+	case Packed:
+		return "Packed"
+	case ZeroTerminated:
+		return "ZeroTerminated"
+	case LengthPrefixed:
+		return "LengthPrefixed"
+	}
+	return fmt.Sprintf("StringFlag(%d)", int(self))
+}
+
+// DS insert the text into memory at IP, updates IP. See StringFlag
+// for the layout. Characters not fitting in a word are an error.
+func (self *Assembler) DS(text string, flags ...StringFlag) {
+	var set StringFlag
+	names := []string{strconv.Quote(text)}
+	for _, f := range flags {
+		set |= f
+		names = append(names, f.String())
+	}
+	if set&ZeroTerminated != 0 && set&LengthPrefixed != 0 {
+		self.errs = append(self.errs, fmt.Errorf("DS %s: both zero terminated and length prefixed", names[0]))
+		return
+	}
+	start := self.ip
+	if set&Packed != 0 {
+		if set&LengthPrefixed != 0 {
+			self.emitWord(uint32(len(text)))
+		}
+		self.emit([]uint8(text)...)
+		if set&ZeroTerminated != 0 {
+			self.emit(0)
+		}
+	} else {
+		if set&LengthPrefixed != 0 {
+			self.emitWord(uint32(utf8.RuneCountInString(text)))
+		}
+		for _, r := range text {
+			if uint64(r) > uint64(self.config.MaxAddress()) {
+				self.errs = append(self.errs, fmt.Errorf("DS %s at %04x: character %q doesn't fit in a word",
+					names[0], uint32(start), r))
+			}
+			self.emitWord(uint32(r))
+		}
+		if set&ZeroTerminated != 0 {
+			self.emitWord(0)
+		}
+	}
+	self.record(start, "DS "+strings.Join(names, ", "))
+	self.protect(start, dataRegion)
+}
+
+// Char a character operand, the address of a word holding its code,
+// see Imm
+type Char rune
+
+// getAddress adds the code to the constant pool and return its
+// address
+func (self Char) getAddress(a *Assembler) Address {
+	return Imm(self).getAddress(a)
+}
+
+// String return the character literal
+func (self Char) String() string {
+	return strconv.QuoteRune(rune(self))
+}
@@ -0,0 +1,87 @@
+package assembler
+
+import (
+	"bytes"
+	"gosics/vm"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDS(t *testing.T) {
+	for _, c := range []struct {
+		flags    []StringFlag
+		expected []uint8
+	}{
+		{nil, []uint8{0x00, 'H', 0x00, 'i', 0x00, '\n'}},
+		{[]StringFlag{ZeroTerminated}, []uint8{0x00, 'H', 0x00, 'i', 0x00, '\n', 0x00, 0x00}},
+		{[]StringFlag{LengthPrefixed}, []uint8{0x00, 0x03, 0x00, 'H', 0x00, 'i', 0x00, '\n'}},
+		{[]StringFlag{Packed}, []uint8{'H', 'i', '\n'}},
+		{[]StringFlag{Packed, ZeroTerminated}, []uint8{'H', 'i', '\n', 0x00}},
+		{[]StringFlag{Packed | LengthPrefixed}, []uint8{0x00, 0x03, 'H', 'i', '\n'}},
+	} {
+		as := NewModule()
+		as.DS("Hi\n", c.flags...)
+		assert.Equal(t, c.expected, as.Assemble(), "%v", c.flags)
+		assert.Nil(t, as.Err())
+	}
+}
+
+func TestDSUnicode(t *testing.T) {
+	as := NewModule()
+	as.DS("ñ")
+	as.DS("ñ", Packed, LengthPrefixed)
+	assert.Equal(t, []uint8{0x00, 0xF1, 0x00, 0x02, 0xC3, 0xB1}, as.Assemble())
+
+	as = NewModule(WithConfig(vm.Config{WordSize: 8}))
+	as.DS("1€")
+	as.Assemble()
+	err := as.Err()
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "DS \"1€\" at 0000: character '€' doesn't fit in a word", err.Error())
+	}
+}
+
+func TestDSErrors(t *testing.T) {
+	as := NewModule()
+	as.DS("Hi", ZeroTerminated, LengthPrefixed)
+	err := as.Err()
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "DS \"Hi\": both zero terminated and length prefixed", err.Error())
+	}
+}
+
+func TestDSListing(t *testing.T) {
+	as := New()
+	as.HLT()
+	as.Label("TEXT")
+	as.DS("Hi\n", Packed, ZeroTerminated)
+
+	var buf bytes.Buffer
+	assert.Nil(t, as.Listing(&buf))
+	assert.Contains(t, buf.String(), "TEXT:\n0016  4869 0a00            DS \"Hi\\n\", Packed, ZeroTerminated\n")
+	assert.Equal(t, "data", as.MemoryMap()[len(as.MemoryMap())-1].Name)
+}
+
+func TestChar(t *testing.T) {
+	as := New()
+	as.BEQ(Label("X"), Char('A'), Label("equal"))
+	as.HLT()
+	as.Label("equal")
+	as.MOV(Char('B'), Label("Y"))
+	as.HLT()
+	as.Label("X")
+	as.DS("A")
+	as.Label("Y")
+	as.DD(0)
+
+	c := t_runUntilHalted(&as)
+	assert.Equal(t, vm.Operand('B'), t_peek(&c, &as, "Y"))
+	assert.Contains(t, t_listing(&as), "SBNZ 'B', __ZERO, Y, __label_0002")
+	assert.Nil(t, as.Err())
+
+	as = New()
+	as.MOV(Label("X"), Char('A'))
+	as.Assemble()
+	assert.NotNil(t, as.Err())
+}
@@ -0,0 +1,87 @@
+package cfg
+
+import (
+	"bytes"
+	"gosics/assembler"
+	"gosics/internal/example"
+	"gosics/vm"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuild(t *testing.T) {
+	as := example.Multiply()
+	g, err := Build(as.Assemble(), as.Symbols(), vm.DefaultConfig)
+	assert.Nil(t, err)
+
+	var starts []vm.Address
+	for _, b := range g.Blocks {
+		starts = append(starts, b.Start)
+	}
+	// preamble, __start, loop, the BEQ's jump, the loop body, exit_loop
+	assert.Equal(t, []vm.Address{0x00, 0x0e, 0x1e, 0x26, 0x2e, 0x4e}, starts)
+	assert.Equal(t, []vm.Address{0x0e, 0x16}, g.Blocks[1].Instructions)
+	assert.Equal(t, []Edge{{Zero, 0x26}, {NotZero, 0x2e}}, g.Blocks[2].Succs)
+	assert.Equal(t, []Edge{{Jump, 0x1e}}, g.Blocks[4].Succs)
+	assert.Equal(t, []Edge{{Jump, vm.HALT}}, g.Blocks[5].Succs)
+}
+
+func TestBuildWithoutSymbols(t *testing.T) {
+	as := example.Multiply()
+	g, err := Build(as.Assemble(), nil, vm.DefaultConfig)
+	assert.Nil(t, err)
+	// without __ONE and __ZERO the jumps may fall through, into the
+	// constants at 0x0008 and the data after the HLT at 0x004e
+	assert.Equal(t, []Edge{{Zero, 0x08}, {NotZero, 0x0e}}, g.Blocks[0].Succs)
+	for _, b := range g.Blocks {
+		if b.Start == 0x4e {
+			assert.Equal(t, []Edge{{Zero, 0x56}, {NotZero, vm.HALT}}, b.Succs)
+		}
+	}
+}
+
+func TestWriteDOTExits(t *testing.T) {
+	as := assembler.New()
+	as.JMP(assembler.Address(0x1000))
+	g, err := Build(as.Assemble(), as.Symbols(), vm.DefaultConfig)
+	assert.Nil(t, err)
+	var buf bytes.Buffer
+	assert.Nil(t, g.WriteDOT(&buf))
+	assert.Contains(t, buf.String(), "\tb000e -> x1000;\n")
+	assert.Contains(t, buf.String(), "\tx1000 [label=\"1000\", shape=oval, style=dashed];\n")
+}
+
+func TestWriteDOT(t *testing.T) {
+	as := example.Multiply()
+	g, _ := Build(as.Assemble(), as.Symbols(), vm.DefaultConfig)
+	var buf bytes.Buffer
+	assert.Nil(t, g.WriteDOT(&buf))
+	dot := buf.String()
+	assert.True(t, strings.HasPrefix(dot, "digraph cfg {\n"))
+	for _, line := range []string{
+		`	b001e [label="loop:\l001e  SBNZ CNT, __ZERO, __JUNK, __label_0003\l"];`,
+		`	b001e -> b0026 [label="= 0"];`,
+		`	b001e -> b002e [label="!= 0"];`,
+		`	b002e -> b001e;`,
+		`	b004e -> halt;`,
+		`	halt [label="HLT", shape=oval];`,
+	} {
+		assert.Contains(t, dot, line+"\n")
+	}
+}
+
+func TestWriteDOTLabelPrefix(t *testing.T) {
+	as := example.Multiply(assembler.WithLabelPrefix("L_"))
+	g, _ := Build(as.Assemble(), as.Symbols(), vm.DefaultConfig)
+	var buf bytes.Buffer
+	assert.Nil(t, g.WriteDOT(&buf))
+	assert.Contains(t, buf.String(), "L_0003:")
+
+	g.LabelPrefix = "L_"
+	buf.Reset()
+	assert.Nil(t, g.WriteDOT(&buf))
+	assert.NotContains(t, buf.String(), "L_0003:")
+	assert.Contains(t, buf.String(), `	b0026 [label="0026  SBNZ`)
+}
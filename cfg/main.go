@@ -0,0 +1,253 @@
+// This package builds the control flow graph of SBNZ programs and
+// exports it to Graphviz, to see the structure of programs that are
+// nothing but conditional branches.
+//
+// The code is found following the control flow from address 0. Each
+// SBNZ a, b, c, d instruction falls through if a - b is zero and
+// jumps to d otherwise, but:
+//
+// - instructions with a == b never branch.
+//
+// - instructions with d pointing to the next instruction, like the
+// assembler's MOV, always continue there.
+//
+// - instructions subtracting __ZERO from __ONE, according to the
+// symbol table, like the assembler's JMP and HLT, always branch.
+//
+// Basic blocks start at address 0, at the targets of the jumps and
+// after the branches. Control flow leaving the program, without the
+// symbol table the jumps fall through into the data, ends in exit
+// nodes.
+package cfg
+
+import (
+	"fmt"
+	"gosics/assembler"
+	"gosics/vm"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Edge kinds
+const (
+	// Next the instruction continues in the next one
+	Next = iota
+	// Zero the instruction continues in the next one if a - b is zero
+	Zero
+	// NotZero the instruction jumps to d if a - b is not zero
+	NotZero
+	// Jump the instruction always jumps to d
+	Jump
+)
+
+// Edge a transition between blocks
+type Edge struct {
+	Kind int
+	// To start of the target block, the highest address when the
+	// program halts or an address outside the program
+	To vm.Address
+}
+
+// Block a basic block
+type Block struct {
+	Start vm.Address
+	// Instructions addresses of the instructions, in order
+	Instructions []vm.Address
+	Succs        []Edge
+}
+
+// Graph the control flow graph of a program
+type Graph struct {
+	Blocks []*Block // sorted by start
+	// LabelPrefix the prefix of the labels generated by the
+	// assembler, hidden by WriteDOT, assembler.DefaultLabelPrefix by
+	// default, see assembler.WithLabelPrefix
+	LabelPrefix string
+	image       []uint8
+	symbols     vm.SymbolTable
+	config      vm.Config
+}
+
+// instruction a decoded SBNZ instruction
+type instruction struct {
+	a, b, c, d vm.Address
+	next       vm.Address
+}
+
+// decode decodes the instruction at p
+func (self *Graph) decode(p vm.Address) instruction {
+	word, n := self.config.WordAt, self.config.Bytes()
+	return instruction{
+		a: word(self.image, p), b: word(self.image, p+n), c: word(self.image, p+2*n), d: word(self.image, p+3*n),
+		next: (p + vm.SBNZ.Width()*n) & self.config.MaxAddress(),
+	}
+}
+
+// succs return the possible successors of the instruction at p
+func (self *Graph) succs(p vm.Address) []Edge {
+	in := self.decode(p)
+	one, ok1 := self.symbols.Lookup("__ONE")
+	zero, ok2 := self.symbols.Lookup("__ZERO")
+	switch {
+	case in.a == in.b || in.d == in.next:
+		return []Edge{{Next, in.next}}
+	case ok1 && ok2 && in.a == one && in.b == zero:
+		return []Edge{{Jump, in.d}}
+	}
+	return []Edge{{Zero, in.next}, {NotZero, in.d}}
+}
+
+// Build builds the control flow graph of the program, symbols may be
+// nil
+func Build(image []uint8, symbols vm.SymbolTable, config vm.Config) (*Graph, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	g := &Graph{LabelPrefix: assembler.DefaultLabelPrefix, image: image, symbols: symbols, config: config}
+	halt := config.MaxAddress()
+
+	// instructions and leaders
+	code := make(map[vm.Address]bool)
+	leaders := map[vm.Address]bool{0: true}
+	pending := []vm.Address{0}
+	for len(pending) > 0 {
+		p := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if code[p] || p == halt || g.outside(p) {
+			continue
+		}
+		code[p] = true
+		for _, e := range g.succs(p) {
+			if e.Kind != Next {
+				leaders[e.To] = true
+			}
+			pending = append(pending, e.To)
+		}
+	}
+
+	// blocks, from each leader to the next one or a transfer
+	for p := range leaders {
+		if !code[p] {
+			continue
+		}
+		b := &Block{Start: p}
+		for {
+			b.Instructions = append(b.Instructions, p)
+			succs := g.succs(p)
+			if len(succs) > 1 || succs[0].Kind != Next || !code[succs[0].To] || leaders[succs[0].To] ||
+				len(b.Instructions) == len(code) {
+				b.Succs = succs
+				break
+			}
+			p = succs[0].To
+		}
+		g.Blocks = append(g.Blocks, b)
+	}
+	sort.Slice(g.Blocks, func(i, j int) bool { return g.Blocks[i].Start < g.Blocks[j].Start })
+	return g, nil
+}
+
+// outside return true if the instruction at p doesn't fit in the
+// program
+func (self *Graph) outside(p vm.Address) bool {
+	return int(p)+int(vm.SBNZ.Width()*self.config.Bytes()) > len(self.image)
+}
+
+// names return the names of the address, without the generated labels
+func (self *Graph) names(p vm.Address) []string {
+	var res []string
+	for _, name := range self.symbols.Names(p) {
+		if self.LabelPrefix == "" || !strings.HasPrefix(name, self.LabelPrefix) {
+			res = append(res, name)
+		}
+	}
+	return res
+}
+
+// name return the symbolic name of the address, preferably not a
+// generated label, or the address
+func (self *Graph) name(p vm.Address) string {
+	if p == self.config.MaxAddress() {
+		return "HLT"
+	}
+	if names := self.names(p); len(names) > 0 {
+		return names[0]
+	}
+	if names := self.symbols.Names(p); len(names) > 0 {
+		return names[0]
+	}
+	return fmt.Sprintf("%04x", p)
+}
+
+// WriteDOT writes the graph in the Graphviz DOT language. Each node
+// shows the labels of the block, but the ones generated by the
+// assembler, and its instructions, the edges the condition.
+func (self *Graph) WriteDOT(w io.Writer) error {
+	var lines []string
+	add := func(format string, args ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+	add("digraph cfg {")
+	add("\tnode [shape=box, fontname=monospace];")
+	halts := false
+	exits := make(map[vm.Address]bool)
+	for _, b := range self.Blocks {
+		var text []string
+		for _, name := range self.names(b.Start) {
+			text = append(text, name+":")
+		}
+		for _, p := range b.Instructions {
+			in := self.decode(p)
+			text = append(text, fmt.Sprintf("%04x  SBNZ %s, %s, %s, %s", p,
+				self.name(in.a), self.name(in.b), self.name(in.c), self.name(in.d)))
+		}
+		add("\tb%04x [label=%s];", b.Start, quote(text))
+		for _, e := range b.Succs {
+			to := fmt.Sprintf("b%04x", e.To)
+			switch {
+			case e.To == self.config.MaxAddress():
+				to = "halt"
+				halts = true
+			case self.outside(e.To):
+				to = fmt.Sprintf("x%04x", e.To)
+				exits[e.To] = true
+			}
+			switch e.Kind {
+			case Zero:
+				add("\tb%04x -> %s [label=\"= 0\"];", b.Start, to)
+			case NotZero:
+				add("\tb%04x -> %s [label=\"!= 0\"];", b.Start, to)
+			default:
+				add("\tb%04x -> %s;", b.Start, to)
+			}
+		}
+	}
+	if halts {
+		add("\thalt [label=\"HLT\", shape=oval];")
+	}
+	var outside []vm.Address
+	for p := range exits {
+		outside = append(outside, p)
+	}
+	sort.Slice(outside, func(i, j int) bool { return outside[i] < outside[j] })
+	for _, p := range outside {
+		add("\tx%04x [label=\"%04x\", shape=oval, style=dashed];", p, p)
+	}
+	add("}")
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+// quote return the lines as a DOT string, left justified
+func quote(lines []string) string {
+	var sb strings.Builder
+	sb.WriteString("\"")
+	for _, l := range lines {
+		l = strings.Replace(l, "\\", "\\\\", -1)
+		l = strings.Replace(l, "\"", "\\\"", -1)
+		sb.WriteString(l + "\\l")
+	}
+	sb.WriteString("\"")
+	return sb.String()
+}
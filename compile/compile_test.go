@@ -0,0 +1,143 @@
+package compile
+
+import (
+	"bytes"
+	"flag"
+	"gosics/assembler"
+	"gosics/compile/internal/mult"
+	"gosics/internal/example"
+	"gosics/vm"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// the program compiled in internal/mult
+const t_golden = "internal/mult/mult.go"
+
+var update = flag.Bool("update", false, "regenerate "+t_golden)
+
+// t_multiply multiplies OP1 and OP2 into DST, then copies DST to RES
+// through the stack, using the self-modifying PUSH and POP
+func t_multiply() assembler.Assembler {
+	as := assembler.New()
+	example.MultiplyLoop(&as)
+	as.PUSH(example.DST)
+	as.POP(assembler.Label("RES"))
+	as.HLT()
+	example.MultiplyData(&as)
+	as.Label("RES")
+	as.DD(0)
+	return as
+}
+
+// t_memory the memory with the program loaded and the operands
+func t_memory(as *assembler.Assembler, op1, op2 vm.Operand) []uint8 {
+	mem := make([]uint8, mult.MemorySize)
+	copy(mem, as.Assemble())
+	symbols := as.Symbols()
+	for _, op := range []struct {
+		name  string
+		value vm.Operand
+	}{{"OP1", op1}, {"OP2", op2}} {
+		p, _ := symbols.Lookup(op.name)
+		mem[p], mem[p+1] = uint8(op.value>>8), uint8(op.value)
+	}
+	return mem
+}
+
+func TestGolden(t *testing.T) {
+	as := t_multiply()
+	var buf bytes.Buffer
+	assert.Nil(t, Go(&buf, as.Assemble(), as.Symbols(), vm.DefaultConfig, "mult"))
+	if *update {
+		assert.Nil(t, ioutil.WriteFile(t_golden, buf.Bytes(), 0644))
+	}
+	golden, err := ioutil.ReadFile(t_golden)
+	assert.Nil(t, err)
+	assert.Equal(t, string(golden), buf.String(), "run go test ./compile -update")
+}
+
+// TestCompiledTraces checks the compiled program and the interpreter
+// execute the same instructions and leave the same memory
+func TestCompiledTraces(t *testing.T) {
+	as := t_multiply()
+	mem := t_memory(&as, 7, -3)
+	c := vm.Computer{}
+	c.LoadMemory(mem)
+
+	ip := vm.Address(0)
+	steps := 0
+	for !c.Halted() && steps < 10000 {
+		c.Step()
+		n := 0
+		n, ip = mult.Run(mem, ip, 1)
+		assert.Equal(t, 1, n)
+		if !assert.Equal(t, c.IP(), ip, "step %d", steps) {
+			break
+		}
+		steps++
+	}
+	assert.True(t, c.Halted())
+	ref := make([]uint8, mult.MemorySize)
+	c.ReadMemory(0, ref)
+	assert.Equal(t, ref, mem)
+	res, _ := as.Symbols().Lookup("RES")
+	assert.Equal(t, vm.Operand(-21), c.Peek(res))
+}
+
+func TestCompiledRun(t *testing.T) {
+	as := t_multiply()
+	mem := t_memory(&as, 100, 3)
+	c := vm.Computer{}
+	c.LoadMemory(mem)
+	steps := c.Run(1000000)
+
+	n, ip := mult.Run(mem, 0, 1000000)
+	assert.Equal(t, steps, n)
+	assert.Equal(t, c.IP(), ip)
+	ref := make([]uint8, mult.MemorySize)
+	c.ReadMemory(0, ref)
+	assert.Equal(t, ref, mem)
+
+	// step limit
+	mem = t_memory(&as, 100, 3)
+	n, ip = mult.Run(mem, 0, 50)
+	assert.Equal(t, 50, n)
+	c = vm.Computer{}
+	c.LoadMemory(t_memory(&as, 100, 3))
+	c.Run(50)
+	assert.Equal(t, c.IP(), ip)
+}
+
+func TestUnsupportedWordSize(t *testing.T) {
+	var buf bytes.Buffer
+	err := Go(&buf, nil, nil, vm.Config{WordSize: 32}, "p")
+	assert.NotNil(t, err)
+	assert.Equal(t, "words of 32 bits not supported", err.Error())
+}
+
+func BenchmarkInterpreted(b *testing.B) {
+	as := t_multiply()
+	mem := t_memory(&as, 30000, 3)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c := vm.Computer{}
+		c.LoadMemory(mem)
+		c.Run(1 << 30)
+	}
+}
+
+// BenchmarkCompiled includes the switch to the interpreter at the
+// end, PUSH and POP write into the code
+func BenchmarkCompiled(b *testing.B) {
+	as := t_multiply()
+	mem := t_memory(&as, 30000, 3)
+	run := make([]uint8, len(mem))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		copy(run, mem)
+		mult.Run(run, 0, 1<<30)
+	}
+}
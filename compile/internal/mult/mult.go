@@ -0,0 +1,237 @@
+// Code generated by gosics/compile. DO NOT EDIT.
+
+package mult
+
+import "gosics/vm"
+
+// Config the configuration of the computer
+var Config = vm.Config{WordSize: 16, Order: vm.BigEndian}
+
+// MemorySize the size of the memory, in bytes
+const MemorySize = 0x10000
+
+const shift = 16
+
+// signed return the word as a signed operand
+func signed(w uint32) vm.Operand {
+	return vm.Operand(int32(w<<shift) >> shift)
+}
+
+// Run executes up to max steps, starting at ip, on the memory, which
+// must hold MemorySize bytes with the program loaded. Returns the
+// steps executed and the IP.
+func Run(mem []uint8, ip vm.Address, max int) (int, vm.Address) {
+	if !pristine(mem) {
+		return interpret(mem, ip, 0, max)
+	}
+	var r vm.Operand
+	steps := 0
+	for ; steps < max; steps++ {
+		switch ip {
+		case 0x0000:
+			// SBNZ 0008, 000a, 000c, 000e
+			r = signed((uint32(mem[0x0008])<<8 | uint32(mem[0x0009])<<0) - (uint32(mem[0x000a])<<8 | uint32(mem[0x000b])<<0))
+			mem[0x000c] = uint8(r >> 8)
+			mem[0x000d] = uint8(r >> 0)
+			if r != 0 {
+				ip = 0x000e
+			} else {
+				ip = 0x0008
+			}
+		case 0x000e:
+			// SBNZ 009a, 000a, 00a0, 0016
+			r = signed((uint32(mem[0x009a])<<8 | uint32(mem[0x009b])<<0) - (uint32(mem[0x000a])<<8 | uint32(mem[0x000b])<<0))
+			mem[0x00a0] = uint8(r >> 8)
+			mem[0x00a1] = uint8(r >> 0)
+			ip = 0x0016
+		case 0x0016:
+			// SBNZ 000a, 000a, 009e, 001e
+			r = signed((uint32(mem[0x000a])<<8 | uint32(mem[0x000b])<<0) - (uint32(mem[0x000a])<<8 | uint32(mem[0x000b])<<0))
+			mem[0x009e] = uint8(r >> 8)
+			mem[0x009f] = uint8(r >> 0)
+			ip = 0x001e
+		case 0x001e:
+			// SBNZ 00a0, 000a, 000c, 002e
+			r = signed((uint32(mem[0x00a0])<<8 | uint32(mem[0x00a1])<<0) - (uint32(mem[0x000a])<<8 | uint32(mem[0x000b])<<0))
+			mem[0x000c] = uint8(r >> 8)
+			mem[0x000d] = uint8(r >> 0)
+			if r != 0 {
+				ip = 0x002e
+			} else {
+				ip = 0x0026
+			}
+		case 0x0026:
+			// SBNZ 0008, 000a, 000c, 004e
+			r = signed((uint32(mem[0x0008])<<8 | uint32(mem[0x0009])<<0) - (uint32(mem[0x000a])<<8 | uint32(mem[0x000b])<<0))
+			mem[0x000c] = uint8(r >> 8)
+			mem[0x000d] = uint8(r >> 0)
+			if r != 0 {
+				ip = 0x004e
+			} else {
+				ip = 0x002e
+			}
+		case 0x002e:
+			// SBNZ 000a, 009e, 000c, 0036
+			r = signed((uint32(mem[0x000a])<<8 | uint32(mem[0x000b])<<0) - (uint32(mem[0x009e])<<8 | uint32(mem[0x009f])<<0))
+			mem[0x000c] = uint8(r >> 8)
+			mem[0x000d] = uint8(r >> 0)
+			ip = 0x0036
+		case 0x0036:
+			// SBNZ 009c, 000c, 009e, 003e
+			r = signed((uint32(mem[0x009c])<<8 | uint32(mem[0x009d])<<0) - (uint32(mem[0x000c])<<8 | uint32(mem[0x000d])<<0))
+			mem[0x009e] = uint8(r >> 8)
+			mem[0x009f] = uint8(r >> 0)
+			ip = 0x003e
+		case 0x003e:
+			// SBNZ 00a0, 0008, 00a0, 0046
+			r = signed((uint32(mem[0x00a0])<<8 | uint32(mem[0x00a1])<<0) - (uint32(mem[0x0008])<<8 | uint32(mem[0x0009])<<0))
+			mem[0x00a0] = uint8(r >> 8)
+			mem[0x00a1] = uint8(r >> 0)
+			ip = 0x0046
+		case 0x0046:
+			// SBNZ 0008, 000a, 000c, 001e
+			r = signed((uint32(mem[0x0008])<<8 | uint32(mem[0x0009])<<0) - (uint32(mem[0x000a])<<8 | uint32(mem[0x000b])<<0))
+			mem[0x000c] = uint8(r >> 8)
+			mem[0x000d] = uint8(r >> 0)
+			if r != 0 {
+				ip = 0x001e
+			} else {
+				ip = 0x004e
+			}
+		case 0x004e:
+			// SBNZ 009e, 000a, 011c, 0056
+			r = signed((uint32(mem[0x009e])<<8 | uint32(mem[0x009f])<<0) - (uint32(mem[0x000a])<<8 | uint32(mem[0x000b])<<0))
+			mem[0x011c] = uint8(r >> 8)
+			mem[0x011d] = uint8(r >> 0)
+			ip = 0x0056
+		case 0x0056:
+			// SBNZ 006e, 000a, 00da, 005e
+			r = signed((uint32(mem[0x006e])<<8 | uint32(mem[0x006f])<<0) - (uint32(mem[0x000a])<<8 | uint32(mem[0x000b])<<0))
+			mem[0x00da] = uint8(r >> 8)
+			mem[0x00db] = uint8(r >> 0)
+			ip = 0x005e
+			// writes into the code
+			return interpret(mem, ip, steps+1, max)
+		case 0x005e:
+			// SBNZ 0008, 000a, 000c, 00a4
+			r = signed((uint32(mem[0x0008])<<8 | uint32(mem[0x0009])<<0) - (uint32(mem[0x000a])<<8 | uint32(mem[0x000b])<<0))
+			mem[0x000c] = uint8(r >> 8)
+			mem[0x000d] = uint8(r >> 0)
+			if r != 0 {
+				ip = 0x00a4
+			} else {
+				ip = 0x0066
+			}
+		case 0x00a4:
+			// SBNZ 011e, 0122, 000c, 00b4
+			r = signed((uint32(mem[0x011e])<<8 | uint32(mem[0x011f])<<0) - (uint32(mem[0x0122])<<8 | uint32(mem[0x0123])<<0))
+			mem[0x000c] = uint8(r >> 8)
+			mem[0x000d] = uint8(r >> 0)
+			if r != 0 {
+				ip = 0x00b4
+			} else {
+				ip = 0x00ac
+			}
+		case 0x00ac:
+			// SBNZ 0008, 000a, 000c, 0126
+			r = signed((uint32(mem[0x0008])<<8 | uint32(mem[0x0009])<<0) - (uint32(mem[0x000a])<<8 | uint32(mem[0x000b])<<0))
+			mem[0x000c] = uint8(r >> 8)
+			mem[0x000d] = uint8(r >> 0)
+			if r != 0 {
+				ip = 0x0126
+			} else {
+				ip = 0x00b4
+			}
+		case 0x00b4:
+			// SBNZ 011e, 000a, 00c0, 00bc
+			r = signed((uint32(mem[0x011e])<<8 | uint32(mem[0x011f])<<0) - (uint32(mem[0x000a])<<8 | uint32(mem[0x000b])<<0))
+			mem[0x00c0] = uint8(r >> 8)
+			mem[0x00c1] = uint8(r >> 0)
+			ip = 0x00bc
+			// writes into the code
+			return interpret(mem, ip, steps+1, max)
+		case 0x00bc:
+			// SBNZ 011c, 000a, fffe, 00c4
+			r = signed((uint32(mem[0x011c])<<8 | uint32(mem[0x011d])<<0) - (uint32(mem[0x000a])<<8 | uint32(mem[0x000b])<<0))
+			mem[0xfffe] = uint8(r >> 8)
+			mem[0xffff] = uint8(r >> 0)
+			ip = 0x00c4
+		case 0x00c4:
+			// SBNZ 011e, 0008, 011e, 00cc
+			r = signed((uint32(mem[0x011e])<<8 | uint32(mem[0x011f])<<0) - (uint32(mem[0x0008])<<8 | uint32(mem[0x0009])<<0))
+			mem[0x011e] = uint8(r >> 8)
+			mem[0x011f] = uint8(r >> 0)
+			ip = 0x00cc
+		case 0x00cc:
+			// SBNZ 011e, 0008, 011e, 00d4
+			r = signed((uint32(mem[0x011e])<<8 | uint32(mem[0x011f])<<0) - (uint32(mem[0x0008])<<8 | uint32(mem[0x0009])<<0))
+			mem[0x011e] = uint8(r >> 8)
+			mem[0x011f] = uint8(r >> 0)
+			ip = 0x00d4
+		case 0x00d4:
+			// SBNZ 0008, 000a, 000c, ffff
+			r = signed((uint32(mem[0x0008])<<8 | uint32(mem[0x0009])<<0) - (uint32(mem[0x000a])<<8 | uint32(mem[0x000b])<<0))
+			mem[0x000c] = uint8(r >> 8)
+			mem[0x000d] = uint8(r >> 0)
+			if r != 0 {
+				ip = 0xffff
+			} else {
+				ip = 0x00dc
+			}
+		case 0x0126:
+			// SBNZ 0136, 000a, 0124, 012e
+			r = signed((uint32(mem[0x0136])<<8 | uint32(mem[0x0137])<<0) - (uint32(mem[0x000a])<<8 | uint32(mem[0x000b])<<0))
+			mem[0x0124] = uint8(r >> 8)
+			mem[0x0125] = uint8(r >> 0)
+			ip = 0x012e
+		case 0x012e:
+			// SBNZ 0008, 000a, 000c, ffff
+			r = signed((uint32(mem[0x0008])<<8 | uint32(mem[0x0009])<<0) - (uint32(mem[0x000a])<<8 | uint32(mem[0x000b])<<0))
+			mem[0x000c] = uint8(r >> 8)
+			mem[0x000d] = uint8(r >> 0)
+			if r != 0 {
+				ip = 0xffff
+			} else {
+				ip = 0x0136
+			}
+		case 0xffff:
+			return steps, ip
+		default:
+			return interpret(mem, ip, steps, max)
+		}
+	}
+	return steps, ip
+}
+
+// code the compiled instructions
+var code = []struct {
+	at    int
+	bytes string
+}{
+	{0x0000, "\x00\b\x00\n\x00\f\x00\x0e"},
+	{0x000e, "\x00\x9a\x00\n\x00\xa0\x00\x16\x00\n\x00\n\x00\x9e\x00\x1e\x00\xa0\x00\n\x00\f\x00.\x00\b\x00\n\x00\f\x00N\x00\n\x00\x9e\x00\f\x006\x00\x9c\x00\f\x00\x9e\x00>\x00\xa0\x00\b\x00\xa0\x00F\x00\b\x00\n\x00\f\x00\x1e\x00\x9e\x00\n\x01\x1c\x00V\x00n\x00\n\x00\xda\x00^\x00\b\x00\n\x00\f\x00\xa4"},
+	{0x00a4, "\x01\x1e\x01\"\x00\f\x00\xb4\x00\b\x00\n\x00\f\x01&\x01\x1e\x00\n\x00\xc0\x00\xbc\x01\x1c\x00\n\xff\xfe\x00\xc4\x01\x1e\x00\b\x01\x1e\x00\xcc\x01\x1e\x00\b\x01\x1e\x00\xd4\x00\b\x00\n\x00\f\xff\xff"},
+	{0x0126, "\x016\x00\n\x01$\x01.\x00\b\x00\n\x00\f\xff\xff"},
+}
+
+// pristine return true if the compiled instructions haven't been
+// modified
+func pristine(mem []uint8) bool {
+	for _, c := range code {
+		if string(mem[c.at:c.at+len(c.bytes)]) != c.bytes {
+			return false
+		}
+	}
+	return true
+}
+
+// interpret continues the run in the interpreter
+func interpret(mem []uint8, ip vm.Address, steps, max int) (int, vm.Address) {
+	c := vm.Computer{}
+	c.SetConfig(Config)
+	c.LoadMemory(mem)
+	c.SetIP(ip)
+	steps += c.Run(max - steps)
+	c.ReadMemory(0, mem)
+	return steps, c.IP()
+}
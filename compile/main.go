@@ -0,0 +1,232 @@
+// This package compiles SBNZ programs ahead of time into Go source
+// code, so that fixed programs, like benchmarks, run at native speed
+// while vm.Computer remains the reference.
+//
+// The generated package has a function
+//
+//	func Run(mem []uint8, ip vm.Address, max int) (int, vm.Address)
+//
+// with the semantics of vm.Computer.Run: executes up to max steps,
+// starting at ip, on the memory mem, which must hold the whole
+// address space with the program loaded, and returns the steps
+// executed and the IP. Each instruction is a case of a switch over the
+// IP with its operands resolved at compile time.
+//
+// The code is found following the control flow from address 0, the
+// instructions subtracting __ZERO from __ONE, according to the symbol
+// table, always branch. Jumps outside the compiled code and
+// instructions writing into it continue in the interpreter until the
+// end of the run, the compiled code may be stale after the write.
+// Runs starting with the compiled code modified are interpreted.
+//
+// Words of 32 bits are not supported, the memory would take 4GB.
+package compile
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"gosics/vm"
+	"io"
+	"sort"
+	"strings"
+)
+
+// instruction a compiled SBNZ instruction
+type instruction struct {
+	at, a, b, c, d, next vm.Address
+}
+
+// program a program being compiled
+type program struct {
+	image   []uint8
+	symbols vm.SymbolTable
+	config  vm.Config
+	code    map[vm.Address]*instruction
+}
+
+// analyze finds the instructions following the control flow from
+// address 0. Instructions not fully inside the image are left to the
+// interpreter.
+func (self *program) analyze() {
+	max := self.config.MaxAddress()
+	word, n := self.config.WordAt, self.config.Bytes()
+	width := vm.SBNZ.Width() * n
+	pending := []vm.Address{0}
+	for len(pending) > 0 {
+		at := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if _, ok := self.code[at]; ok || at == max || int(at)+int(width) > len(self.image) {
+			continue
+		}
+		in := &instruction{
+			at: at,
+			a:  word(self.image, at), b: word(self.image, at+n), c: word(self.image, at+2*n), d: word(self.image, at+3*n),
+			next: (at + width) & max,
+		}
+		self.code[at] = in
+		if !self.alwaysBranches(in) {
+			pending = append(pending, in.next)
+		}
+		if in.a != in.b {
+			pending = append(pending, in.d)
+		}
+	}
+}
+
+// alwaysBranches return true if the instruction subtracts __ZERO from
+// __ONE, according to the symbol table
+func (self *program) alwaysBranches(in *instruction) bool {
+	one, ok1 := self.symbols.Lookup("__ONE")
+	zero, ok2 := self.symbols.Lookup("__ZERO")
+	return ok1 && ok2 && in.a == one && in.b == zero
+}
+
+// spans return the ranges of memory [from, to) holding the compiled
+// instructions
+func (self *program) spans(order []*instruction) [][2]vm.Address {
+	width := vm.SBNZ.Width() * self.config.Bytes()
+	var res [][2]vm.Address
+	for _, in := range order {
+		if n := len(res); n > 0 && in.at <= res[n-1][1] {
+			if in.at+width > res[n-1][1] {
+				res[n-1][1] = in.at + width
+			}
+			continue
+		}
+		res = append(res, [2]vm.Address{in.at, in.at + width})
+	}
+	return res
+}
+
+// bytes return the addresses of the bytes of the word at p, most
+// significant first
+func (self *program) bytes(p vm.Address) []vm.Address {
+	n := self.config.Bytes()
+	res := make([]vm.Address, n)
+	for i := vm.Address(0); i < n; i++ {
+		q := p + i
+		if self.config.Order == vm.LittleEndian {
+			q = p + n - 1 - i
+		}
+		res[i] = q & self.config.MaxAddress()
+	}
+	return res
+}
+
+// writesCode return true if the instruction writes into the compiled
+// code
+func (self *program) writesCode(in *instruction) bool {
+	width := vm.SBNZ.Width() * self.config.Bytes()
+	for _, p := range self.bytes(in.c) {
+		for _, other := range self.code {
+			if p >= other.at && p < other.at+width {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// load return the expression reading the word at p
+func (self *program) load(p vm.Address) string {
+	var terms []string
+	bs := self.bytes(p)
+	for i, q := range bs {
+		terms = append(terms, fmt.Sprintf("uint32(mem[0x%04x])<<%d", q, 8*(len(bs)-1-i)))
+	}
+	return strings.Join(terms, " | ")
+}
+
+// Go writes the Go source code of the package pkg running the program
+func Go(w io.Writer, image []uint8, symbols vm.SymbolTable, config vm.Config, pkg string) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	if config.Bytes() > 3 {
+		return fmt.Errorf("words of %d bits not supported", 8*config.Bytes())
+	}
+	p := &program{image: image, symbols: symbols, config: config, code: make(map[vm.Address]*instruction)}
+	p.analyze()
+	var order []*instruction
+	for _, in := range p.code {
+		order = append(order, in)
+	}
+	sort.Slice(order, func(i, j int) bool { return order[i].at < order[j].at })
+
+	var buf bytes.Buffer
+	printf := func(format string, args ...interface{}) {
+		fmt.Fprintf(&buf, format, args...)
+	}
+	endian := "BigEndian"
+	if config.Order == vm.LittleEndian {
+		endian = "LittleEndian"
+	}
+	printf("// Code generated by gosics/compile. DO NOT EDIT.\n\n")
+	printf("package %s\n\nimport \"gosics/vm\"\n\n", pkg)
+	printf("// Config the configuration of the computer\n")
+	printf("var Config = vm.Config{WordSize: %d, Order: vm.%s}\n\n", 8*config.Bytes(), endian)
+	printf("// MemorySize the size of the memory, in bytes\n")
+	printf("const MemorySize = 0x%x\n\n", config.MemorySize())
+	printf("const shift = %d\n\n", 32-8*config.Bytes())
+	printf("// signed return the word as a signed operand\n")
+	printf("func signed(w uint32) vm.Operand {\n\treturn vm.Operand(int32(w<<shift) >> shift)\n}\n\n")
+	printf("// Run executes up to max steps, starting at ip, on the memory, which\n")
+	printf("// must hold MemorySize bytes with the program loaded. Returns the\n")
+	printf("// steps executed and the IP.\n")
+	printf("func Run(mem []uint8, ip vm.Address, max int) (int, vm.Address) {\n")
+	printf("\tif !pristine(mem) {\n\t\treturn interpret(mem, ip, 0, max)\n\t}\n")
+	printf("\tvar r vm.Operand\n")
+	printf("\tsteps := 0\n")
+	printf("\tfor ; steps < max; steps++ {\n")
+	printf("\t\tswitch ip {\n")
+	for _, in := range order {
+		printf("\t\tcase 0x%04x:\n", in.at)
+		printf("\t\t\t// SBNZ %04x, %04x, %04x, %04x\n", in.a, in.b, in.c, in.d)
+		printf("\t\t\tr = signed((%s) - (%s))\n", p.load(in.a), p.load(in.b))
+		bs := p.bytes(in.c)
+		for i, q := range bs {
+			printf("\t\t\tmem[0x%04x] = uint8(r >> %d)\n", q, 8*(len(bs)-1-i))
+		}
+		if in.a == in.b || in.d == in.next {
+			printf("\t\t\tip = 0x%04x\n", in.next)
+		} else {
+			printf("\t\t\tif r != 0 {\n\t\t\t\tip = 0x%04x\n\t\t\t} else {\n\t\t\t\tip = 0x%04x\n\t\t\t}\n", in.d, in.next)
+		}
+		if p.writesCode(in) {
+			printf("\t\t\t// writes into the code\n")
+			printf("\t\t\treturn interpret(mem, ip, steps+1, max)\n")
+		}
+	}
+	printf("\t\tcase 0x%04x:\n\t\t\treturn steps, ip\n", config.MaxAddress())
+	printf("\t\tdefault:\n\t\t\treturn interpret(mem, ip, steps, max)\n")
+	printf("\t\t}\n\t}\n\treturn steps, ip\n}\n\n")
+	printf("// code the compiled instructions\n")
+	printf("var code = []struct {\n\tat    int\n\tbytes string\n}{\n")
+	for _, s := range p.spans(order) {
+		printf("\t{0x%04x, %q},\n", s[0], image[s[0]:s[1]])
+	}
+	printf("}\n\n")
+	printf("// pristine return true if the compiled instructions haven't been\n")
+	printf("// modified\n")
+	printf("func pristine(mem []uint8) bool {\n")
+	printf("\tfor _, c := range code {\n")
+	printf("\t\tif string(mem[c.at:c.at+len(c.bytes)]) != c.bytes {\n\t\t\treturn false\n\t\t}\n")
+	printf("\t}\n\treturn true\n}\n\n")
+	printf("// interpret continues the run in the interpreter\n")
+	printf("func interpret(mem []uint8, ip vm.Address, steps, max int) (int, vm.Address) {\n")
+	printf("\tc := vm.Computer{}\n")
+	printf("\tc.SetConfig(Config)\n")
+	printf("\tc.LoadMemory(mem)\n")
+	printf("\tc.SetIP(ip)\n")
+	printf("\tsteps += c.Run(max - steps)\n")
+	printf("\tc.ReadMemory(0, mem)\n")
+	printf("\treturn steps, c.IP()\n}\n")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(src)
+	return err
+}
@@ -0,0 +1,50 @@
+// This package holds the example program of the README, multiplying
+// two numbers by repeated sums, shared by the tests of the packages
+// taking programs.
+package example
+
+import "gosics/assembler"
+
+// labels of the program
+const (
+	OP1 = assembler.Label("OP1")
+	OP2 = assembler.Label("OP2")
+	DST = assembler.Label("DST")
+	CNT = assembler.Label("CNT")
+)
+
+// Multiply return the example program of the README: multiplies OP1
+// and OP2, 3 and 2, into DST and halts
+func Multiply(opts ...assembler.Option) assembler.Assembler {
+	as := assembler.New(opts...)
+	MultiplyLoop(&as)
+	as.HLT()
+	MultiplyData(&as)
+	return as
+}
+
+// MultiplyLoop emits the code of the program, continuing after the
+// loop at exit_loop once DST holds the product
+func MultiplyLoop(as *assembler.Assembler) {
+	loop := assembler.Label("loop")
+	exit := assembler.Label("exit_loop")
+	as.MOV(OP1, CNT)
+	as.MOV(assembler.ZERO, DST)
+	as.Label(loop)
+	as.BEQ(CNT, assembler.ZERO, exit)
+	as.ADD(OP2, DST, DST)
+	as.DEC(CNT)
+	as.JMP(loop)
+	as.Label(exit)
+}
+
+// MultiplyData emits the data of the program
+func MultiplyData(as *assembler.Assembler) {
+	for _, d := range []struct {
+		label assembler.Label
+		value uint16
+	}{{OP1, 3}, {OP2, 2}, {DST, 0}, {CNT, 0}} {
+		as.Label(d.label)
+		as.DD(d.value)
+	}
+}
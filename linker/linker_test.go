@@ -0,0 +1,199 @@
+package linker
+
+import (
+	"gosics/assembler"
+	"gosics/vm"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// t_object assembles the module as an object named name
+func t_object(t *testing.T, as *assembler.Assembler, name string) *assembler.Object {
+	obj, err := as.Object()
+	assert.Nil(t, err)
+	obj.Name = name
+	return obj
+}
+
+// t_run load the program in a new computer and run it until halted
+func t_run(program []uint8) vm.Computer {
+	c := vm.Computer{}
+	c.LoadMemory(program)
+	for i := 0; i < 1000 && !c.Halted(); i++ {
+		c.Step()
+	}
+	return c
+}
+
+func TestLinkResolvesReferencesBetweenObjects(t *testing.T) {
+	main := assembler.NewModule()
+	main.MOV(assembler.Label("VALUE"), assembler.Label("RESULT"))
+	main.HLT()
+	main.Label("RESULT")
+	main.DD(0)
+
+	lib := assembler.NewModule()
+	lib.DD(0xAAAA)
+	lib.Label("VALUE")
+	lib.DD(0x1234)
+	lib.Export("VALUE")
+
+	program, symbols, err := Link(t_object(t, &main, "main"), t_object(t, &lib, "lib"))
+	assert.Nil(t, err)
+	c := t_run(program)
+	assert.True(t, c.Halted())
+
+	result, ok := symbols.Lookup("main.RESULT")
+	assert.True(t, ok)
+	assert.Equal(t, vm.Operand(0x1234), c.Peek(result))
+	value, ok := symbols.Lookup("VALUE")
+	assert.True(t, ok)
+	assert.Equal(t, vm.Operand(0x1234), c.Peek(value))
+}
+
+func TestLinkRelocatesObjects(t *testing.T) {
+	main := assembler.NewModule()
+	main.JMP(assembler.Label("routine"))
+	main.Label("back")
+	main.HLT()
+	main.Export("back")
+
+	// uses PUSH/POP, that generate addresses relative to the IP
+	lib := assembler.NewModule()
+	lib.Label("routine")
+	lib.PUSH(assembler.Label("SRC"))
+	lib.POP(assembler.Label("DST"))
+	lib.JMP(assembler.Label("back"))
+	lib.Label("SRC")
+	lib.DD(0x1234)
+	lib.Label("DST")
+	lib.DD(0)
+	lib.Export("routine")
+
+	program, symbols, err := Link(t_object(t, &main, "main"), t_object(t, &lib, "lib"))
+	assert.Nil(t, err)
+	c := t_run(program)
+	assert.True(t, c.Halted())
+	dst, _ := symbols.Lookup("lib.DST")
+	assert.Equal(t, vm.Operand(0x1234), c.Peek(dst))
+}
+
+func TestLinkSingleObjectMatchesAssemble(t *testing.T) {
+	mod := assembler.NewModule()
+	mod.MOV(assembler.Label("SRC"), assembler.Label("DST"))
+	mod.PUSH(assembler.Label("SRC"))
+	mod.HLT()
+	mod.Label("SRC")
+	mod.DD(0x1234)
+	mod.Label("DST")
+	mod.DD(0)
+
+	as := assembler.New()
+	as.MOV(assembler.Label("SRC"), assembler.Label("DST"))
+	as.PUSH(assembler.Label("SRC"))
+	as.HLT()
+	as.Label("SRC")
+	as.DD(0x1234)
+	as.Label("DST")
+	as.DD(0)
+
+	program, _, err := Link(t_object(t, &mod, ""))
+	assert.Nil(t, err)
+	assert.Equal(t, as.Assemble(), program)
+}
+
+func TestLinkReportsUnresolvedSymbols(t *testing.T) {
+	main := assembler.NewModule()
+	main.JMP(assembler.Label("nowhere"))
+	lib := assembler.NewModule()
+	lib.JMP(assembler.Label("nowhere"))
+
+	_, _, err := Link(t_object(t, &main, "main"), t_object(t, &lib, ""))
+	assert.Equal(t, &Error{
+		Unresolved: map[string][]string{"nowhere": {"main", "#2"}},
+		Duplicated: map[string][]string{},
+	}, err)
+	assert.Equal(t, "unresolved symbol 'nowhere' referenced by main, #2", err.Error())
+}
+
+func TestLinkReportsDuplicatedSymbols(t *testing.T) {
+	one := assembler.NewModule()
+	one.Label("foo")
+	one.HLT()
+	one.Export("foo")
+	two := assembler.NewModule()
+	two.Label("foo")
+	two.HLT()
+	two.Export("foo")
+
+	_, _, err := Link(t_object(t, &one, "one"), t_object(t, &two, "two"))
+	assert.Equal(t, "duplicated symbol 'foo' exported by one, two", err.Error())
+}
+
+func TestLinkLocalLabelsDoNotCollide(t *testing.T) {
+	one := assembler.NewModule()
+	one.JMP(assembler.Label("next"))
+	one.Label("next")
+	two := assembler.NewModule()
+	two.JMP(assembler.Label("next"))
+	two.Label("next")
+	two.HLT()
+
+	program, _, err := Link(t_object(t, &one, "one"), t_object(t, &two, "two"))
+	assert.Nil(t, err)
+	c := t_run(program)
+	assert.True(t, c.Halted())
+}
+
+func TestLinkWithConfig(t *testing.T) {
+	config := vm.Config{WordSize: 24, Order: vm.LittleEndian}
+	main := assembler.NewModule(assembler.WithConfig(config))
+	main.PUSH(assembler.Label("VALUE"))
+	main.POP(assembler.Label("RESULT"))
+	main.HLT()
+	main.Label("RESULT")
+	main.DD(0)
+
+	lib := assembler.NewModule(assembler.WithConfig(config))
+	lib.Label("VALUE")
+	lib.DW(0x123456)
+	lib.Export("VALUE")
+
+	program, symbols, err := Link(t_object(t, &main, "main"), t_object(t, &lib, "lib"))
+	assert.Nil(t, err)
+	c := vm.Computer{}
+	c.SetConfig(config)
+	c.LoadMemory(program)
+	for i := 0; i < 1000 && !c.Halted(); i++ {
+		c.Step()
+	}
+	assert.True(t, c.Halted())
+	assert.Nil(t, c.Fault())
+	result, _ := symbols.Lookup("main.RESULT")
+	assert.Equal(t, vm.Operand(0x123456), c.Peek(result))
+}
+
+func TestLinkConfigMismatch(t *testing.T) {
+	main := assembler.NewModule()
+	main.HLT()
+	lib := assembler.NewModule(assembler.WithConfig(vm.Config{WordSize: 32}))
+	lib.DD(0)
+
+	_, _, err := Link(t_object(t, &main, "main"), t_object(t, &lib, "lib"))
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{"lib"}, err.(*Error).Mismatch)
+	assert.Equal(t, "configuration mismatch in lib", err.Error())
+}
+
+func TestLinkOverflow(t *testing.T) {
+	config := vm.Config{WordSize: 8}
+	main := assembler.NewModule(assembler.WithConfig(config))
+	main.HLT()
+	lib := assembler.NewModule(assembler.WithConfig(config))
+	lib.DB(make([]uint8, 250)...)
+
+	_, _, err := Link(t_object(t, &main, "main"), t_object(t, &lib, "lib"))
+	assert.NotNil(t, err)
+	assert.True(t, err.(*Error).Overflow)
+}
@@ -0,0 +1,212 @@
+// This package implements a linker that combines several relocatable
+// objects, created by the assembler, into a program:
+//
+// - the preamble (constants...) is emitted once, at the beginning of
+// the program. Execution starts at the first object.
+//
+// - the runtime routines required by the objects are emitted once, at
+// the end of the program.
+//
+// - objects are placed in memory one after the other, in the given
+// order, and their addresses relocated.
+//
+// - references to labels defined in other objects are resolved using
+// the labels exported by each object.
+//
+// Example:
+//
+//	main := assembler.NewModule()
+//	main.MOV(assembler.Label("VALUE"), assembler.Label("RESULT"))
+//	...
+//	lib := assembler.NewModule()
+//	lib.Label("VALUE")
+//	lib.DD(0x1234)
+//	lib.Export("VALUE")
+//	...
+//	program, symbols, err := linker.Link(mainObj, libObj)
+package linker
+
+import (
+	"fmt"
+	"gosics/assembler"
+	"gosics/vm"
+	"sort"
+	"strings"
+)
+
+// Error lists the problems found while linking
+type Error struct {
+	// Unresolved maps each symbol not defined by any object to the
+	// objects referencing it
+	Unresolved map[string][]string
+	// Duplicated maps each symbol exported by more than one object
+	// to the objects exporting it
+	Duplicated map[string][]string
+	// Overflow is true if the program does not fit in memory
+	Overflow bool
+	// Mismatch lists the objects whose configuration (word size and
+	// byte order) differs from the first object's
+	Mismatch []string
+}
+
+func (self *Error) Error() string {
+	var msgs []string
+	for _, s := range sortedKeys(self.Duplicated) {
+		msgs = append(msgs, fmt.Sprintf("duplicated symbol '%s' exported by %s",
+			s, strings.Join(self.Duplicated[s], ", ")))
+	}
+	for _, s := range sortedKeys(self.Unresolved) {
+		msgs = append(msgs, fmt.Sprintf("unresolved symbol '%s' referenced by %s",
+			s, strings.Join(self.Unresolved[s], ", ")))
+	}
+	if self.Overflow {
+		msgs = append(msgs, "program too big")
+	}
+	if len(self.Mismatch) > 0 {
+		msgs = append(msgs, "configuration mismatch in "+strings.Join(self.Mismatch, ", "))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (self *Error) empty() bool {
+	return len(self.Unresolved) == 0 && len(self.Duplicated) == 0 && !self.Overflow &&
+		len(self.Mismatch) == 0
+}
+
+func sortedKeys(m map[string][]string) []string {
+	res := make([]string, 0, len(m))
+	for k := range m {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// objectName return the name of the i-th object, used in error
+// messages and symbol tables
+func objectName(obj *assembler.Object, i int) string {
+	if obj.Name != "" {
+		return obj.Name
+	}
+	return fmt.Sprintf("#%d", i)
+}
+
+// Link combines the objects into a program. Returns the memory image
+// and the symbol table. The symbol table contains the exported
+// symbols and the local symbols of each object, prefixed by the
+// object name, "name.label", for objects with a name. All the objects
+// must have the same configuration.
+func Link(objects ...*assembler.Object) ([]uint8, vm.SymbolTable, error) {
+	lerr := &Error{
+		Unresolved: make(map[string][]string),
+		Duplicated: make(map[string][]string),
+	}
+	config := vm.DefaultConfig
+	if len(objects) > 0 {
+		config = objects[0].Config
+	}
+	for i, obj := range objects {
+		if !obj.Config.Equal(config) {
+			lerr.Mismatch = append(lerr.Mismatch, objectName(obj, i+1))
+		}
+	}
+	if !lerr.empty() {
+		return nil, nil, lerr
+	}
+
+	ass := assembler.New(assembler.WithConfig(config))
+	preamble, err := ass.Object()
+	if err != nil {
+		return nil, nil, err
+	}
+	preamble.Name = "preamble"
+	objects = append([]*assembler.Object{preamble}, objects...)
+
+	// place the objects and collect the exported symbols
+	bases := make([]vm.Address, len(objects))
+	size := uint64(0)
+	// place return the base of the code, false if it doesn't fit
+	place := func(code []uint8) (vm.Address, bool) {
+		base := size
+		size += uint64(len(code))
+		return vm.Address(base), size <= config.MemorySize()
+	}
+	global := make(map[assembler.Label]vm.Address)
+	exporter := make(map[assembler.Label]string)
+	symbols := make(map[string]vm.Address)
+	for i, obj := range objects {
+		base, ok := place(obj.Code)
+		if !ok {
+			lerr.Overflow = true
+			return nil, nil, lerr
+		}
+		bases[i] = base
+		for _, l := range obj.Exports {
+			if other, ok := exporter[l]; ok {
+				if len(lerr.Duplicated[string(l)]) == 0 {
+					lerr.Duplicated[string(l)] = []string{other}
+				}
+				lerr.Duplicated[string(l)] = append(lerr.Duplicated[string(l)], objectName(obj, i))
+				continue
+			}
+			exporter[l] = objectName(obj, i)
+			global[l] = bases[i] + vm.Address(obj.Symbols[l])
+			symbols[string(l)] = global[l]
+		}
+		if obj.Name != "" {
+			for l, a := range obj.Symbols {
+				symbols[obj.Name+"."+string(l)] = bases[i] + vm.Address(a)
+			}
+		}
+	}
+
+	// runtime routines
+	var needed []assembler.Label
+	for _, obj := range objects {
+		for l := range obj.Imports {
+			if _, ok := global[l]; !ok {
+				needed = append(needed, l)
+			}
+		}
+	}
+	runtime, err := assembler.RuntimeObject(config, needed...)
+	if err != nil {
+		return nil, nil, err
+	}
+	base, ok := place(runtime.Code)
+	if !ok {
+		lerr.Overflow = true
+		return nil, nil, lerr
+	}
+	bases = append(bases, base)
+	objects = append(objects, runtime)
+	for _, l := range runtime.Exports {
+		global[l] = bases[len(bases)-1] + vm.Address(runtime.Symbols[l])
+		symbols[string(l)] = global[l]
+	}
+
+	// relocate and resolve references
+	program := make([]uint8, size)
+	for i, obj := range objects {
+		base := bases[i]
+		code := program[base : int(base)+len(obj.Code)]
+		copy(code, obj.Code)
+		for _, p := range obj.Relocations {
+			config.PutWord(code[p:], config.Word(code[p:])+base)
+		}
+		for l, positions := range obj.Imports {
+			a, ok := global[l]
+			if !ok {
+				lerr.Unresolved[string(l)] = append(lerr.Unresolved[string(l)], objectName(obj, i))
+				continue
+			}
+			for _, p := range positions {
+				config.PutWord(code[p:], a)
+			}
+		}
+	}
+	if !lerr.empty() {
+		return nil, nil, lerr
+	}
+	return program, vm.NewSymbolTable(symbols), nil
+}
@@ -1,4 +1,60 @@
+// Command gosics checks programs written in assembler source, see
+// assembler.Assembler.Source.
+//
+// Usage:
+//
+//	gosics lint source...
+//
+// assembles the sources and prints the diagnostics found by the
+// assembler's Lint, as file:line: message, with the paths given.
+// Exits with status 1 if there are any, 2 if a source can't be
+// assembled.
 package main
 
+import (
+	"fmt"
+	"gosics/assembler"
+	"io"
+	"os"
+)
+
+// usage the command line syntax
+const usage = "usage: gosics lint source..."
+
+// lint prints the diagnostics of the sources, returns how many
+func lint(w io.Writer, paths []string) (int, error) {
+	n := 0
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return n, err
+		}
+		as := assembler.New(assembler.WithLint(true))
+		as.Source(path, f)
+		f.Close()
+		as.Assemble()
+		if err := as.Err(); err != nil {
+			return n, err
+		}
+		for _, d := range as.Lint() {
+			fmt.Fprintln(w, d)
+			n++
+		}
+	}
+	return n, nil
+}
+
 func main() {
+	if len(os.Args) < 3 || os.Args[1] != "lint" {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	n, err := lint(os.Stdout, os.Args[2:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if n > 0 {
+		os.Exit(1)
+	}
 }
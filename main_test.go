@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// t_writeSource writes the source into dir
+func t_writeSource(t *testing.T, dir, name, text string) string {
+	path := filepath.Join(dir, name)
+	assert.Nil(t, ioutil.WriteFile(path, []byte(text), 0644))
+	return path
+}
+
+func TestLint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosics")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	clean := t_writeSource(t, dir, "clean.s", "main:   HLT\n        EXPORT main\n")
+	dirty := t_writeSource(t, dir, "dirty.s", "        HLT\nunused: HLT\n")
+
+	var out bytes.Buffer
+	n, err := lint(&out, []string{clean, dirty})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, dirty+":2: label unused defined but never referenced\n", out.String())
+
+	_, err = lint(&out, []string{filepath.Join(dir, "missing.s")})
+	assert.NotNil(t, err)
+
+	broken := t_writeSource(t, dir, "broken.s", "        JMP\n")
+	_, err = lint(&out, []string{broken})
+	assert.NotNil(t, err)
+	assert.Equal(t, broken+":1: JMP: 1 operands expected, got 0", err.Error())
+}
@@ -0,0 +1,469 @@
+// This package searches the shortest SBNZ sequences implementing the
+// effect of a macro instruction on a few memory cells, like dst = a +
+// b, to replace the hand-written macros of the assembler with shorter
+// ones.
+//
+// A Spec names the cells read and written and computes the expected
+// outputs. The sequences use the cells of the spec, the assembler's
+// __ONE, __ZERO and __JUNK constants and the scratch cells t0, t1...
+// Instructions only branch forward, to a later instruction or to the
+// end of the sequence, so every sequence terminates, and never write
+// into __ONE, __ZERO or the inputs not written by the spec. __JUNK,
+// the scratch cells and the outputs start with any value.
+//
+// The candidates are checked against edge-case and random inputs,
+// first with a quick evaluator and then on a vm.Computer. Passing the
+// tests is not a proof: the results should be reviewed before
+// replacing a macro, specially for inputs aliasing the outputs.
+package superopt
+
+import (
+	"fmt"
+	"gosics/assembler"
+	"gosics/vm"
+	"math"
+	"math/bits"
+	"math/rand"
+	"strings"
+)
+
+// DefaultTests the random inputs checked, unless set
+const DefaultTests = 100
+
+// Spec the effect of a macro instruction on a few memory cells
+type Spec struct {
+	// Inputs the names of the cells read
+	Inputs []string
+	// Outputs the names of the cells written, may be inputs
+	Outputs []string
+	// Temps the number of scratch cells, besides __JUNK
+	Temps int
+	// Func return the outputs for the inputs, in order
+	Func func(in []vm.Operand) []vm.Operand
+}
+
+// Instruction a SBNZ a, b, c, d instruction: A, B and C index the
+// cells of the search, D the instruction jumped to, the length of the
+// program for the end
+type Instruction struct {
+	A, B, C, D int
+}
+
+// Program a sequence of SBNZ instructions
+type Program struct {
+	Code  []Instruction
+	cells []string
+}
+
+// String return the program as SBNZ instructions, one per line
+func (self *Program) String() string {
+	var b strings.Builder
+	for i, in := range self.Code {
+		d := "end"
+		if in.D < len(self.Code) {
+			d = fmt.Sprint(in.D)
+		}
+		fmt.Fprintf(&b, "%d: SBNZ %s, %s, %s, %s\n", i, self.cells[in.A], self.cells[in.B], self.cells[in.C], d)
+	}
+	return b.String()
+}
+
+// the cells of every search
+const (
+	one = iota
+	zero
+	junk
+)
+
+// test the values of the cells before running a program and the
+// expected outputs
+type test struct {
+	values   []vm.Operand
+	expected []vm.Operand
+}
+
+// Search looks for SBNZ sequences implementing a Spec
+type Search struct {
+	Spec Spec
+	// Config the words of the computer, DefaultConfig if zero
+	Config vm.Config
+	// Tests the random inputs checked, besides the edge cases,
+	// DefaultTests if 0
+	Tests int
+	// Seed of the random inputs and of the stochastic search
+	Seed int64
+
+	cells    []string
+	inputs   []int // cells of the inputs
+	outputs  []int // cells of the outputs
+	writable []int // cells the instructions may write into
+	tests    []test
+	rnd      *rand.Rand
+}
+
+// prepare checks the spec and generates the tests
+func (self *Search) prepare() error {
+	if self.cells != nil {
+		return nil
+	}
+	if err := self.Config.Validate(); err != nil {
+		return err
+	}
+	if len(self.Spec.Outputs) == 0 {
+		return fmt.Errorf("no outputs")
+	}
+	if self.Spec.Func == nil {
+		return fmt.Errorf("no Func")
+	}
+	cells := []string{assembler.ONE.String(), assembler.ZERO.String(), assembler.JUNK.String()}
+	index := map[string]int{}
+	for i, c := range cells {
+		index[c] = i
+	}
+	var inputs, outputs []int
+	for _, names := range []*[]string{&self.Spec.Inputs, &self.Spec.Outputs} {
+		outs := names == &self.Spec.Outputs
+		seen := map[string]bool{}
+		for _, n := range *names {
+			if seen[n] {
+				return fmt.Errorf("duplicate cell %s", n)
+			}
+			seen[n] = true
+			i, ok := index[n]
+			if !ok {
+				i = len(cells)
+				cells = append(cells, n)
+				index[n] = i
+			} else if i <= junk {
+				return fmt.Errorf("%s is reserved", n)
+			}
+			if outs {
+				outputs = append(outputs, i)
+			} else {
+				inputs = append(inputs, i)
+			}
+		}
+	}
+	for i := 0; i < self.Spec.Temps; i++ {
+		n := fmt.Sprintf("t%d", i)
+		if _, ok := index[n]; ok {
+			return fmt.Errorf("%s is reserved", n)
+		}
+		cells = append(cells, n)
+	}
+	writable := []int{junk}
+	writable = append(writable, outputs...)
+	for i := len(cells) - self.Spec.Temps; i < len(cells); i++ {
+		writable = append(writable, i)
+	}
+
+	self.cells, self.inputs, self.outputs, self.writable = cells, inputs, outputs, writable
+	self.rnd = rand.New(rand.NewSource(self.Seed))
+	tests, err := self.generate()
+	if err != nil {
+		self.cells = nil
+		return err
+	}
+	self.tests = tests
+	return nil
+}
+
+// edges the edge cases of the inputs
+func (self *Search) edges() []vm.Operand {
+	max := self.Config.Signed(self.Config.MaxAddress() >> 1)
+	res := []vm.Operand{0, 1, -1, 2, -2, max, -max - 1, max - 1, -max}
+	return append(res, self.Config.Wrap(0x55555555), self.Config.Wrap(0x2AAAAAAA))
+}
+
+// random return a random word
+func (self *Search) random() vm.Operand {
+	return self.Config.Wrap(vm.Operand(self.rnd.Uint32()))
+}
+
+// generate return the tests: every combination of the edge cases, if
+// not too many, and random inputs. The other cells start random.
+func (self *Search) generate() ([]test, error) {
+	edges := self.edges()
+	var inputs [][]vm.Operand
+	if n := len(self.inputs); math.Pow(float64(len(edges)), float64(n)) <= 1000 {
+		inputs = [][]vm.Operand{nil}
+		for k := 0; k < n; k++ {
+			var next [][]vm.Operand
+			for _, in := range inputs {
+				for _, e := range edges {
+					next = append(next, append(append([]vm.Operand{}, in...), e))
+				}
+			}
+			inputs = next
+		}
+	}
+	tests := self.Tests
+	if tests == 0 {
+		tests = DefaultTests
+	}
+	for i := 0; i < tests; i++ {
+		in := make([]vm.Operand, len(self.inputs))
+		for k := range in {
+			in[k] = self.random()
+		}
+		inputs = append(inputs, in)
+	}
+
+	res := make([]test, len(inputs))
+	for i, in := range inputs {
+		values := make([]vm.Operand, len(self.cells))
+		for k := range values {
+			values[k] = self.random()
+		}
+		values[one], values[zero] = 1, 0
+		for k, c := range self.inputs {
+			values[c] = in[k]
+		}
+		expected := self.Spec.Func(append([]vm.Operand{}, in...))
+		if len(expected) != len(self.outputs) {
+			return nil, fmt.Errorf("Func returned %d outputs, want %d", len(expected), len(self.outputs))
+		}
+		for k, e := range expected {
+			expected[k] = self.Config.Wrap(e)
+		}
+		res[i] = test{values, expected}
+	}
+	return res, nil
+}
+
+// eval runs the code on the values of the cells
+func (self *Search) eval(code []Instruction, values []vm.Operand) {
+	for i := 0; i < len(code); {
+		in := code[i]
+		r := self.Config.Wrap(values[in.A] - values[in.B])
+		values[in.C] = r
+		if r != 0 {
+			i = in.D
+		} else {
+			i++
+		}
+	}
+}
+
+// cost return the wrong bits of the outputs over the tests, stops
+// counting after limit
+func (self *Search) cost(code []Instruction, limit int) int {
+	res := 0
+	values := make([]vm.Operand, len(self.cells))
+	mask := uint32(self.Config.MaxAddress())
+	for _, t := range self.tests {
+		copy(values, t.values)
+		self.eval(code, values)
+		for k, c := range self.outputs {
+			res += bits.OnesCount32(uint32(values[c]^t.expected[k]) & mask)
+		}
+		if res > limit {
+			break
+		}
+	}
+	return res
+}
+
+// Verify return true if the program passes the tests on a vm.Computer
+func (self *Search) Verify(p *Program) (bool, error) {
+	if err := self.prepare(); err != nil {
+		return false, err
+	}
+	n := self.Config.Bytes()
+	width := 4 * n
+	// the code, a HLT after it and the cells
+	base := vm.Address(len(p.Code)+1) * width
+	if uint64(base)+uint64(len(self.cells))*uint64(n) > uint64(self.Config.MaxAddress()) {
+		return false, fmt.Errorf("program too long")
+	}
+	image := make([]uint8, base+vm.Address(len(self.cells))*n)
+	cell := func(c int) vm.Address { return base + vm.Address(c)*n }
+	put := func(p, w vm.Address) { self.Config.PutWord(image[p:], w) }
+	for i, in := range p.Code {
+		d := self.Config.MaxAddress()
+		if in.D < len(p.Code) {
+			d = vm.Address(in.D) * width
+		}
+		at := vm.Address(i) * width
+		put(at, cell(in.A))
+		put(at+n, cell(in.B))
+		put(at+2*n, cell(in.C))
+		put(at+3*n, d)
+	}
+	end := vm.Address(len(p.Code)) * width
+	put(end, cell(one))
+	put(end+n, cell(zero))
+	put(end+2*n, cell(junk))
+	put(end+3*n, self.Config.MaxAddress())
+
+	for _, t := range self.tests {
+		for c, v := range t.values {
+			put(cell(c), vm.Address(v))
+		}
+		c := vm.Computer{}
+		c.SetConfig(self.Config)
+		c.LoadMemory(image)
+		c.Run(len(p.Code) + 1)
+		if !c.Halted() || c.Fault() != nil {
+			return false, nil
+		}
+		for k, o := range self.outputs {
+			if c.Peek(cell(o)) != t.expected[k] {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// found return the program if the code passes the tests, nil
+// otherwise
+func (self *Search) found(code []Instruction) (*Program, error) {
+	if self.cost(code, 0) > 0 {
+		return nil, nil
+	}
+	p := &Program{append([]Instruction{}, code...), self.cells}
+	if ok, err := self.Verify(p); !ok || err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Exhaustive return the shortest program, up to max instructions,
+// passing the tests, nil if there's none. The candidates of each
+// length are tried in order, so the result is deterministic.
+func (self *Search) Exhaustive(max int) (*Program, error) {
+	if err := self.prepare(); err != nil {
+		return nil, err
+	}
+	for n := 1; n <= max; n++ {
+		code := make([]Instruction, n)
+		var res *Program
+		var err error
+		var try func(i int) bool
+		try = func(i int) bool {
+			if i == n {
+				res, err = self.found(code)
+				return res != nil || err != nil
+			}
+			for a := range self.cells {
+				for b := range self.cells {
+					for _, c := range self.writable {
+						// a - b is zero when a == b, d doesn't matter
+						d := i + 1
+						if a != b {
+							d = n
+						}
+						for ; d > i; d-- {
+							code[i] = Instruction{a, b, c, d}
+							if try(i + 1) {
+								return true
+							}
+							if a == b {
+								break
+							}
+						}
+					}
+				}
+			}
+			return false
+		}
+		if try(0) {
+			return res, err
+		}
+	}
+	return nil, nil
+}
+
+// randomInstruction return a random instruction at position i of a
+// program of n instructions
+func (self *Search) randomInstruction(i, n int) Instruction {
+	return Instruction{
+		A: self.rnd.Intn(len(self.cells)),
+		B: self.rnd.Intn(len(self.cells)),
+		C: self.writable[self.rnd.Intn(len(self.writable))],
+		D: i + 1 + self.rnd.Intn(n-i),
+	}
+}
+
+// Stochastic searches a program of up to length instructions with
+// random mutations: a random operand, or a whole instruction, is
+// replaced and the mutation kept if it doesn't increase the wrong
+// bits of the outputs, or with decreasing probability when it does.
+// The program found is shortened removing the instructions not
+// needed. Returns nil if nothing is found in the iterations.
+func (self *Search) Stochastic(length, iterations int) (*Program, error) {
+	if err := self.prepare(); err != nil {
+		return nil, err
+	}
+	if length < 1 {
+		return nil, fmt.Errorf("invalid length %d", length)
+	}
+	code := make([]Instruction, length)
+	for i := range code {
+		code[i] = self.randomInstruction(i, length)
+	}
+	cost := self.cost(code, math.MaxInt32)
+	next := make([]Instruction, length)
+	for it := 0; it < iterations; it++ {
+		copy(next, code)
+		i := self.rnd.Intn(length)
+		r := self.randomInstruction(i, length)
+		switch self.rnd.Intn(5) {
+		case 0:
+			next[i].A = r.A
+		case 1:
+			next[i].B = r.B
+		case 2:
+			next[i].C = r.C
+		case 3:
+			next[i].D = r.D
+		default:
+			next[i] = r
+		}
+		c := self.cost(next, math.MaxInt32)
+		if c == 0 {
+			p, err := self.found(next)
+			if err != nil {
+				return nil, err
+			}
+			if p != nil {
+				return self.shorten(p)
+			}
+		}
+		if c <= cost || self.rnd.Float64() < math.Exp(float64(cost-c)) {
+			code, next = next, code
+			cost = c
+		}
+	}
+	return nil, nil
+}
+
+// shorten removes the instructions of the program not needed to pass
+// the tests
+func (self *Search) shorten(p *Program) (*Program, error) {
+	for i := 0; i < len(p.Code); {
+		var code []Instruction
+		for k, in := range p.Code {
+			if k == i {
+				continue
+			}
+			if in.D > i {
+				in.D--
+			}
+			code = append(code, in)
+		}
+		q, err := self.found(code)
+		if err != nil {
+			return nil, err
+		}
+		if q != nil && len(code) > 0 {
+			// removing an instruction may make the previous ones
+			// unnecessary
+			p, i = q, 0
+		} else {
+			i++
+		}
+	}
+	return p, nil
+}
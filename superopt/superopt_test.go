@@ -0,0 +1,111 @@
+package superopt
+
+import (
+	"gosics/vm"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// t_specs the specs of some macro instructions
+var t_specs = map[string]Spec{
+	"MOV": {Inputs: []string{"a"}, Outputs: []string{"dst"},
+		Func: func(in []vm.Operand) []vm.Operand { return []vm.Operand{in[0]} }},
+	"ADD": {Inputs: []string{"a", "b"}, Outputs: []string{"dst"},
+		Func: func(in []vm.Operand) []vm.Operand { return []vm.Operand{in[0] + in[1]} }},
+	"INC": {Inputs: []string{"a"}, Outputs: []string{"a"},
+		Func: func(in []vm.Operand) []vm.Operand { return []vm.Operand{in[0] + 1} }},
+	"NOT": {Inputs: []string{"a"}, Outputs: []string{"dst"},
+		Func: func(in []vm.Operand) []vm.Operand { return []vm.Operand{^in[0]} }},
+	"SWAP": {Inputs: []string{"a", "b"}, Outputs: []string{"a", "b"},
+		Func: func(in []vm.Operand) []vm.Operand { return []vm.Operand{in[1], in[0]} }},
+	"AND": {Inputs: []string{"a", "b"}, Outputs: []string{"dst"},
+		Func: func(in []vm.Operand) []vm.Operand { return []vm.Operand{in[0] & in[1]} }},
+}
+
+func TestExhaustive(t *testing.T) {
+	for name, expected := range map[string]string{
+		"MOV": "0: SBNZ a, __ZERO, dst, end\n",
+		"ADD": "0: SBNZ __ZERO, a, __JUNK, 1\n1: SBNZ b, __JUNK, dst, end\n",
+		"INC": "0: SBNZ __ZERO, __ONE, __JUNK, 1\n1: SBNZ a, __JUNK, a, end\n",
+		// one instruction shorter than the assembler's NOT
+		"NOT":  "0: SBNZ __ZERO, __ONE, __JUNK, 1\n1: SBNZ __JUNK, a, dst, end\n",
+		"SWAP": "0: SBNZ __ONE, a, __JUNK, 1\n1: SBNZ b, __ZERO, a, 2\n2: SBNZ __ONE, __JUNK, b, end\n",
+	} {
+		s := Search{Spec: t_specs[name]}
+		p, err := s.Exhaustive(3)
+		assert.Nil(t, err, name)
+		if assert.NotNil(t, p, name) {
+			assert.Equal(t, expected, p.String(), name)
+		}
+	}
+}
+
+func TestExhaustiveNotFound(t *testing.T) {
+	s := Search{Spec: t_specs["AND"]}
+	p, err := s.Exhaustive(2)
+	assert.Nil(t, err)
+	assert.Nil(t, p)
+}
+
+func TestExhaustiveConfig(t *testing.T) {
+	s := Search{Spec: t_specs["ADD"], Config: vm.Config{WordSize: 8, Order: vm.LittleEndian}}
+	p, err := s.Exhaustive(2)
+	assert.Nil(t, err)
+	if assert.NotNil(t, p) {
+		assert.Equal(t, 2, len(p.Code))
+	}
+}
+
+func TestStochastic(t *testing.T) {
+	s := Search{Spec: t_specs["NOT"], Seed: 1}
+	p, err := s.Stochastic(4, 100000)
+	assert.Nil(t, err)
+	if assert.NotNil(t, p) {
+		// shortened
+		assert.Equal(t, "0: SBNZ __ZERO, a, dst, 1\n1: SBNZ dst, __ONE, dst, end\n", p.String())
+	}
+
+	s = Search{Spec: t_specs["AND"], Seed: 1}
+	p, err = s.Stochastic(2, 1000)
+	assert.Nil(t, err)
+	assert.Nil(t, p)
+
+	_, err = s.Stochastic(0, 1000)
+	assert.NotNil(t, err)
+}
+
+func TestVerify(t *testing.T) {
+	s := Search{Spec: t_specs["NOT"]}
+	// the assembler's NOT: ADD a, __ONE, dst; NEG dst, dst
+	ok, err := s.Verify(&Program{Code: []Instruction{{zero, one, junk, 1}, {3, junk, 4, 2}, {zero, 4, 4, 3}}})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	ok, err = s.Verify(&Program{Code: []Instruction{{3, zero, 4, 1}}})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// branching over the negation
+	ok, err = s.Verify(&Program{Code: []Instruction{{zero, 3, 4, 2}, {4, one, 4, 2}}})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestSpecErrors(t *testing.T) {
+	id := func(in []vm.Operand) []vm.Operand { return in }
+	for msg, s := range map[string]Search{
+		"no outputs":                      {Spec: Spec{Inputs: []string{"a"}, Func: id}},
+		"no Func":                         {Spec: Spec{Outputs: []string{"a"}}},
+		"duplicate cell a":                {Spec: Spec{Inputs: []string{"a", "a"}, Outputs: []string{"a", "b"}, Func: id}},
+		"__JUNK is reserved":              {Spec: Spec{Inputs: []string{"__JUNK"}, Outputs: []string{"a"}, Func: id}},
+		"t0 is reserved":                  {Spec: Spec{Outputs: []string{"t0"}, Temps: 1, Func: id}},
+		"Func returned 1 outputs, want 2": {Spec: Spec{Inputs: []string{"a"}, Outputs: []string{"a", "b"}, Func: id}},
+		"unsupported word size 12":        {Spec: t_specs["MOV"], Config: vm.Config{WordSize: 12}},
+	} {
+		_, err := s.Exhaustive(1)
+		if assert.NotNil(t, err, msg) {
+			assert.Equal(t, msg, err.Error())
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// This package translates programs between instruction sets: SBNZ
+// programs into equivalent SUBLEQ programs and vice versa. Programs
+// are equivalent if, after running them to completion, the data at
+// the addresses pointed by the symbols is the same.
+//
+// The translators work on memory images and their symbol tables, as
+// produced by the assembler:
+//
+// - the code is found following the control flow from address 0,
+// the rest of the program is data.
+//
+// - each instruction is translated into a block of instructions in
+// the target instruction set, blocks are placed in the same order as
+// the original instructions.
+//
+// - the data is copied after the code and the operands, and the
+// symbols, are relocated accordingly. Addresses past the end of the
+// program are not relocated.
+//
+// Self-modifying code can't be translated, the PUSH and POP macro
+// instructions rely on it.
+//
+// The SUBLEQ programs run on the vm.SUBLEQ instruction set. Both
+// programs use the same configuration, word size and byte order.
+package translate
+
+import (
+	"fmt"
+	"gosics/vm"
+	"sort"
+)
+
+// Address an address in the source program
+type Address = vm.Address
+
+// decoder describes an instruction set for the translators
+type decoder struct {
+	words Address // operands of an instruction
+	// next returns the addresses that may be executed after the
+	// instruction at p
+	next func(p *program, at Address) []Address
+	// operands returns the data addresses accessed by the instruction
+	operands func(p *program, at Address) []Address
+}
+
+// program a program being translated
+type program struct {
+	config  vm.Config
+	width   Address // size of an instruction in bytes
+	image   []uint8
+	symbols vm.SymbolTable
+	code    map[Address]bool // addresses of the instructions
+	order   []Address        // addresses of the instructions, sorted
+	data    []span           // data ranges, sorted
+}
+
+// span a range of addresses [from, to)
+type span struct {
+	from, to Address
+}
+
+// halt return true if jumping to a halts the computer
+func (self *program) halt(a Address) bool {
+	return a == self.config.MaxAddress()
+}
+
+// analyze finds the code following the control flow from address 0,
+// and the data, the rest of the program. Fails if the code is not
+// well formed or modifies itself.
+func analyze(image []uint8, symbols vm.SymbolTable, config vm.Config, d decoder) (*program, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	p := &program{config: config, width: d.words * config.Bytes(), image: image, symbols: symbols,
+		code: make(map[Address]bool)}
+	pending := []Address{0}
+	for len(pending) > 0 {
+		at := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if p.code[at] {
+			continue
+		}
+		if int(at)+int(p.width) > len(image) {
+			return nil, fmt.Errorf("jump outside the program at %04x", at)
+		}
+		p.code[at] = true
+		pending = append(pending, d.next(p, at)...)
+	}
+	for at := range p.code {
+		p.order = append(p.order, at)
+	}
+	sort.Slice(p.order, func(i, j int) bool { return p.order[i] < p.order[j] })
+
+	// instructions must not overlap
+	busy := make([]bool, len(image))
+	for _, at := range p.order {
+		for i := at; i < at+p.width; i++ {
+			if busy[i] {
+				return nil, fmt.Errorf("overlapping instructions at %04x", at)
+			}
+			busy[i] = true
+		}
+	}
+	for _, at := range p.order {
+		for _, o := range d.operands(p, at) {
+			for i := o; i < o+config.Bytes() && int(i) < len(image); i++ {
+				if busy[i] {
+					return nil, fmt.Errorf("self-modifying code at %04x, accesses %04x", at, o)
+				}
+			}
+		}
+	}
+	for i := 0; i < len(image); {
+		if busy[i] {
+			i++
+			continue
+		}
+		j := i
+		for j < len(image) && !busy[j] {
+			j++
+		}
+		p.data = append(p.data, span{Address(i), Address(j)})
+		i = j
+	}
+	return p, nil
+}
+
+// isData return true if a is a data address of the source program
+func (self *program) isData(a Address) bool {
+	for _, s := range self.data {
+		if a >= s.from && a < s.to {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSize fails if the translated program overlaps the addresses
+// past the end of the source program, they are not relocated
+func checkSize(p *program, size int, operands func(at Address) []Address) error {
+	if uint64(size) > p.config.MemorySize() {
+		return fmt.Errorf("translated program too big")
+	}
+	for _, at := range p.order {
+		for _, o := range operands(at) {
+			if int(o) >= len(p.image) && int(o) < size {
+				return fmt.Errorf("translated program overlaps address %04x", o)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package translate
+
+import (
+	"fmt"
+	"gosics/vm"
+)
+
+// sbnzDecoder SBNZ a, b, c, d. The instructions subtracting __ZERO
+// from __ONE, like the assembler's JMP, always branch and those with
+// a == b never branch.
+var sbnzDecoder = decoder{
+	words: 4,
+	next: func(p *program, at Address) []Address {
+		a, b, _, d := p.operands(at)
+		var res []Address
+		if !sbnzAlwaysBranches(p, at) {
+			res = append(res, at+p.width)
+		}
+		if a != b && !p.halt(d) {
+			res = append(res, d)
+		}
+		return res
+	},
+	operands: func(p *program, at Address) []Address {
+		a, b, c, _ := p.operands(at)
+		return []Address{a, b, c}
+	},
+}
+
+// operands return the operands of the instruction at p, the missing
+// ones are 0
+func (self *program) operands(at Address) (a, b, c, d Address) {
+	word, n := self.config.WordAt, self.config.Bytes()
+	return word(self.image, at), word(self.image, at+n), word(self.image, at+2*n), word(self.image, at+3*n)
+}
+
+// sbnzAlwaysBranches return true if the instruction subtracts __ZERO
+// from __ONE, according to the symbol table
+func sbnzAlwaysBranches(p *program, at Address) bool {
+	one, ok1 := p.symbols.Lookup("__ONE")
+	zero, ok2 := p.symbols.Lookup("__ZERO")
+	a, b, _, _ := p.operands(at)
+	return ok1 && ok2 && a == one && b == zero
+}
+
+// sizes of the SUBLEQ blocks, in instructions
+const (
+	blockCompute      = 9 // computes and stores a - b
+	blockBranch       = 3 // branches if a - b != 0
+	blockAlwaysBranch = 1 // branches
+)
+
+// SBNZToSUBLEQ translates a SBNZ program into a SUBLEQ program.
+// Returns the translated program and symbol table.
+//
+// Each SBNZ a, b, c, d instruction is translated into a block that
+// computes T = a - b using a temporary T and a word Z that is always
+// zero, copies T into c and then branches to the block of d if T is
+// positive or negative (T + 1 <= 0):
+//
+//	T T; a Z; Z T; Z Z; b T      ; T = a - b
+//	c c; T Z; Z c; Z Z           ; c = T
+//	Z T L1; Z Z d                ; T > 0
+//	L1: M1 T d                   ; T < 0, M1 = -1
+//
+// The temporaries follow the data.
+func SBNZToSUBLEQ(image []uint8, symbols vm.SymbolTable, config vm.Config) ([]uint8, vm.SymbolTable, error) {
+	p, err := analyze(image, symbols, config, sbnzDecoder)
+	if err != nil {
+		return nil, nil, err
+	}
+	n := config.Bytes()
+	subleqWidth := subleqDecoder.words * n
+
+	// layout: blocks, data and temporaries
+	kinds := make(map[Address]int, len(p.order))
+	blocks := make(map[Address]Address, len(p.order))
+	ip := Address(0)
+	for _, at := range p.order {
+		a, b, _, d := p.operands(at)
+		size := blockCompute
+		switch {
+		case sbnzAlwaysBranches(p, at):
+			size += blockAlwaysBranch
+		case a != b && d != at+p.width:
+			size += blockBranch
+		}
+		blocks[at] = ip
+		kinds[at] = size
+		ip += Address(size) * subleqWidth
+	}
+	bases := make([]Address, len(p.data))
+	for i, s := range p.data {
+		bases[i] = ip
+		ip += s.to - s.from
+	}
+	Z, T, M1 := ip, ip+n, ip+2*n
+	size := int(ip + 3*n)
+	if err := checkSize(p, size, func(at Address) []Address { return sbnzDecoder.operands(p, at) }); err != nil {
+		return nil, nil, err
+	}
+
+	data := func(a Address) (Address, error) {
+		if int(a) >= len(image) {
+			return a, nil
+		}
+		for i, s := range p.data {
+			if a >= s.from && a < s.to {
+				return bases[i] + a - s.from, nil
+			}
+		}
+		return 0, fmt.Errorf("%04x is not data", a)
+	}
+	target := func(a Address) Address {
+		if p.halt(a) {
+			return a
+		}
+		return blocks[a]
+	}
+
+	res := make([]uint8, size)
+	put := func(p Address, a Address) {
+		config.PutWord(res[p:], a)
+	}
+	for _, at := range p.order {
+		ip := blocks[at]
+		emit := func(a, b, c Address) {
+			put(ip, a)
+			put(ip+n, b)
+			put(ip+2*n, c)
+			ip += subleqWidth
+		}
+		next := func() Address { return ip + subleqWidth }
+		var ops [3]Address
+		for i, o := range sbnzDecoder.operands(p, at) {
+			if ops[i], err = data(o); err != nil {
+				return nil, nil, fmt.Errorf("instruction at %04x: %s", at, err)
+			}
+		}
+		a, b, c := ops[0], ops[1], ops[2]
+		_, _, _, d := p.operands(at)
+		d = target(d)
+		emit(T, T, next())
+		emit(a, Z, next())
+		emit(Z, T, next())
+		emit(Z, Z, next())
+		emit(b, T, next())
+		emit(c, c, next())
+		emit(T, Z, next())
+		emit(Z, c, next())
+		emit(Z, Z, next())
+		switch kinds[at] {
+		case blockCompute + blockAlwaysBranch:
+			emit(Z, Z, d)
+		case blockCompute + blockBranch:
+			emit(Z, T, ip+2*subleqWidth)
+			emit(Z, Z, d)
+			emit(M1, T, d)
+		}
+	}
+	for i, s := range p.data {
+		copy(res[bases[i]:], image[s.from:s.to])
+	}
+	put(M1, config.MaxAddress())
+
+	translated := make(map[string]vm.Address)
+	for _, s := range symbols {
+		if a, ok := blocks[s.Address]; ok {
+			translated[s.Name] = a
+		} else if int(s.Address) >= len(image) || p.isData(s.Address) {
+			translated[s.Name], _ = data(s.Address)
+		}
+	}
+	translated["__subleq_Z"] = Z
+	translated["__subleq_T"] = T
+	translated["__subleq_M1"] = M1
+	return res, vm.NewSymbolTable(translated), nil
+}
@@ -0,0 +1,120 @@
+package translate
+
+import (
+	"fmt"
+	"gosics/assembler"
+	"gosics/vm"
+)
+
+// subleqDecoder SUBLEQ a, b, c. The instructions with a == b always
+// branch.
+var subleqDecoder = decoder{
+	words: 3,
+	next: func(p *program, at Address) []Address {
+		a, b, c, _ := p.operands(at)
+		var res []Address
+		if a != b {
+			res = append(res, at+p.width)
+		}
+		if !p.halt(c) {
+			res = append(res, c)
+		}
+		return res
+	},
+	operands: func(p *program, at Address) []Address {
+		a, b, _, _ := p.operands(at)
+		return []Address{a, b}
+	},
+}
+
+// SUBLEQToSBNZ translates a SUBLEQ program into a SBNZ program.
+// Returns the translated program and symbol table.
+//
+// Each instruction is translated into the assembler's SUBLEQ macro
+// instruction, or a cheaper equivalent when possible, so the program
+// gets the usual preamble. The data is placed after the code.
+func SUBLEQToSBNZ(image []uint8, symbols vm.SymbolTable, config vm.Config) ([]uint8, vm.SymbolTable, error) {
+	p, err := analyze(image, symbols, config, subleqDecoder)
+	if err != nil {
+		return nil, nil, err
+	}
+	code := func(a Address) assembler.Label {
+		return assembler.Label(fmt.Sprintf("L%04x", a))
+	}
+	dataLabel := func(a Address) assembler.Label {
+		return assembler.Label(fmt.Sprintf("D%04x", a))
+	}
+	labeled := make(map[Address]bool)
+	data := func(a Address) assembler.Labeler {
+		if int(a) >= len(image) {
+			return assembler.Address(a)
+		}
+		labeled[a] = true
+		return dataLabel(a)
+	}
+	target := func(a Address) assembler.Labeler {
+		if p.halt(a) {
+			return assembler.HLT
+		}
+		return code(a)
+	}
+
+	as := assembler.New(assembler.WithConfig(config))
+	for _, at := range p.order {
+		as.Label(code(at))
+		pa, pb, c, _ := p.operands(at)
+		a, b := data(pa), data(pb)
+		switch {
+		case pa == pb:
+			as.MOV(assembler.ZERO, b)
+			as.JMP(target(c))
+		case c == at+p.width:
+			as.SUB(b, a, b)
+		default:
+			as.SUBLEQ(a, b, target(c))
+		}
+	}
+	for _, s := range symbols {
+		if p.isData(s.Address) {
+			labeled[s.Address] = true
+		}
+	}
+	for _, s := range p.data {
+		for i := s.from; i < s.to; {
+			as.Label(dataLabel(i))
+			j := i + 1
+			for j < s.to && !labeled[j] {
+				j++
+			}
+			as.DB(image[i:j]...)
+			i = j
+		}
+	}
+	res := as.Assemble()
+	if err := as.Err(); err != nil {
+		return nil, nil, err
+	}
+	if err := checkSize(p, len(res), func(at Address) []Address { return subleqDecoder.operands(p, at) }); err != nil {
+		return nil, nil, err
+	}
+
+	assembled := as.Symbols()
+	translated := make(map[string]vm.Address)
+	for _, s := range symbols {
+		var name assembler.Label
+		switch {
+		case p.code[s.Address]:
+			name = code(s.Address)
+		case p.isData(s.Address):
+			name = dataLabel(s.Address)
+		case int(s.Address) >= len(image):
+			translated[s.Name] = s.Address
+			continue
+		default:
+			continue
+		}
+		a, _ := assembled.Lookup(string(name))
+		translated[s.Name] = a
+	}
+	return res, vm.NewSymbolTable(translated), nil
+}
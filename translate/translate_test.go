@@ -0,0 +1,175 @@
+package translate
+
+import (
+	"gosics/assembler"
+	"gosics/internal/example"
+	"gosics/vm"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// t_run run the program on the instruction set until halted
+func t_run(image []uint8, isa vm.ISA) vm.Computer {
+	return t_runWithConfig(image, isa, vm.DefaultConfig)
+}
+
+// t_runWithConfig run the program on the instruction set, with the
+// configuration, until halted
+func t_runWithConfig(image []uint8, isa vm.ISA, config vm.Config) vm.Computer {
+	c := vm.Computer{}
+	c.SetConfig(config)
+	c.SetISA(isa)
+	c.LoadMemory(image)
+	for i := 0; i < 100000 && !c.Halted(); i++ {
+		c.Step()
+	}
+	return c
+}
+
+// t_referenceSUBLEQ a straightforward SUBLEQ interpreter, independent
+// of the vm package, used to check the translations. Returns the
+// memory after running the program.
+func t_referenceSUBLEQ(image []uint8) []uint8 {
+	mem := make([]uint8, vm.MemorySize)
+	copy(mem, image)
+	word := func(p int) int { return int(mem[p])<<8 | int(mem[p+1]) }
+	ip := 0
+	for i := 0; i < 100000 && ip != int(vm.HALT); i++ {
+		a, b, c := word(ip), word(ip+2), word(ip+4)
+		r := int16(word(b)) - int16(word(a))
+		mem[b], mem[b+1] = uint8(uint16(r)>>8), uint8(r)
+		if r <= 0 {
+			ip = c
+		} else {
+			ip += 6
+		}
+	}
+	return mem
+}
+
+// t_assertEquivalent assert that, after running both programs, the
+// data at the addresses pointed by the names is the same
+func t_assertEquivalent(t *testing.T, c1 vm.Computer, s1 vm.SymbolTable, c2 vm.Computer, s2 vm.SymbolTable, names ...string) {
+	assert.True(t, c1.Halted())
+	assert.True(t, c2.Halted())
+	assert.Nil(t, c2.Fault())
+	for _, name := range names {
+		a1, ok1 := s1.Lookup(name)
+		a2, ok2 := s2.Lookup(name)
+		assert.True(t, ok1 && ok2, name)
+		assert.Equal(t, c1.Peek(a1), c2.Peek(a2), name)
+	}
+}
+
+// t_subleqSum adds X to RES, CNT times
+//
+//	LOOP: X Z; Z RES; Z Z; ONE CNT HALT; Z Z LOOP
+func t_subleqSum() ([]uint8, vm.SymbolTable) {
+	image := []uint8{
+		0x00, 0x1e, 0x00, 0x20, 0x00, 0x06,
+		0x00, 0x20, 0x00, 0x22, 0x00, 0x0c,
+		0x00, 0x20, 0x00, 0x20, 0x00, 0x12,
+		0x00, 0x24, 0x00, 0x26, 0xff, 0xff,
+		0x00, 0x20, 0x00, 0x20, 0x00, 0x00,
+		0x00, 0x03, // X
+		0x00, 0x00, // Z
+		0x00, 0x00, // RES
+		0x00, 0x01, // ONE
+		0x00, 0x04, // CNT
+	}
+	symbols := vm.NewSymbolTable(map[string]vm.Address{
+		"LOOP": 0x00, "X": 0x1e, "Z": 0x20, "RES": 0x22, "ONE": 0x24, "CNT": 0x26,
+	})
+	return image, symbols
+}
+
+func TestSBNZToSUBLEQ(t *testing.T) {
+	as := example.Multiply()
+	image := as.Assemble()
+	symbols := as.Symbols()
+
+	translated, tsymbols, err := SBNZToSUBLEQ(image, symbols, vm.DefaultConfig)
+	assert.Nil(t, err)
+
+	c1 := t_run(image, vm.SBNZ)
+	c2 := t_run(translated, vm.SUBLEQ)
+	t_assertEquivalent(t, c1, symbols, c2, tsymbols, "OP1", "OP2", "DST", "CNT", "__JUNK")
+	a, _ := tsymbols.Lookup("DST")
+	assert.Equal(t, vm.Operand(6), c2.Peek(a))
+	assert.Equal(t, []uint8{0, 6}, t_referenceSUBLEQ(translated)[a:a+2])
+}
+
+func TestSUBLEQToSBNZ(t *testing.T) {
+	image, symbols := t_subleqSum()
+
+	translated, tsymbols, err := SUBLEQToSBNZ(image, symbols, vm.DefaultConfig)
+	assert.Nil(t, err)
+
+	c1 := t_run(image, vm.SUBLEQ)
+	c2 := t_run(translated, vm.SBNZ)
+	assert.Equal(t, vm.Operand(12), c1.Peek(0x22))
+	assert.Equal(t, []uint8{0, 12}, t_referenceSUBLEQ(image)[0x22:0x24])
+	t_assertEquivalent(t, c1, symbols, c2, tsymbols, "X", "Z", "RES", "ONE", "CNT")
+	// the preamble jumps to the first instruction
+	a, _ := tsymbols.Lookup("LOOP")
+	assert.Equal(t, []uint8{uint8(a >> 8), uint8(a)}, translated[6:8])
+}
+
+func TestRoundTrip(t *testing.T) {
+	as := example.Multiply()
+	image := as.Assemble()
+	symbols := as.Symbols()
+
+	subleq, ssymbols, err := SBNZToSUBLEQ(image, symbols, vm.DefaultConfig)
+	assert.Nil(t, err)
+	sbnz, tsymbols, err := SUBLEQToSBNZ(subleq, ssymbols, vm.DefaultConfig)
+	assert.Nil(t, err)
+
+	c1 := t_run(image, vm.SBNZ)
+	c2 := t_run(sbnz, vm.SBNZ)
+	t_assertEquivalent(t, c1, symbols, c2, tsymbols, "OP1", "OP2", "DST", "CNT")
+}
+
+func TestSelfModifyingCodeCantBeTranslated(t *testing.T) {
+	as := assembler.New()
+	as.PUSH(assembler.ONE)
+	as.HLT()
+
+	_, _, err := SBNZToSUBLEQ(as.Assemble(), as.Symbols(), vm.DefaultConfig)
+	assert.NotNil(t, err)
+}
+
+func TestJumpOutsideTheProgram(t *testing.T) {
+	image := []uint8{0x00, 0x06, 0x00, 0x06, 0x10, 0x00, 0x00, 0x00}
+	_, _, err := SUBLEQToSBNZ(image, nil, vm.DefaultConfig)
+	assert.NotNil(t, err)
+	assert.Equal(t, "jump outside the program at 1000", err.Error())
+}
+
+func TestRoundTripWithConfig(t *testing.T) {
+	for _, config := range []vm.Config{{WordSize: 24}, {WordSize: 32, Order: vm.LittleEndian}} {
+		as := example.Multiply(assembler.WithConfig(config))
+		image := as.Assemble()
+		symbols := as.Symbols()
+
+		subleq, ssymbols, err := SBNZToSUBLEQ(image, symbols, config)
+		assert.Nil(t, err)
+		sbnz, tsymbols, err := SUBLEQToSBNZ(subleq, ssymbols, config)
+		assert.Nil(t, err)
+
+		c1 := t_runWithConfig(image, vm.SBNZ, config)
+		c2 := t_runWithConfig(subleq, vm.SUBLEQ, config)
+		c3 := t_runWithConfig(sbnz, vm.SBNZ, config)
+		t_assertEquivalent(t, c1, symbols, c2, ssymbols, "OP1", "OP2", "DST", "CNT")
+		t_assertEquivalent(t, c1, symbols, c3, tsymbols, "OP1", "OP2", "DST", "CNT")
+		a, _ := tsymbols.Lookup("DST")
+		assert.Equal(t, vm.Operand(6), c3.Peek(a), "%v", config)
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	_, _, err := SBNZToSUBLEQ(nil, nil, vm.Config{WordSize: 12})
+	assert.NotNil(t, err)
+	assert.Equal(t, "unsupported word size 12", err.Error())
+}
@@ -0,0 +1,133 @@
+package vm
+
+import "fmt"
+
+// Banks describes a bank switched memory. The addresses in the window
+// are mapped to the selected bank, writing the number of a bank in
+// the bank select register switches banks. Bank 0 is the memory
+// behind the window, the other banks are stored past the end of the
+// address space, so programs can use more memory than the word size
+// allows.
+type Banks struct {
+	// Window first address of the switchable window
+	Window Address
+	// Size of the window, and of each bank, in bytes
+	Size Address
+	// Count number of banks, including bank 0
+	Count int
+	// Select address of the bank select register, a word outside the
+	// window
+	Select Address
+}
+
+// contains return true if the address is in the window
+func (self Banks) contains(p Address) bool {
+	return p >= self.Window && p-self.Window < self.Size
+}
+
+// Validate return an error if the banks don't fit the configuration
+func (self Banks) Validate(config Config) error {
+	size := config.MemorySize()
+	switch {
+	case self.Count < 2 || self.Size == 0:
+		return fmt.Errorf("at least two banks required")
+	case uint64(self.Window)+uint64(self.Size) > size:
+		return fmt.Errorf("window outside the memory")
+	case uint64(self.Select)+uint64(config.Bytes()) > size ||
+		self.contains(self.Select) || self.contains(self.Select+config.Bytes()-1):
+		return fmt.Errorf("bank select register inside the window")
+	case size+uint64(self.Count-1)*uint64(self.Size) > 1<<32:
+		return fmt.Errorf("banks too big")
+	}
+	return nil
+}
+
+// SetBanks enables the bank switched memory. The bank select register
+// is cleared, bank 0 is selected. Must be called after SetConfig.
+func (self *Computer) SetBanks(banks Banks) error {
+	if err := banks.Validate(self.config); err != nil {
+		return err
+	}
+	self.banks = &banks
+	self.bank = 0
+	self.words = nil
+	self.putOperand(banks.Select, 0)
+	return nil
+}
+
+// Bank return the selected bank
+func (self *Computer) Bank() int {
+	return self.bank
+}
+
+// LoadBank loads data into the bank, at the start of the window
+func (self *Computer) LoadBank(bank int, data []uint8) error {
+	if self.banks == nil || bank < 0 || bank >= self.banks.Count {
+		return fmt.Errorf("invalid bank %d", bank)
+	}
+	if Address(len(data)) > self.banks.Size {
+		return fmt.Errorf("data too big for bank %d", bank)
+	}
+	for i, c := range data {
+		self.memory.set(self.bankAddress(bank, self.banks.Window+Address(i)), c)
+	}
+	return nil
+}
+
+// bankAddress return where the address of the window is stored for
+// the bank
+func (self *Computer) bankAddress(bank int, p Address) Address {
+	if bank == 0 {
+		return p
+	}
+	base := self.config.MaxAddress() + 1 + Address(bank-1)*self.banks.Size
+	return base + p - self.banks.Window
+}
+
+// get return the byte at address p, of the selected bank if p is in
+// the window
+func (self *Computer) get(p Address) uint8 {
+	p &= self.config.MaxAddress()
+	if self.bank != 0 && self.banks.contains(p) {
+		p = self.bankAddress(self.bank, p)
+	}
+	return self.memory.get(p)
+}
+
+// set stores the byte v at address p, of the selected bank if p is in
+// the window
+func (self *Computer) set(p Address, v uint8) {
+	p &= self.config.MaxAddress()
+	if self.icache != nil {
+		self.icache.written(p)
+	}
+	if self.bank != 0 && self.banks.contains(p) {
+		p = self.bankAddress(self.bank, p)
+	}
+	self.memory.set(p, v)
+}
+
+// checkBank return true if writing o at p selects a valid bank, or
+// doesn't touch the bank select register. If not sets the fault.
+func (self *Computer) checkBank(p Address, o Operand) bool {
+	if self.banks == nil || p != self.banks.Select {
+		return true
+	}
+	if w := Address(o) & self.config.MaxAddress(); w >= Address(self.banks.Count) {
+		self.fault = &Fault{IP: self.ip, Address: p, Reason: "invalid bank"}
+		return false
+	}
+	return true
+}
+
+// selectBank updates the selected bank after writing the bank select
+// register. Sets the fault if the register doesn't hold a valid bank
+// number.
+func (self *Computer) selectBank() {
+	bank := self.fetchAddress(self.banks.Select)
+	if bank >= Address(self.banks.Count) {
+		self.fault = &Fault{IP: self.ip, Address: self.banks.Select, Reason: "invalid bank"}
+		return
+	}
+	self.bank = int(bank)
+}
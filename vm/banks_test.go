@@ -0,0 +1,85 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// t_banks 4 banks of 256 bytes at 0x1000, the register at 0x0F00
+var t_banks = Banks{Window: 0x1000, Size: 0x100, Count: 4, Select: 0x0F00}
+
+func TestSetBanksValidates(t *testing.T) {
+	c := Computer{}
+	assert.NotNil(t, c.SetBanks(Banks{Window: 0x1000, Size: 0x100, Count: 1}))
+	assert.NotNil(t, c.SetBanks(Banks{Window: 0xFF00, Size: 0x200, Count: 2}))
+	assert.NotNil(t, c.SetBanks(Banks{Window: 0x1000, Size: 0x100, Count: 2, Select: 0x1010}))
+	assert.NotNil(t, c.SetBanks(Banks{Window: 0x1000, Size: 0x100, Count: 2, Select: 0x0FFF}))
+	c.SetConfig(Config{WordSize: 32})
+	assert.NotNil(t, c.SetBanks(Banks{Window: 0x1000, Size: 0x100, Count: 2}))
+	c.SetConfig(DefaultConfig)
+	assert.Nil(t, c.SetBanks(t_banks))
+}
+
+func TestBankSwitching(t *testing.T) {
+	c := Computer{}
+	assert.Nil(t, c.SetBanks(t_banks))
+	c.LoadMemory([]uint8{
+		0x00, 0x10, // a
+		0x00, 0x12, // b
+		0x0F, 0x00, // c, the bank select register
+		0x00, 0x08, // d
+		// select bank 2, then copy the window to 0x0020
+		0x10, 0x00, // a
+		0x00, 0x12, // b
+		0x00, 0x20, // c
+		0xFF, 0xFF, // d
+		0x00, 0x03, // *a
+		0x00, 0x01, // *b
+	})
+	c.putOperand(0x1000, 0x1234)
+	assert.Nil(t, c.LoadBank(2, []uint8{0x56, 0x78}))
+
+	assert.Equal(t, Operand(0x1234), c.Peek(0x1000))
+	c.Step()
+	assert.Equal(t, 2, c.Bank())
+	assert.Equal(t, Operand(0x5678), c.Peek(0x1000))
+	c.Step()
+	assert.Equal(t, Operand(0x5677), c.Peek(0x0020))
+
+	// bank 0 is the memory behind the window
+	c.putOperand(0x0F00, 0)
+	c.selectBank()
+	assert.Equal(t, Operand(0x1234), c.Peek(0x1000))
+}
+
+func TestInvalidBank(t *testing.T) {
+	c := Computer{}
+	assert.Nil(t, c.SetBanks(t_banks))
+	c.LoadMemory([]uint8{
+		0x00, 0x08, // a
+		0x00, 0x0A, // b
+		0x0F, 0x00, // c, the bank select register
+		0x00, 0x08, // d
+		0x00, 0x07, // *a
+		0x00, 0x01, // *b
+	})
+	c.Step()
+	assert.True(t, c.Halted())
+	assert.Equal(t, &Fault{IP: 0, Address: 0x0F00, Reason: "invalid bank"}, c.Fault())
+	assert.Equal(t, 0, c.Bank())
+	assert.NotNil(t, c.LoadBank(4, nil))
+	assert.NotNil(t, c.LoadBank(1, make([]uint8, 0x101)))
+}
+
+func TestBanksBeyondTheAddressSpace(t *testing.T) {
+	c := Computer{}
+	assert.Nil(t, c.SetBanks(Banks{Window: 0x8000, Size: 0x8000, Count: 8, Select: 0x0100}))
+	for bank := 1; bank < 8; bank++ {
+		assert.Nil(t, c.LoadBank(bank, []uint8{0x00, uint8(bank)}))
+	}
+	for bank := 7; bank > 0; bank-- {
+		c.write(0x0100, Operand(bank))
+		assert.Equal(t, Operand(bank), c.Peek(0x8000))
+	}
+}
@@ -0,0 +1,140 @@
+package vm
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// DefaultMaxSteps the step limit of the runs of a batch, unless set
+const DefaultMaxSteps = 1000000
+
+// Batch runs a program many times with different inputs, in parallel.
+// Each run uses its own computer: Setup prepares it, the program is
+// loaded, the inputs written and the computer stepped until halted or
+// MaxSteps steps.
+type Batch struct {
+	// Program the memory image
+	Program []uint8
+	// Symbols resolves the labels of the inputs and the outputs
+	Symbols SymbolTable
+	// Outputs the labels of the words reported after each run
+	Outputs []string
+	// MaxSteps the step limit of each run, DefaultMaxSteps if 0
+	MaxSteps int
+	// Workers the number of goroutines, GOMAXPROCS if 0
+	Workers int
+	// Setup prepares the computer before loading the program
+	// (configuration, instruction set...), optional
+	Setup func(c *Computer)
+}
+
+// Input the words written before a run, by label
+type Input map[string]Operand
+
+// Stop why a run stopped
+type Stop int
+
+const (
+	Halted Stop = iota
+	Faulted
+	StepLimit
+)
+
+func (self Stop) String() string {
+	switch self {
+	case Halted:
+		return "halted"
+	case Faulted:
+		return "faulted"
+	}
+	return "step limit"
+}
+
+// Result the outcome of a run
+type Result struct {
+	// Outputs the words of Batch.Outputs, by label
+	Outputs map[string]Operand
+	Steps   int
+	Stop    Stop
+	Fault   error // the fault, if faulted
+}
+
+// Run runs the program once for each input and returns the results,
+// in the order of the inputs. Fails if a label is not defined.
+func (self *Batch) Run(inputs []Input) ([]Result, error) {
+	if err := self.checkLabels(inputs); err != nil {
+		return nil, err
+	}
+	workers := self.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	results := make([]Result, len(inputs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = self.run(inputs[i])
+			}
+		}()
+	}
+	for i := range inputs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results, nil
+}
+
+// checkLabels fails if a label of the inputs or the outputs is not
+// defined
+func (self *Batch) checkLabels(inputs []Input) error {
+	labels := append([]string{}, self.Outputs...)
+	for _, in := range inputs {
+		for l := range in {
+			labels = append(labels, l)
+		}
+	}
+	for _, l := range labels {
+		if _, ok := self.Symbols.Lookup(l); !ok {
+			return fmt.Errorf("undefined label %s", l)
+		}
+	}
+	return nil
+}
+
+// run runs the program with the input
+func (self *Batch) run(in Input) Result {
+	c := Computer{}
+	if self.Setup != nil {
+		self.Setup(&c)
+	}
+	c.LoadMemory(self.Program)
+	for l, o := range in {
+		p, _ := self.Symbols.Lookup(l)
+		c.putOperand(p, o)
+	}
+	max := self.MaxSteps
+	if max <= 0 {
+		max = DefaultMaxSteps
+	}
+	res := Result{Outputs: make(map[string]Operand, len(self.Outputs))}
+	res.Steps = c.Run(max)
+	switch {
+	case c.Fault() != nil:
+		res.Stop, res.Fault = Faulted, c.Fault()
+	case c.Halted():
+		res.Stop = Halted
+	default:
+		res.Stop = StepLimit
+	}
+	for _, l := range self.Outputs {
+		p, _ := self.Symbols.Lookup(l)
+		res.Outputs[l] = c.Peek(p)
+	}
+	return res
+}
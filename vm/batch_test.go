@@ -0,0 +1,64 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// t_batch C = A - B, looping forever if the result is 1
+var t_batch = Batch{
+	Program: []uint8{
+		0x00, 0x18, 0x00, 0x1A, 0x00, 0x1C, 0x00, 0x08, // SBNZ A, B, C, 0x08
+		0x00, 0x1C, 0x00, 0x1E, 0x00, 0x22, 0xFF, 0xFF, // SBNZ C, ONE, JUNK, HALT
+		0x00, 0x1E, 0x00, 0x20, 0x00, 0x22, 0x00, 0x10, // SBNZ ONE, ZERO, JUNK, 0x10
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00,
+	},
+	Symbols: NewSymbolTable(map[string]Address{"A": 0x18, "B": 0x1A, "C": 0x1C, "ONE": 0x1E}),
+	Outputs: []string{"C"},
+	Workers: 4,
+}
+
+func TestBatchRun(t *testing.T) {
+	var inputs []Input
+	for i := 0; i < 100; i++ {
+		inputs = append(inputs, Input{"A": Operand(2 * i), "B": Operand(i)})
+	}
+	inputs[1] = Input{"A": 5, "B": 4}
+	results, err := t_batch.Run(inputs)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(results))
+	for i, r := range results {
+		if i == 1 {
+			continue
+		}
+		assert.Equal(t, Halted, r.Stop)
+		assert.Equal(t, map[string]Operand{"C": Operand(i)}, r.Outputs)
+	}
+	assert.Equal(t, Result{Outputs: map[string]Operand{"C": 1}, Steps: DefaultMaxSteps, Stop: StepLimit}, results[1])
+}
+
+func TestBatchStepLimitAndFaults(t *testing.T) {
+	b := t_batch
+	b.MaxSteps = 10
+	results, err := b.Run([]Input{{"A": 1}})
+	assert.Nil(t, err)
+	assert.Equal(t, StepLimit, results[0].Stop)
+	assert.Equal(t, 10, results[0].Steps)
+
+	b.Setup = func(c *Computer) {
+		c.Guard(0x1C, 0x1C)
+	}
+	results, err = b.Run([]Input{{"A": 3}})
+	assert.Nil(t, err)
+	assert.Equal(t, Faulted, results[0].Stop)
+	assert.Equal(t, 1, results[0].Steps)
+	assert.NotNil(t, results[0].Fault)
+	assert.Equal(t, "faulted", results[0].Stop.String())
+}
+
+func TestBatchUndefinedLabel(t *testing.T) {
+	_, err := t_batch.Run([]Input{{"X": 1}})
+	assert.NotNil(t, err)
+	assert.Equal(t, "undefined label X", err.Error())
+}
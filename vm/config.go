@@ -0,0 +1,119 @@
+package vm
+
+import "fmt"
+
+// ByteOrder order of the bytes of a word in memory
+type ByteOrder int
+
+const (
+	// BigEndian the most significant byte first, the default
+	BigEndian ByteOrder = iota
+	// LittleEndian the least significant byte first
+	LittleEndian
+)
+
+// Config describes the words of the computer. Addresses and operands
+// are words, so the word size determines the size of the memory and
+// the range of the operands. The zero value is the default
+// configuration, 16 bits big endian words.
+type Config struct {
+	// WordSize bits per word: 8, 16, 24 or 32
+	WordSize uint `json:"word_size"`
+	// Order byte order of the words in memory
+	Order ByteOrder `json:"order"`
+}
+
+// DefaultConfig 16 bits big endian words, 64K of memory
+var DefaultConfig = Config{WordSize: 16, Order: BigEndian}
+
+// Validate return an error if the configuration is not supported
+func (self Config) Validate() error {
+	switch self.WordSize {
+	case 0, 8, 16, 24, 32:
+	default:
+		return fmt.Errorf("unsupported word size %d", self.WordSize)
+	}
+	if self.Order != BigEndian && self.Order != LittleEndian {
+		return fmt.Errorf("unsupported byte order %d", self.Order)
+	}
+	return nil
+}
+
+// Equal return true if both configurations describe the same words
+func (self Config) Equal(other Config) bool {
+	return self.bits() == other.bits() && self.Order == other.Order
+}
+
+// bits return the bits per word
+func (self Config) bits() uint {
+	if self.WordSize == 0 {
+		return DefaultConfig.WordSize
+	}
+	return self.WordSize
+}
+
+// Bytes return the bytes per word
+func (self Config) Bytes() Address {
+	return Address(self.bits() / 8)
+}
+
+// MaxAddress return the highest address, jumping to it halts the
+// computer
+func (self Config) MaxAddress() Address {
+	return Address(uint64(1)<<self.bits() - 1)
+}
+
+// MemorySize return the size of the memory, in bytes
+func (self Config) MemorySize() uint64 {
+	return uint64(self.MaxAddress()) + 1
+}
+
+// Word decodes the word stored in b
+func (self Config) Word(b []uint8) Address {
+	res := Address(0)
+	n := int(self.Bytes())
+	for i := 0; i < n; i++ {
+		if self.Order == LittleEndian {
+			res = res<<8 | Address(b[n-1-i])
+		} else {
+			res = res<<8 | Address(b[i])
+		}
+	}
+	return res
+}
+
+// WordAt return the word at p of the image, 0 if it doesn't fit in
+// the image
+func (self Config) WordAt(image []uint8, p Address) Address {
+	n := self.Bytes()
+	if uint64(p)+uint64(n) > uint64(len(image)) {
+		return 0
+	}
+	return self.Word(image[p : p+n])
+}
+
+// PutWord encodes w into b, only the lower bits fitting in a word are
+// stored
+func (self Config) PutWord(b []uint8, w Address) {
+	n := int(self.Bytes())
+	for i := n - 1; i >= 0; i-- {
+		if self.Order == LittleEndian {
+			b[n-1-i] = uint8(w)
+		} else {
+			b[i] = uint8(w)
+		}
+		w >>= 8
+	}
+}
+
+// Signed return the word as a signed operand
+func (self Config) Signed(w Address) Operand {
+	shift := 32 - self.bits()
+	return Operand(w<<shift) >> shift
+}
+
+// Wrap truncates the operand to the word size, as if stored in memory
+// and read back
+func (self Config) Wrap(o Operand) Operand {
+	return self.Signed(Address(o) & self.MaxAddress())
+}
@@ -0,0 +1,113 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigValidate(t *testing.T) {
+	assert.Nil(t, Config{}.Validate())
+	assert.Nil(t, Config{WordSize: 24, Order: LittleEndian}.Validate())
+	assert.NotNil(t, Config{WordSize: 12}.Validate())
+	assert.NotNil(t, Config{WordSize: 16, Order: 2}.Validate())
+}
+
+func TestConfigDefaults(t *testing.T) {
+	assert.Equal(t, Address(2), Config{}.Bytes())
+	assert.Equal(t, MaxAddress, Config{}.MaxAddress())
+	assert.Equal(t, uint64(MemorySize), DefaultConfig.MemorySize())
+	assert.Equal(t, Address(0xFFFFFFFF), Config{WordSize: 32}.MaxAddress())
+	assert.Equal(t, Address(0xFF), Config{WordSize: 8}.MaxAddress())
+}
+
+func TestConfigWord(t *testing.T) {
+	data := []struct {
+		config Config
+		bytes  []uint8
+	}{
+		{Config{WordSize: 8}, []uint8{0x56}},
+		{Config{WordSize: 16}, []uint8{0x34, 0x56}},
+		{Config{WordSize: 16, Order: LittleEndian}, []uint8{0x56, 0x34}},
+		{Config{WordSize: 24}, []uint8{0x12, 0x34, 0x56}},
+		{Config{WordSize: 32, Order: LittleEndian}, []uint8{0x56, 0x34, 0x12, 0x00}},
+	}
+	for _, d := range data {
+		w := Address(0x123456) & d.config.MaxAddress()
+		b := make([]uint8, len(d.bytes))
+		d.config.PutWord(b, 0x123456)
+		assert.Equal(t, d.bytes, b, d.config)
+		assert.Equal(t, w, d.config.Word(d.bytes), d.config)
+		assert.Equal(t, w, d.config.WordAt(append([]uint8{0}, d.bytes...), 1), d.config)
+		assert.Equal(t, Address(0), d.config.WordAt(d.bytes, 1), d.config)
+	}
+	assert.Equal(t, Address(0), DefaultConfig.WordAt([]uint8{1, 2}, MaxAddress))
+}
+
+func TestConfigSigned(t *testing.T) {
+	assert.Equal(t, Operand(-1), Config{WordSize: 8}.Signed(0xFF))
+	assert.Equal(t, Operand(127), Config{WordSize: 8}.Signed(0x7F))
+	assert.Equal(t, Operand(-2), Config{WordSize: 24}.Signed(0xFFFFFE))
+	assert.Equal(t, Operand(-1), Config{WordSize: 32}.Signed(0xFFFFFFFF))
+	assert.Equal(t, Operand(0x7FFF), Config{}.Wrap(-0x8001))
+	assert.Equal(t, Operand(-0x80), Config{WordSize: 8}.Wrap(0x80))
+}
+
+func TestStepLittleEndian(t *testing.T) {
+	c := Computer{}
+	assert.Nil(t, c.SetConfig(Config{WordSize: 32, Order: LittleEndian}))
+	c.LoadMemory([]uint8{
+		0x10, 0x00, 0x00, 0x00, // a
+		0x14, 0x00, 0x00, 0x00, // b
+		0x18, 0x00, 0x00, 0x00, // c
+		0x00, 0x00, 0x02, 0x00, // d
+		0x05, 0x00, 0x00, 0x00, // *a
+		0x07, 0x00, 0x00, 0x00, // *b
+	})
+	c.Step()
+	assert.Equal(t, Operand(-2), c.Peek(0x18))
+	assert.Equal(t, uint8(0xFE), c.memory.get(0x18))
+	assert.Equal(t, Address(0x20000), c.IP())
+}
+
+func TestProgramLargerThan64K(t *testing.T) {
+	c := Computer{}
+	assert.Nil(t, c.SetConfig(Config{WordSize: 24}))
+	program := make([]uint8, 0x20012)
+	copy(program, []uint8{
+		0x02, 0x00, 0x0C, // a
+		0x02, 0x00, 0x0F, // b
+		0x02, 0x00, 0x0C, // c
+		0x02, 0x00, 0x00, // d
+	})
+	copy(program[0x20000:], []uint8{
+		0x02, 0x00, 0x0C, // a
+		0x02, 0x00, 0x0F, // b
+		0x00, 0x00, 0x0C, // c
+		0xFF, 0xFF, 0xFF, // d
+		0x00, 0x00, 0x05, // *a
+		0x00, 0x00, 0x01, // *b
+	})
+	c.LoadMemory(program)
+	c.Step()
+	assert.Equal(t, Operand(4), c.Peek(0x2000C))
+	assert.Equal(t, Address(0x20000), c.IP())
+	c.Step()
+	assert.True(t, c.Halted())
+	assert.Equal(t, Operand(3), c.Peek(0x0C))
+}
+
+func TestOperandsWrapAround(t *testing.T) {
+	c := Computer{}
+	assert.Nil(t, c.SetConfig(Config{WordSize: 8}))
+	c.LoadMemory([]uint8{
+		0x06, 0x07, 0xFF, // a, b, c
+		0x00, 0x00, 0x00, // padding
+		0x80, 0x01, // *a, *b
+	})
+	c.SetISA(SUBLEQ)
+	c.Step()
+	// 1 - -128 overflows
+	assert.Equal(t, Operand(-127), c.Peek(0x07))
+	assert.True(t, c.Halted())
+}
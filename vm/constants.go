@@ -0,0 +1,31 @@
+package vm
+
+// constant an address whose operand must not change
+type constant struct {
+	address Address
+	value   Operand
+}
+
+// WatchConstants traps the changes to the operands at addrs: an
+// instruction changing one of them from its value when
+// WatchConstants is called stops the computer with a fault. Intended
+// for the constants the programs rely on, like the assembler's __ONE
+// and __ZERO, must be called after loading the program.
+func (self *Computer) WatchConstants(addrs ...Address) {
+	for _, a := range addrs {
+		self.constants = append(self.constants, constant{a, self.fetchOperand(a)})
+	}
+}
+
+// checkConstants return true if the watched constants keep their
+// values. If not sets the fault, ip is the address of the instruction
+// that changed them.
+func (self *Computer) checkConstants(ip Address) bool {
+	for _, k := range self.constants {
+		if self.fetchOperand(k.address) != k.value {
+			self.fault = &Fault{IP: ip, Address: k.address, Reason: "constant modified"}
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,27 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchConstantsTrapsChanges(t *testing.T) {
+	c := Computer{}
+	c.LoadMemory(t_protected)
+	c.WatchConstants(0x08, 0x0C)
+	c.Step()
+	assert.True(t, c.Halted())
+	assert.Equal(t, &Fault{IP: 0x00, Address: 0x0C, Reason: "constant modified"}, c.Fault())
+	assert.Equal(t, "constant modified at 000c, instruction at 0000", c.Fault().Error())
+}
+
+func TestWatchConstantsAllowsSameValue(t *testing.T) {
+	c := Computer{}
+	// SBNZ 0x08, 0x0A, 0x08, 0x0000, writes 5 - 0 to 0x08
+	c.LoadMemory([]uint8{0x00, 0x08, 0x00, 0x0A, 0x00, 0x08, 0x00, 0x00, 0x00, 0x05, 0x00, 0x00})
+	c.WatchConstants(0x08, 0x0A)
+	c.Step()
+	assert.Nil(t, c.Fault())
+	assert.Equal(t, Address(0), c.IP())
+}
@@ -0,0 +1,206 @@
+package vm
+
+// Fast interpreter
+//
+// Run executes SBNZ programs from a cache of predecoded instructions,
+// saving the decoding of the four operands at each step, and accesses
+// the memory directly. The cache is indexed by address and allocated
+// on demand, in pages like the memory. Writing a byte of a decoded
+// instruction invalidates it, so self-modifying code behaves like
+// with Step.
+//
+// The features checked at each step (guards, banks, memory map,
+// watched constants, interrupts and test-and-set registers) and the
+// other instruction sets are not supported by the fast path, Run
+// falls back to Step when any of them is enabled.
+//
+// With the default configuration (memory of 64K in a single page and
+// 16 bits big endian words) Run and Step read the instructions and
+// the operands straight from the page, decoding is cheaper than the
+// cache. Other memories fitting in a page are accessed directly too.
+
+// decoded a predecoded SBNZ instruction
+type decoded struct {
+	a, b, c, d Address
+	next       Address // address of the next instruction
+	valid      bool
+}
+
+// icachePage the instructions of a page, memories smaller than a
+// page get smaller pages
+type icachePage struct {
+	entries []decoded
+	code    []bool // bytes of decoded instructions
+}
+
+// icache the predecoded instructions, by address
+type icache struct {
+	pages []*icachePage
+	width Address // size of an instruction in bytes
+	max   Address // highest address, instructions may wrap around
+}
+
+// page return the page containing p, allocating it if required
+func (self *icache) page(p Address) *icachePage {
+	i := int(p >> pageBits)
+	if i >= len(self.pages) {
+		self.pages = append(self.pages, make([]*icachePage, i+1-len(self.pages))...)
+	}
+	if self.pages[i] == nil {
+		n := Address(1 << pageBits)
+		if self.max < n {
+			n = self.max + 1
+		}
+		self.pages[i] = &icachePage{entries: make([]decoded, n), code: make([]bool, n)}
+	}
+	return self.pages[i]
+}
+
+// written invalidates the instructions containing the byte at p, if
+// any
+func (self *icache) written(p Address) {
+	if i := int(p >> pageBits); i >= len(self.pages) || self.pages[i] == nil ||
+		!self.pages[i].code[p&(1<<pageBits-1)] {
+		return
+	}
+	for i := Address(0); i < self.width; i++ {
+		q := (p - i) & self.max
+		if j := int(q >> pageBits); j < len(self.pages) && self.pages[j] != nil {
+			self.pages[j].entries[q&(1<<pageBits-1)].valid = false
+		}
+	}
+}
+
+// fast return true if the fast path supports the setup of the
+// computer
+func (self *Computer) fast() bool {
+	return (self.isa == nil || self.isa == SBNZ) && self.guards == nil && self.banks == nil &&
+		self.regions == nil && self.constants == nil && self.interrupts == nil && self.tas == nil
+}
+
+// word16 return the 16 bits big endian word at p
+func word16(m *page, p uint16) uint16 {
+	return uint16(m[p])<<8 | uint16(m[p+1])
+}
+
+// decode return the cache entry of the instruction at IP, decoding it
+// if required
+func (self *Computer) decode() *decoded {
+	in := &self.icache.page(self.ip).entries[self.ip&(1<<pageBits-1)]
+	if in.valid {
+		return in
+	}
+	in.a = self.fetchAddress(self.ip)
+	in.b = self.fetchAddress(self.word(1))
+	in.c = self.fetchAddress(self.word(2))
+	in.d = self.fetchAddress(self.word(3))
+	in.next = self.word(4)
+	in.valid = true
+	for i := Address(0); i < self.icache.width; i++ {
+		p := (self.ip + i) & self.icache.max
+		self.icache.page(p).code[p&(1<<pageBits-1)] = true
+	}
+	return in
+}
+
+// Run executes up to max steps, stopping if the computer halts, and
+// returns the number of steps executed. Same as calling Step, faster.
+func (self *Computer) Run(max int) int {
+	n := 0
+	if !self.fast() {
+		for ; n < max && !self.Halted(); n++ {
+			self.Step()
+		}
+		return n
+	}
+	if m := self.flat(); m != nil {
+		return self.runWords(m, max)
+	}
+	if self.icache == nil {
+		self.icache = &icache{width: SBNZ.Width() * self.config.Bytes(), max: self.config.MaxAddress()}
+	}
+	mem, cache := &self.memory, self.icache
+	bytes, mask := self.config.Bytes(), self.config.MaxAddress()
+	shift := 32 - self.config.bits()
+	little := self.config.Order == LittleEndian
+	// memories fitting in a page are accessed directly
+	var flat *page
+	if mask < 1<<pageBits {
+		flat = mem.first()
+	}
+	get := func(p Address) uint8 {
+		if flat != nil {
+			return flat[p]
+		}
+		return mem.get(p)
+	}
+	load := func(p Address) Operand {
+		var w Address
+		for i := Address(0); i < bytes; i++ {
+			q := p + i
+			if little {
+				q = p + bytes - 1 - i
+			}
+			w = w<<8 | Address(get(q&mask))
+		}
+		return Operand(w<<shift) >> shift
+	}
+	store := func(p Address, o Operand) {
+		w := Address(o)
+		for i := bytes; i > 0; i-- {
+			q := p + i - 1
+			if little {
+				q = p + bytes - i
+			}
+			q &= mask
+			cache.written(q)
+			if flat != nil {
+				flat[q] = uint8(w)
+			} else {
+				mem.set(q, uint8(w))
+			}
+			w >>= 8
+		}
+	}
+	for ; n < max && self.ip != mask && self.fault == nil; n++ {
+		in := self.decode()
+		r := Operand(Address(load(in.a)-load(in.b))<<shift) >> shift
+		store(in.c, r)
+		switch {
+		case r == 0:
+			self.ip = in.next
+		case in.valid:
+			self.ip = in.d
+		default:
+			// the instruction overwrote itself
+			self.ip = self.fetchAddress(self.word(3))
+		}
+	}
+	return n
+}
+
+// runWords is Run with the default configuration, m is the memory,
+// see flat. Decoding the instructions from m is cheaper than the
+// cache.
+func (self *Computer) runWords(m *page, max int) int {
+	if self.fault != nil {
+		return 0
+	}
+	n, ip := 0, uint16(self.ip)
+	for ; n < max && ip != 0xFFFF; n++ {
+		r := int16(word16(m, word16(m, ip))) - int16(word16(m, word16(m, ip+2)))
+		c := word16(m, ip+4)
+		if self.icache != nil {
+			self.icache.written(Address(c))
+			self.icache.written(Address(c + 1))
+		}
+		m[c], m[c+1] = uint8(r>>8), uint8(r)
+		if r != 0 {
+			ip = word16(m, ip+6)
+		} else {
+			ip += 8
+		}
+	}
+	self.ip = Address(ip)
+	return n
+}
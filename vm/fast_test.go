@@ -0,0 +1,192 @@
+package vm
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// t_countdown decrements CNT from 0x7FFF to 0, then halts
+var t_countdown = []uint8{
+	0x00, 0x10, 0x00, 0x12, 0x00, 0x10, 0x00, 0x00, // SBNZ CNT, ONE, CNT, 0x0000
+	0x00, 0x12, 0x00, 0x14, 0x00, 0x16, 0xFF, 0xFF, // SBNZ ONE, ZERO, JUNK, HALT
+	0x7F, 0xFF, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, // CNT, ONE, ZERO, JUNK
+}
+
+// t_countdownFor return t_countdown with the words of config, counts
+// from n
+func t_countdownFor(config Config, n Address) []uint8 {
+	w := config.Bytes()
+	words := []Address{8 * w, 9 * w, 8 * w, 0, 9 * w, 10 * w, 11 * w, config.MaxAddress(), n, 1, 0, 0}
+	res := make([]uint8, len(words)*int(w))
+	for i, v := range words {
+		config.PutWord(res[i*int(w):], v)
+	}
+	return res
+}
+
+// t_randomProgram a program of n random words pointing inside the
+// program, so it overwrites itself often
+func t_randomProgram(rng *rand.Rand, config Config, n int) []uint8 {
+	size := n * int(config.Bytes())
+	res := make([]uint8, size)
+	for i := 0; i < size; i += int(config.Bytes()) {
+		config.PutWord(res[i:], Address(rng.Intn(size)))
+	}
+	return res
+}
+
+// t_sameMemory return true if both memories hold the same bytes
+func t_sameMemory(a, b *memory) bool {
+	n := len(a.pages)
+	if len(b.pages) > n {
+		n = len(b.pages)
+	}
+	var zero page
+	for i := 0; i < n; i++ {
+		pa, pb := &zero, &zero
+		if i < len(a.pages) && a.pages[i] != nil {
+			pa = a.pages[i]
+		}
+		if i < len(b.pages) && b.pages[i] != nil {
+			pb = b.pages[i]
+		}
+		if *pa != *pb {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRun(t *testing.T) {
+	c := Computer{}
+	c.LoadMemory(t_countdown)
+	assert.Equal(t, 1000, c.Run(1000))
+	assert.Equal(t, Operand(0x7FFF-1000), c.Peek(0x10))
+	assert.Equal(t, 0x7FFF+1-1000, c.Run(1000000))
+	assert.True(t, c.Halted())
+	assert.Equal(t, Operand(0), c.Peek(0x10))
+	assert.Equal(t, 0, c.Run(1000))
+}
+
+func TestRunFallsBackToStep(t *testing.T) {
+	c := Computer{}
+	c.LoadMemory(t_countdown)
+	c.Guard(0x10, 0x10)
+	assert.Equal(t, 1, c.Run(1000))
+	assert.NotNil(t, c.Fault())
+}
+
+// TestRunTraces checks Run and Step execute the same instructions and
+// leave the same memory, running random self-modifying programs
+func TestRunTraces(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, config := range []Config{DefaultConfig, {WordSize: 8}, {WordSize: 32, Order: LittleEndian}} {
+		for i := 0; i < 50; i++ {
+			program := t_randomProgram(rng, config, 64)
+			ref, fast := Computer{}, Computer{}
+			for _, c := range []*Computer{&ref, &fast} {
+				c.SetConfig(config)
+				c.LoadMemory(program)
+			}
+			for step := 0; step < 2000 && !ref.Halted(); step++ {
+				ref.Step()
+				assert.Equal(t, 1, fast.Run(1))
+				if !assert.Equal(t, ref.IP(), fast.IP(), "config %v program %d step %d", config, i, step) {
+					break
+				}
+			}
+			assert.Equal(t, ref.Halted(), fast.Halted())
+			assert.True(t, t_sameMemory(&ref.memory, &fast.memory), "config %v program %d", config, i)
+		}
+	}
+}
+
+// TestStepWords checks the default configuration fast path of Step
+// executes like the general one, forced by an unused guard
+func TestStepWords(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		program := t_randomProgram(rng, DefaultConfig, 64)
+		ref, fast := Computer{}, Computer{}
+		ref.LoadMemory(program)
+		ref.Guard(0xFFF0, 0xFFF0)
+		fast.LoadMemory(program)
+		for step := 0; step < 2000 && !ref.Halted(); step++ {
+			ref.Step()
+			fast.Step()
+			if !assert.Equal(t, ref.IP(), fast.IP(), "program %d step %d", i, step) {
+				break
+			}
+		}
+		assert.Equal(t, ref.Halted(), fast.Halted())
+		assert.True(t, t_sameMemory(&ref.memory, &fast.memory), "program %d", i)
+	}
+}
+
+func BenchmarkStep(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		c := Computer{}
+		c.LoadMemory(t_countdown)
+		for !c.Halted() {
+			c.Step()
+		}
+	}
+}
+
+// BenchmarkStepGeneric the general path of Step, an unused guard
+// disables the fast path
+func BenchmarkStepGeneric(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		c := Computer{}
+		c.LoadMemory(t_countdown)
+		c.Guard(0xFFF0, 0xFFF0)
+		for !c.Halted() {
+			c.Step()
+		}
+	}
+}
+
+func BenchmarkRun(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		c := Computer{}
+		c.LoadMemory(t_countdown)
+		c.Run(1 << 20)
+	}
+}
+
+// BenchmarkConfigs compares Run to Step with several configurations
+func BenchmarkConfigs(b *testing.B) {
+	for _, config := range []Config{DefaultConfig, {WordSize: 8}, {WordSize: 32, Order: LittleEndian}} {
+		n := config.MaxAddress() >> 1
+		if n > 0x7FFF {
+			n = 0x7FFF
+		}
+		program := t_countdownFor(config, n)
+		for _, run := range []bool{false, true} {
+			name := fmt.Sprintf("%d bits order %d step", config.bits(), config.Order)
+			if run {
+				name = fmt.Sprintf("%d bits order %d run", config.bits(), config.Order)
+			}
+			b.Run(name, func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					c := Computer{}
+					c.SetConfig(config)
+					c.LoadMemory(program)
+					if run {
+						c.Run(1 << 20)
+					} else {
+						for !c.Halted() {
+							c.Step()
+						}
+					}
+					if !c.Halted() {
+						b.Fatal("not halted")
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package vm
+
+import "fmt"
+
+// Interrupts describes the timer interrupt. Its registers are words
+// mapped in memory, programs configure the interrupt writing to them.
+// Every Timer steps with the interrupts enabled the computer saves the
+// IP in SavedIP, disables the interrupts and jumps to the address in
+// Vector. Taking the interrupt takes a step. The timer doesn't count
+// while the interrupts are disabled, so the program progresses even
+// if the handler takes longer than the period.
+//
+// Returning from the handler requires enabling the interrupts and
+// jumping to the saved IP, two instructions, so after enabling them
+// the interrupts are not taken until the next instruction has been
+// executed.
+type Interrupts struct {
+	// Timer address of the timer register, the number of steps
+	// between interrupts, 0 stops the timer
+	Timer Address
+	// Vector address of the interrupt vector, holding the address of
+	// the handler
+	Vector Address
+	// SavedIP address where the IP of the interrupted instruction is
+	// saved
+	SavedIP Address
+	// Enable address of the interrupt enable register, the interrupts
+	// are enabled if not zero
+	Enable Address
+}
+
+// registers return the addresses of the registers
+func (self Interrupts) registers() []Address {
+	return []Address{self.Timer, self.Vector, self.SavedIP, self.Enable}
+}
+
+// Validate return an error if the registers don't fit in the memory
+// or overlap
+func (self Interrupts) Validate(config Config) error {
+	regs := self.registers()
+	for i, p := range regs {
+		if uint64(p)+uint64(config.Bytes()) > config.MemorySize() {
+			return fmt.Errorf("interrupt register outside the memory")
+		}
+		for _, q := range regs[:i] {
+			if p < q+config.Bytes() && q < p+config.Bytes() {
+				return fmt.Errorf("overlapping interrupt registers")
+			}
+		}
+	}
+	return nil
+}
+
+// SetInterrupts enables the timer interrupt. The registers are
+// cleared, the timer is stopped and the interrupts disabled. Must be
+// called after SetConfig.
+func (self *Computer) SetInterrupts(interrupts Interrupts) error {
+	if err := interrupts.Validate(self.config); err != nil {
+		return err
+	}
+	self.interrupts = &interrupts
+	for _, p := range interrupts.registers() {
+		self.putOperand(p, 0)
+	}
+	self.ticks = 0
+	self.enabled = false
+	return nil
+}
+
+// Interrupts return the timer interrupt configuration, nil if not
+// enabled
+func (self *Computer) Interrupts() *Interrupts {
+	return self.interrupts
+}
+
+// interrupt counts the step and takes the timer interrupt if due.
+// Returns true if taken.
+func (self *Computer) interrupt() bool {
+	regs := self.interrupts
+	enabled := self.fetchAddress(regs.Enable) != 0
+	// interrupts enabled by the previous instruction wait for the next
+	// one
+	ready := enabled && self.enabled
+	self.enabled = enabled
+	timer := self.fetchAddress(regs.Timer)
+	if !ready || timer == 0 {
+		return false
+	}
+	self.ticks++
+	if self.ticks < uint64(timer) {
+		return false
+	}
+	self.ticks = 0
+	self.putOperand(regs.SavedIP, Operand(self.ip))
+	self.putOperand(regs.Enable, 0)
+	self.enabled = false
+	self.ip = self.fetchAddress(regs.Vector)
+	return true
+}
@@ -0,0 +1,69 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// t_interrupts the registers at 0x20
+var t_interrupts = Interrupts{Timer: 0x20, Vector: 0x22, SavedIP: 0x24, Enable: 0x26}
+
+// t_loops a loop at 0x00 and the handler, another loop, at 0x40
+var t_loops = []uint8{
+	0x00, 0x10, 0x00, 0x12, 0x00, 0x14, 0x00, 0x00, // SBNZ 0x10, 0x12, 0x14, 0x0000
+	0, 0, 0, 0, 0, 0, 0, 0,
+	0x00, 0x01, 0x00, 0x00, 0x00, 0x00, // 1, 0, junk
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, // registers
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0x00, 0x10, 0x00, 0x12, 0x00, 0x14, 0x00, 0x40, // SBNZ 0x10, 0x12, 0x14, 0x0040
+}
+
+func TestSetInterruptsValidates(t *testing.T) {
+	c := Computer{}
+	assert.NotNil(t, c.SetInterrupts(Interrupts{Timer: 0x20, Vector: 0x21, SavedIP: 0x24, Enable: 0x26}))
+	assert.NotNil(t, c.SetInterrupts(Interrupts{Timer: 0xFFFF, Vector: 0x22, SavedIP: 0x24, Enable: 0x26}))
+	assert.Nil(t, c.SetInterrupts(t_interrupts))
+	assert.Equal(t, &t_interrupts, c.Interrupts())
+}
+
+func TestTimerInterrupt(t *testing.T) {
+	c := Computer{}
+	assert.Nil(t, c.SetInterrupts(t_interrupts))
+	c.LoadMemory(t_loops)
+	c.putOperand(0x20, 3)
+	c.putOperand(0x22, 0x40)
+	c.putOperand(0x26, 1)
+
+	// the first instruction after enabling the interrupts isn't counted
+	for i := 0; i < 3; i++ {
+		c.Step()
+		assert.Equal(t, Address(0x00), c.IP())
+	}
+	c.Step()
+	assert.Equal(t, Address(0x40), c.IP())
+	assert.Equal(t, Operand(0x00), c.Peek(0x24))
+	assert.Equal(t, Operand(0), c.Peek(0x26))
+}
+
+func TestTimerStopsWhileDisabled(t *testing.T) {
+	c := Computer{}
+	assert.Nil(t, c.SetInterrupts(t_interrupts))
+	c.LoadMemory(t_loops)
+	c.putOperand(0x20, 2)
+	c.putOperand(0x22, 0x40)
+	for i := 0; i < 5; i++ {
+		c.Step()
+	}
+	assert.Equal(t, Address(0x00), c.IP())
+
+	c.putOperand(0x26, 1)
+	for i := 0; i < 2; i++ {
+		c.Step()
+		assert.Equal(t, Address(0x00), c.IP())
+	}
+	c.Step()
+	assert.Equal(t, Address(0x40), c.IP())
+	assert.Nil(t, c.Fault())
+}
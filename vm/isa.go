@@ -0,0 +1,180 @@
+package vm
+
+////////////////////////////////////////////////////////////////////////
+//
+// instruction sets
+//
+// The computer is a one instruction set computer, the semantics of
+// the single instruction are pluggable. Each ISA defines the width of
+// the instruction and the halt convention, when the program halts
+// according to the convention the ISA sets the IP to the highest
+// address (HALT in the default configuration).
+
+// ISA the semantics of the single instruction of a computer
+type ISA interface {
+	// Name of the instruction set
+	Name() string
+	// Width size of an instruction, in words
+	Width() Address
+	// Execute executes the instruction at IP and updates IP
+	Execute(c *Computer)
+}
+
+// SetISA selects the instruction set of the computer, SBNZ by default
+func (self *Computer) SetISA(isa ISA) {
+	self.isa = isa
+}
+
+// ISA return the instruction set of the computer
+func (self *Computer) ISA() ISA {
+	if self.isa == nil {
+		return SBNZ
+	}
+	return self.isa
+}
+
+// SBNZ a, b, c, d: subtract and branch if not equal to zero. Subtracts
+// the contents at address b from the contents at address a, stores
+// the result at address c, and then, if the result is not 0, jumps
+// to address d. Halts when jumping to the highest address.
+var SBNZ ISA = sbnz{}
+
+type sbnz struct{}
+
+func (sbnz) Name() string   { return "SBNZ" }
+func (sbnz) Width() Address { return 4 }
+
+func (sbnz) Execute(c *Computer) {
+	a, ok1 := c.read(c.fetchAddress(c.ip))
+	b, ok2 := c.read(c.fetchAddress(c.word(1)))
+	if !ok1 || !ok2 {
+		return
+	}
+	r := c.config.Wrap(a - b)
+	if !c.write(c.fetchAddress(c.word(2)), r) {
+		return
+	}
+	if r != 0 {
+		c.ip = c.fetchAddress(c.word(3))
+	} else {
+		c.next(4)
+	}
+}
+
+// SUBLEQ a, b, c: subtract and branch if less than or equal to zero.
+// Subtracts the contents at address a from the contents at address
+// b, stores the result at address b, and then, if the result is less
+// than or equal to 0, jumps to address c. Halts when jumping to the
+// highest address.
+var SUBLEQ ISA = subleq{}
+
+type subleq struct{}
+
+func (subleq) Name() string   { return "SUBLEQ" }
+func (subleq) Width() Address { return 3 }
+
+func (subleq) Execute(c *Computer) {
+	pb := c.fetchAddress(c.word(1))
+	a, ok1 := c.read(c.fetchAddress(c.ip))
+	b, ok2 := c.read(pb)
+	if !ok1 || !ok2 {
+		return
+	}
+	r := c.config.Wrap(b - a)
+	if !c.write(pb, r) {
+		return
+	}
+	if r <= 0 {
+		c.ip = c.fetchAddress(c.word(2))
+	} else {
+		c.next(3)
+	}
+}
+
+// Memory mapped registers of the RSSB computer in the default
+// configuration, in general they are the third and second words
+// before the end of the memory (see RSSBRegisters)
+const (
+	// RSSBIP holds the address of the next instruction, writing to it
+	// jumps
+	RSSBIP Address = 0xFFFA
+	// RSSBAcc the accumulator
+	RSSBAcc Address = 0xFFFC
+)
+
+// RSSB a: reverse subtract and skip if borrow. Subtracts the
+// accumulator from the contents at address a and stores the result
+// both at address a and in the accumulator. If the result is negative
+// skips the next instruction. The IP and the accumulator are mapped in
+// memory (RSSBIP and RSSBAcc), the IP holds the address of the next
+// instruction while executing, so jumps are performed subtracting
+// from RSSBIP. Halts when a is the highest address.
+var RSSB ISA = rssb{}
+
+// RSSBRegisters return the addresses of the IP and the accumulator of
+// the RSSB computer for the configuration
+func RSSBRegisters(config Config) (ip, acc Address) {
+	end := config.MaxAddress() + 1
+	return end - 3*config.Bytes(), end - 2*config.Bytes()
+}
+
+type rssb struct{}
+
+func (rssb) Name() string   { return "RSSB" }
+func (rssb) Width() Address { return 1 }
+
+func (rssb) Execute(c *Computer) {
+	a := c.fetchAddress(c.ip)
+	if a == c.config.MaxAddress() {
+		c.ip = a
+		return
+	}
+	pip, pacc := RSSBRegisters(c.config)
+	if !c.write(pip, Operand(c.word(1))) {
+		return
+	}
+	va, ok1 := c.read(a)
+	acc, ok2 := c.read(pacc)
+	if !ok1 || !ok2 {
+		return
+	}
+	r := c.config.Wrap(va - acc)
+	if !c.write(a, r) || !c.write(pacc, r) {
+		return
+	}
+	c.ip = c.fetchAddress(pip)
+	if r < 0 {
+		c.next(1)
+	}
+}
+
+// BitBitJump a, b, c: copies the bit at bit address a to bit address
+// b and jumps to address c. Bit address n refers to the bit n%8 (0 is
+// the least significant) of the byte at address n/8, so only the
+// first eighth of the memory is bit addressable. Halts when jumping
+// to itself.
+var BitBitJump ISA = bitBitJump{}
+
+type bitBitJump struct{}
+
+func (bitBitJump) Name() string   { return "BitBitJump" }
+func (bitBitJump) Width() Address { return 3 }
+
+func (bitBitJump) Execute(c *Computer) {
+	a := c.fetchAddress(c.ip)
+	b := c.fetchAddress(c.word(1))
+	next := c.fetchAddress(c.word(2))
+	if !c.checkAccess(a/8, 1, Read) || !c.checkWrite(b/8, 1) || !c.checkAccess(b/8, 1, Write) {
+		return
+	}
+	bit := (c.get(a/8) >> (a % 8)) & 1
+	c.set(b/8, c.get(b/8)&^(1<<(b%8))|bit<<(b%8))
+	if c.banks != nil && b/8 >= c.banks.Select && b/8 < c.banks.Select+c.config.Bytes() {
+		c.selectBank()
+	}
+	if next == c.ip {
+		c.ip = c.config.MaxAddress()
+	} else {
+		c.ip = next
+	}
+}
@@ -0,0 +1,130 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultISAIsSBNZ(t *testing.T) {
+	c := Computer{}
+	assert.Equal(t, SBNZ, c.ISA())
+	c.SetISA(SUBLEQ)
+	assert.Equal(t, SUBLEQ, c.ISA())
+}
+
+func TestISAWidth(t *testing.T) {
+	assert.Equal(t, Address(4), SBNZ.Width())
+	assert.Equal(t, Address(3), SUBLEQ.Width())
+	assert.Equal(t, Address(1), RSSB.Width())
+	assert.Equal(t, Address(3), BitBitJump.Width())
+}
+
+func TestSUBLEQ(t *testing.T) {
+	data := []struct {
+		a, b Operand
+		eip  Address // expected IP
+	}{
+		{2, 5, 0x06},       // positive, next instruction
+		{5, 5, 0xFA},       // zero, branches
+		{5, 2, 0xFA},       // negative, branches
+		{-1, 0x7FFF, 0xFA}, // overflows
+	}
+	for i, d := range data {
+		t.Logf("Iteration %d, %#v", i, d)
+		c := Computer{}
+		c.LoadMemory([]uint8{
+			0x00, 0x06, // a
+			0x00, 0x08, // b
+			0x00, 0xFA, // c
+		})
+		c.putOperand(0x06, d.a)
+		c.putOperand(0x08, d.b)
+		c.SetISA(SUBLEQ)
+		c.Step()
+
+		assert.Equal(t, d.eip, c.ip, "IP mismatch")
+		assert.Equal(t, DefaultConfig.Wrap(d.b-d.a), c.Peek(0x08))
+		assert.Equal(t, d.a, c.Peek(0x06))
+	}
+}
+
+func TestSUBLEQHalts(t *testing.T) {
+	c := Computer{}
+	c.LoadMemory([]uint8{
+		0x00, 0x06, // a
+		0x00, 0x06, // b
+		0xFF, 0xFF, // c
+		0x00, 0x05,
+	})
+	c.SetISA(SUBLEQ)
+	c.Step()
+	assert.True(t, c.Halted())
+}
+
+func TestRSSB(t *testing.T) {
+	c := Computer{}
+	c.LoadMemory([]uint8{
+		0x00, 0x10, // rssb 0x10
+		0x00, 0x12, // rssb 0x12, skipped
+		0x00, 0x14, // rssb 0x14
+	})
+	c.putOperand(0x10, 2)
+	c.putOperand(RSSBAcc, 5)
+	c.SetISA(RSSB)
+
+	c.Step()
+	assert.Equal(t, Operand(-3), c.Peek(0x10))
+	assert.Equal(t, Operand(-3), c.Peek(RSSBAcc))
+	assert.Equal(t, Address(4), c.ip) // borrow, skip
+	c.Step()
+	assert.Equal(t, Operand(3), c.Peek(0x14))
+	assert.Equal(t, Operand(3), c.Peek(RSSBAcc))
+	assert.Equal(t, Address(6), c.ip)
+}
+
+func TestRSSBJumpsWritingTheIP(t *testing.T) {
+	c := Computer{}
+	c.LoadMemory([]uint8{
+		0xFF, 0xFA, // rssb RSSBIP
+	})
+	c.putOperand(RSSBAcc, -0x20)
+	c.SetISA(RSSB)
+	c.Step()
+	assert.Equal(t, Address(0x22), c.ip)
+}
+
+func TestRSSBHalts(t *testing.T) {
+	c := Computer{}
+	c.LoadMemory([]uint8{0xFF, 0xFF})
+	c.SetISA(RSSB)
+	c.Step()
+	assert.True(t, c.Halted())
+}
+
+func TestBitBitJump(t *testing.T) {
+	c := Computer{}
+	c.LoadMemory([]uint8{
+		0x00, 0x41, // a, bit 1 of byte 8
+		0x00, 0x4F, // b, bit 7 of byte 9
+		0x00, 0x20, // c
+		0x00, 0x00, // padding
+		0x02, 0x01, // data
+	})
+	c.SetISA(BitBitJump)
+	c.Step()
+	assert.Equal(t, uint8(0x81), c.memory.get(9))
+	assert.Equal(t, Address(0x20), c.ip)
+
+	c.LoadMemory([]uint8{
+		0x00, 0x40, // a, bit 0 of byte 8
+		0x00, 0x48, // b, bit 0 of byte 9
+		0x00, 0x00, // c, itself
+		0x00, 0x00, // padding
+		0x02, 0x01, // data
+	})
+	c.ip = 0
+	c.Step()
+	assert.Equal(t, uint8(0x00), c.memory.get(9))
+	assert.True(t, c.Halted())
+}
@@ -0,0 +1,156 @@
+package vm
+
+import "math/rand"
+
+// Machine several cores sharing a computer: the memory and the rest
+// of its setup (configuration, instruction set, memory map...). Each
+// core has its own IP and halts on its own. Each step of the machine
+// executes an instruction of one of the running cores, chosen by the
+// scheduler, so instructions are atomic but the interleaving of the
+// cores is not: programs must synchronize, see TestAndSet.
+type Machine struct {
+	computer  Computer
+	cores     []core
+	scheduler Scheduler
+}
+
+// core the state of a core
+type core struct {
+	ip    Address
+	fault error
+}
+
+// NewMachine return a machine with a core starting at each address
+func NewMachine(start ...Address) *Machine {
+	m := &Machine{scheduler: RoundRobin()}
+	for _, ip := range start {
+		m.cores = append(m.cores, core{ip: ip})
+	}
+	return m
+}
+
+// Computer return the computer shared by the cores, to load the
+// program and set it up. Its IP and fault are meaningless, the cores
+// have their own.
+func (self *Machine) Computer() *Computer {
+	return &self.computer
+}
+
+// SetScheduler sets how the cores are interleaved, RoundRobin by
+// default
+func (self *Machine) SetScheduler(s Scheduler) {
+	self.scheduler = s
+}
+
+// Cores return the number of cores
+func (self *Machine) Cores() int {
+	return len(self.cores)
+}
+
+// IP return the IP of the core
+func (self *Machine) IP(core int) Address {
+	return self.cores[core].ip
+}
+
+// Fault return the error that stopped the core, if any
+func (self *Machine) Fault(core int) error {
+	return self.cores[core].fault
+}
+
+// CoreHalted return true if the core is halted
+func (self *Machine) CoreHalted(core int) bool {
+	c := self.cores[core]
+	return c.ip == self.computer.config.MaxAddress() || c.fault != nil
+}
+
+// Halted return true if all the cores are halted
+func (self *Machine) Halted() bool {
+	for i := range self.cores {
+		if !self.CoreHalted(i) {
+			return false
+		}
+	}
+	return true
+}
+
+// Step executes an instruction of a running core, chosen by the
+// scheduler. Returns the core, -1 if all the cores are halted.
+func (self *Machine) Step() int {
+	var running []int
+	for i := range self.cores {
+		if !self.CoreHalted(i) {
+			running = append(running, i)
+		}
+	}
+	if len(running) == 0 {
+		return -1
+	}
+	i := running[self.scheduler.Next(running)]
+	c := &self.cores[i]
+	self.computer.ip, self.computer.fault = c.ip, c.fault
+	self.computer.Step()
+	c.ip, c.fault = self.computer.ip, self.computer.fault
+	return i
+}
+
+// Scheduler chooses the core executing the next step
+type Scheduler interface {
+	// Next return the index in running of the next core, running
+	// holds the running cores in order
+	Next(running []int) int
+}
+
+// RoundRobin return a scheduler stepping the running cores in turn
+func RoundRobin() Scheduler {
+	return &roundRobin{last: -1}
+}
+
+type roundRobin struct {
+	last int // last core stepped
+}
+
+func (self *roundRobin) Next(running []int) int {
+	for i, core := range running {
+		if core > self.last {
+			self.last = core
+			return i
+		}
+	}
+	self.last = running[0]
+	return 0
+}
+
+// Random return a scheduler stepping a running core chosen at random.
+// The interleaving is reproducible with the same seed.
+func Random(seed int64) Scheduler {
+	return random{rand.New(rand.NewSource(seed))}
+}
+
+type random struct {
+	rng *rand.Rand
+}
+
+func (self random) Next(running []int) int {
+	return self.rng.Intn(len(running))
+}
+
+// TestAndSet makes the word at p a test-and-set register: reading it
+// returns its value and sets it to 1, in the same step. A lock is
+// acquired reading a 0 and released writing a 0:
+//
+//	acquire: SBNZ LOCK, ZERO, JUNK, acquire
+//	         ...
+//	         SBNZ ZERO, ZERO, LOCK, next
+func (self *Computer) TestAndSet(p Address) {
+	self.tas = append(self.tas, p)
+}
+
+// testAndSet sets the test-and-set register at p, if any, after
+// reading it
+func (self *Computer) testAndSet(p Address) {
+	for _, r := range self.tas {
+		if r == p {
+			self.putOperand(p, 1)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// data of the counter programs
+const (
+	t_COUNT Address = 0x0100 + 2*iota
+	t_ZERO
+	t_ONE
+	t_MINUS1
+	t_JUNK
+	t_LOCK
+	t_T0
+	t_T1
+	t_N0
+	t_N1
+)
+
+// t_counter a program adding n to COUNT one at a time, at base and
+// using the temporary t. Optionally holding the lock while updating
+// COUNT.
+func t_counter(base, t, n Address, lock bool) []Address {
+	var code []Address
+	sbnz := func(a, b, c, d Address) {
+		code = append(code, a, b, c, d)
+	}
+	next := func() Address { return base + 2*Address(len(code)) + 8 }
+	loop := next() - 8
+	if lock {
+		sbnz(t_LOCK, t_ZERO, t_JUNK, loop)
+	}
+	sbnz(t_COUNT, t_ZERO, t, next())
+	sbnz(t, t_MINUS1, t, next())
+	sbnz(t, t_ZERO, t_COUNT, next())
+	if lock {
+		sbnz(t_ZERO, t_ZERO, t_LOCK, next())
+	}
+	sbnz(n, t_ONE, n, loop)
+	sbnz(t_ONE, t_ZERO, t_JUNK, HALT)
+	return code
+}
+
+// t_counters a machine running two counters, adding 50 each
+func t_counters(lock bool, s Scheduler) *Machine {
+	m := NewMachine(0x0000, 0x0080)
+	m.SetScheduler(s)
+	c := m.Computer()
+	for i, w := range t_counter(0x0000, t_T0, t_N0, lock) {
+		c.putOperand(Address(2*i), Operand(w))
+	}
+	for i, w := range t_counter(0x0080, t_T1, t_N1, lock) {
+		c.putOperand(0x0080+Address(2*i), Operand(w))
+	}
+	c.putOperand(t_ONE, 1)
+	c.putOperand(t_MINUS1, -1)
+	c.putOperand(t_N0, 50)
+	c.putOperand(t_N1, 50)
+	c.TestAndSet(t_LOCK)
+	return m
+}
+
+func t_runMachine(m *Machine) {
+	for i := 0; i < 10000 && !m.Halted(); i++ {
+		m.Step()
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	m := NewMachine(0x00, 0x08, 0x10)
+	m.Computer().LoadMemory([]uint8{
+		0x00, 0x18, 0x00, 0x1A, 0x00, 0x1C, 0x00, 0x00, // loops forever
+		0x00, 0x18, 0x00, 0x1A, 0x00, 0x1C, 0xFF, 0xFF, // halts
+		0x00, 0x18, 0x00, 0x1A, 0x00, 0x1C, 0x00, 0x10, // loops forever
+		0x00, 0x01, 0x00, 0x00, 0x00, 0x00,
+	})
+	var order []int
+	for i := 0; i < 6; i++ {
+		order = append(order, m.Step())
+	}
+	assert.Equal(t, []int{0, 1, 2, 0, 2, 0}, order)
+	assert.False(t, m.CoreHalted(0))
+	assert.True(t, m.CoreHalted(1))
+	assert.False(t, m.Halted())
+	assert.Equal(t, Address(0x10), m.IP(2))
+}
+
+func TestRandomSchedulerIsReproducible(t *testing.T) {
+	order := func(seed int64) []int {
+		s := Random(seed)
+		var res []int
+		for i := 0; i < 20; i++ {
+			res = append(res, s.Next([]int{0, 1, 2}))
+		}
+		return res
+	}
+	assert.Equal(t, order(42), order(42))
+	assert.NotEqual(t, order(42), order(43))
+}
+
+func TestCoresShareTheMemory(t *testing.T) {
+	m := t_counters(false, RoundRobin())
+	t_runMachine(m)
+	assert.True(t, m.Halted())
+	assert.Nil(t, m.Fault(0))
+	assert.Nil(t, m.Fault(1))
+	// in lockstep both cores read the same COUNT
+	assert.Equal(t, Operand(50), m.Computer().Peek(t_COUNT))
+}
+
+func TestRaceCondition(t *testing.T) {
+	m := t_counters(false, Random(1))
+	t_runMachine(m)
+	assert.True(t, m.Halted())
+	assert.True(t, m.Computer().Peek(t_COUNT) < 100)
+}
+
+func TestTestAndSetLock(t *testing.T) {
+	for seed := int64(0); seed < 5; seed++ {
+		m := t_counters(true, Random(seed))
+		t_runMachine(m)
+		assert.True(t, m.Halted())
+		assert.Equal(t, Operand(100), m.Computer().Peek(t_COUNT))
+		assert.Equal(t, Operand(0), m.Computer().Peek(t_LOCK))
+	}
+}
+
+func TestTestAndSet(t *testing.T) {
+	c := Computer{}
+	c.LoadMemory(t_protected)
+	c.TestAndSet(0x08)
+	c.Step()
+	assert.Equal(t, Operand(3), c.Peek(0x0C))
+	assert.Equal(t, Operand(1), c.Peek(0x08))
+}
@@ -8,36 +8,142 @@ import "fmt"
 //
 // - Unified address spaces for program and data.
 //
-// - big endian
+// - words of 8, 16, 24 or 32 bits, big or little endian, see Config.
+// By default 16 bits big endian.
 //
-// - Pointers are 16 bits long
+// - Pointers are one word long
 //
-// - Operands are 16 bit long, signed
+// - Operands are one word long, signed
 
-type Address uint16
-type Operand int16
+type Address uint32
+type Operand int32
 
-const MaxAddress = Address(^Address(0))
+// MaxAddress, MemorySize and HALT in the default configuration, see
+// Config for other configurations
+const MaxAddress = Address(0xFFFF)
 const MemorySize = uint(MaxAddress) + 1
 const HALT Address = MaxAddress
-const bytesPerAddress = 2
-const bytesPerOperand = 2
 
+// Computer the virtual machine. The memory is allocated in pages
+// shared by the copies of a Computer, writing the memory of a copy
+// changes the original: use Clone for independent copies.
 type Computer struct {
-	ip     Address
-	memory [MemorySize]uint8
+	ip      Address
+	memory  memory
+	guards  []guard
+	fault   error
+	isa     ISA
+	config  Config
+	banks   *Banks // nil if the memory is not banked
+	bank    int    // selected bank
+	regions MemoryMap
+	// watched constants, see WatchConstants
+	constants []constant
+	// timer interrupt, see SetInterrupts
+	interrupts *Interrupts
+	ticks      uint64 // steps with interrupts enabled since the last interrupt
+	enabled    bool   // interrupts enabled before the last step
+	// test-and-set registers, see TestAndSet
+	tas []Address
+	// predecoded instructions, see Run
+	icache *icache
+	// the memory with the default configuration, see flat
+	words *page
+}
+
+// SetConfig sets the word size and byte order of the computer,
+// DefaultConfig by default. Must be called before loading the
+// program.
+func (self *Computer) SetConfig(config Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	self.config = config
+	self.words = nil
+	return nil
+}
+
+// Config return the configuration of the computer
+func (self *Computer) Config() Config {
+	return self.config
+}
+
+// Clone return a copy of the computer sharing nothing with it: the
+// memory, the banks and the setup are copied
+func (self *Computer) Clone() Computer {
+	c := *self
+	c.memory = self.memory.clone()
+	c.guards = append([]guard(nil), self.guards...)
+	c.regions = append(MemoryMap(nil), self.regions...)
+	c.constants = append([]constant(nil), self.constants...)
+	c.tas = append([]Address(nil), self.tas...)
+	if self.banks != nil {
+		banks := *self.banks
+		c.banks = &banks
+	}
+	if self.interrupts != nil {
+		interrupts := *self.interrupts
+		c.interrupts = &interrupts
+	}
+	// point to the memory of self
+	c.icache, c.words = nil, nil
+	return c
+}
+
+// guard a range of addresses that can't be written
+type guard struct {
+	from, to Address
+}
+
+// Fault describes the error that stopped the computer
+type Fault struct {
+	IP      Address // address of the instruction
+	Address Address // address being accessed
+	Reason  string
+	// Access and Region describe the protection faults, the access
+	// denied and the region denying it
+	Access Permission
+	Region *Region
+}
+
+func (self *Fault) Error() string {
+	msg := fmt.Sprintf("%s at %04x, instruction at %04x", self.Reason, self.Address, self.IP)
+	if self.Region != nil {
+		msg += fmt.Sprintf(": %s access to %s", self.Access, self.Region)
+	}
+	return msg
 }
 
 // LoadMemory loads the memory image into memory
 func (self *Computer) LoadMemory(data []uint8) {
 	for i, c := range data {
-		self.memory[i] = c
+		self.set(Address(i), c)
+	}
+}
+
+// ReadMemory copies the memory, starting at address p, into data
+func (self *Computer) ReadMemory(p Address, data []uint8) {
+	for i := range data {
+		data[i] = self.get(p + Address(i))
 	}
 }
 
-// Halted return true if the computer is halted
+// Halted return true if the computer is halted, either because the
+// program jumped to HALT or because of a fault.
 func (self *Computer) Halted() bool {
-	return (self.ip == HALT)
+	return (self.ip == self.config.MaxAddress()) || self.fault != nil
+}
+
+// Fault return the error that stopped the computer, if any
+func (self *Computer) Fault() error {
+	return self.fault
+}
+
+// Guard protects the addresses in the range [from, to] against
+// writes. Writing to a guarded address stops the computer with a
+// fault. Useful for detecting stack overflows.
+func (self *Computer) Guard(from, to Address) {
+	self.guards = append(self.guards, guard{from, to})
 }
 
 func (self *Computer) Peek(a Address) Operand {
@@ -48,49 +154,128 @@ func (self *Computer) IP() Address {
 	return self.ip
 }
 
+// SetIP sets the address of the next instruction
+func (self *Computer) SetIP(ip Address) {
+	self.ip = ip
+}
+
+// word return the address of the i-th word of the instruction at IP
+func (self *Computer) word(i Address) Address {
+	return (self.ip + i*self.config.Bytes()) & self.config.MaxAddress()
+}
+
+// next moves the IP n words forward
+func (self *Computer) next(n Address) {
+	self.ip = self.word(n)
+}
+
+// flat return the memory if it's a single page of 16 bits big endian
+// words, the default configuration without banks, nil otherwise.
+// Fast path of the accesses to words.
+func (self *Computer) flat() *page {
+	if self.words != nil {
+		return self.words
+	}
+	if self.banks != nil || self.config.Order != BigEndian || self.config.WordSize != 0 && self.config.WordSize != 16 {
+		return nil
+	}
+	self.words = self.memory.first()
+	return self.words
+}
+
 func (self *Computer) fetchAddress(p Address) Address {
-	res := Address(0)
-	for i := 0; i < bytesPerAddress; i++ {
-		res = (res << 8) | Address(self.memory[int(p)+i])
+	if m := self.flat(); m != nil {
+		return Address(m[uint16(p)])<<8 | Address(m[uint16(p+1)])
+	}
+	var b [4]uint8
+	n := self.config.Bytes()
+	for i := Address(0); i < n; i++ {
+		b[i] = self.get(p + i)
 	}
-	return res
+	return self.config.Word(b[:n])
 }
 
 func (self *Computer) fetchOperand(p Address) Operand {
-	res := Operand(0)
-	for i := 0; i < bytesPerOperand; i++ {
-		res = (res << 8) | Operand(self.memory[int(p)+i])
+	if m := self.flat(); m != nil {
+		return Operand(int16(m[uint16(p)])<<8 | int16(m[uint16(p+1)]))
 	}
-	return res
+	return self.config.Signed(self.fetchAddress(p))
 }
 
 func (self *Computer) putOperand(p Address, o Operand) {
-	for i := bytesPerOperand - 1; i >= 0; i-- {
-		self.memory[int(p)+i] = uint8(o & Operand(0xFF))
-		o = o >> 8
+	if m := self.flat(); m != nil {
+		p, q := uint16(p), uint16(p+1)
+		if self.icache != nil {
+			self.icache.written(Address(p))
+			self.icache.written(Address(q))
+		}
+		m[p], m[q] = uint8(o>>8), uint8(o)
+		return
+	}
+	var b [4]uint8
+	n := self.config.Bytes()
+	self.config.PutWord(b[:n], Address(o))
+	for i := Address(0); i < n; i++ {
+		self.set(p+i, b[i])
+	}
+}
+
+// checkWrite return true if the n bytes at p can be written. If not
+// sets the fault.
+func (self *Computer) checkWrite(p Address, n uint) bool {
+	for _, g := range self.guards {
+		// the written bytes overlap the guard
+		if uint(p)+n-1 >= uint(g.from) && p <= g.to {
+			self.fault = &Fault{IP: self.ip, Address: p, Reason: "write to guarded address"}
+			return false
+		}
+	}
+	return true
+}
+
+// write stores o at p, unless p is guarded or o is not a valid bank
+// number and p the bank select register. Returns false in that case
+// and sets the fault.
+func (self *Computer) write(p Address, o Operand) bool {
+	if !self.checkWrite(p, uint(self.config.Bytes())) || !self.checkBank(p, o) ||
+		!self.checkAccess(p, uint(self.config.Bytes()), Write) {
+		return false
 	}
+	self.putOperand(p, o)
+	if self.banks != nil && p == self.banks.Select {
+		self.selectBank()
+	}
+	return true
 }
 
 // Step execute the next instruction and updates the IP pointer, if
 // the computer is not halted
 func (self *Computer) Step() {
-	if !self.Halted() {
-		a := self.fetchOperand(self.fetchAddress(self.ip))
-		b := self.fetchOperand(self.fetchAddress(self.ip + bytesPerAddress))
-		r := a - b
-		self.putOperand(self.fetchAddress(self.ip+2*bytesPerAddress), r)
-		if r != 0 {
-			self.ip = self.fetchAddress(self.ip + 3*bytesPerAddress)
-		} else {
-			self.ip += 4 * bytesPerAddress
-		}
+	if self.Halted() {
+		return
+	}
+	if m := self.flat(); m != nil && self.fast() {
+		self.runWords(m, 1)
+		return
+	}
+	if self.interrupts != nil && self.interrupt() {
+		return
+	}
+	isa := self.ISA()
+	if self.regions != nil && !self.checkAccess(self.ip, uint(isa.Width()*self.config.Bytes()), Execute) {
+		return
+	}
+	ip := self.ip
+	isa.Execute(self)
+	if self.constants != nil {
+		self.checkConstants(ip)
 	}
 }
 
 func (self *Computer) Print(n int) {
 	fmt.Printf("%5d: ", self.ip)
-	for _, v := range self.memory[:n] {
-		fmt.Printf("%03d ", v)
+	for i := 0; i < n; i++ {
+		fmt.Printf("%03d ", self.get(Address(i)))
 	}
 	fmt.Println("")
 }
@@ -0,0 +1,62 @@
+package vm
+
+// memory the memory of the computer. Allocated on demand, in pages,
+// so that computers with large address spaces are cheap.
+type memory struct {
+	pages []*page
+}
+
+const pageBits = 16
+
+type page [1 << pageBits]uint8
+
+// get return the byte at address p
+func (self *memory) get(p Address) uint8 {
+	i := int(p >> pageBits)
+	if i >= len(self.pages) || self.pages[i] == nil {
+		return 0
+	}
+	return self.pages[i][p&(1<<pageBits-1)]
+}
+
+// first return the first page, allocating it if required. Holds the
+// whole memory with the default configuration.
+func (self *memory) first() *page {
+	if len(self.pages) == 0 {
+		self.pages = make([]*page, 1)
+	}
+	if self.pages[0] == nil {
+		self.pages[0] = new(page)
+	}
+	return self.pages[0]
+}
+
+// clone return a copy of the memory not sharing the pages
+func (self *memory) clone() memory {
+	res := memory{pages: make([]*page, len(self.pages))}
+	for i, p := range self.pages {
+		if p != nil {
+			c := *p
+			res.pages[i] = &c
+		}
+	}
+	return res
+}
+
+// set stores the byte v at address p
+func (self *memory) set(p Address, v uint8) {
+	i := int(p >> pageBits)
+	if i >= len(self.pages) {
+		if v == 0 {
+			return
+		}
+		self.pages = append(self.pages, make([]*page, i+1-len(self.pages))...)
+	}
+	if self.pages[i] == nil {
+		if v == 0 {
+			return
+		}
+		self.pages[i] = new(page)
+	}
+	self.pages[i][p&(1<<pageBits-1)] = v
+}
@@ -0,0 +1,78 @@
+package vm
+
+import "fmt"
+
+// Permission access rights to a region of memory
+type Permission uint8
+
+const (
+	Read Permission = 1 << iota
+	Write
+	Execute
+)
+
+// String return the permissions in the "rwx" form
+func (self Permission) String() string {
+	res := []byte("---")
+	for i, c := range "rwx" {
+		if self&(1<<uint(i)) != 0 {
+			res[i] = byte(c)
+		}
+	}
+	return string(res)
+}
+
+// Region a range of addresses [From, To] with the same permissions
+type Region struct {
+	Name     string
+	From, To Address
+	Perm     Permission
+}
+
+// MemoryMap the regions of memory with restricted access. Addresses
+// outside of the regions can be read, written and executed. An access
+// touching several regions is allowed only if all of them allow it.
+type MemoryMap []Region
+
+// SetMemoryMap enables the memory protection. Reading, writing or
+// executing an address without the permission stops the computer
+// with a protection fault.
+func (self *Computer) SetMemoryMap(m MemoryMap) {
+	self.regions = m
+}
+
+// MemoryMap return the memory map of the computer
+func (self *Computer) MemoryMap() MemoryMap {
+	return self.regions
+}
+
+// checkAccess return true if the regions containing the n bytes at p
+// allow the access. If not sets the fault.
+func (self *Computer) checkAccess(p Address, n uint, access Permission) bool {
+	for i := range self.regions {
+		r := &self.regions[i]
+		if uint(p)+n-1 >= uint(r.From) && p <= r.To && r.Perm&access == 0 {
+			self.fault = &Fault{IP: self.ip, Address: p, Reason: "protection fault", Access: access, Region: r}
+			return false
+		}
+	}
+	return true
+}
+
+// read return the operand at p, unless p can't be read. Returns false
+// in that case and sets the fault. Sets the test-and-set registers.
+func (self *Computer) read(p Address) (Operand, bool) {
+	if !self.checkAccess(p, uint(self.config.Bytes()), Read) {
+		return 0, false
+	}
+	o := self.fetchOperand(p)
+	if self.tas != nil {
+		self.testAndSet(p)
+	}
+	return o, true
+}
+
+// String describes the region, used in fault messages
+func (self *Region) String() string {
+	return fmt.Sprintf("%s [%04x-%04x %s]", self.Name, self.From, self.To, self.Perm)
+}
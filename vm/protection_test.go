@@ -0,0 +1,70 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// t_protected SBNZ 0x08, 0x0A, 0x0C, 0x0000 with the data at 0x08
+var t_protected = []uint8{
+	0x00, 0x08, // a
+	0x00, 0x0A, // b
+	0x00, 0x0C, // c
+	0x00, 0x00, // d
+	0x00, 0x05, // *a
+	0x00, 0x02, // *b
+	0x00, 0x00, // *c
+}
+
+func TestPermissionString(t *testing.T) {
+	assert.Equal(t, "r-x", (Read | Execute).String())
+	assert.Equal(t, "---", Permission(0).String())
+	assert.Equal(t, "rwx", (Read | Write | Execute).String())
+}
+
+func TestMemoryMapAllowsAccess(t *testing.T) {
+	c := Computer{}
+	c.LoadMemory(t_protected)
+	c.SetMemoryMap(MemoryMap{
+		{"code", 0x00, 0x07, Read | Execute},
+		{"data", 0x08, 0x0D, Read | Write},
+	})
+	c.Step()
+	assert.Nil(t, c.Fault())
+	assert.Equal(t, Operand(3), c.Peek(0x0C))
+}
+
+func TestWriteProtection(t *testing.T) {
+	c := Computer{}
+	c.LoadMemory(t_protected)
+	c.SetMemoryMap(MemoryMap{{"constants", 0x0C, 0x0D, Read}})
+	c.Step()
+	assert.True(t, c.Halted())
+	assert.Equal(t, Operand(0), c.Peek(0x0C))
+	f := c.Fault().(*Fault)
+	assert.Equal(t, Write, f.Access)
+	assert.Equal(t, "constants", f.Region.Name)
+	assert.Equal(t, "protection fault at 000c, instruction at 0000: -w- access to constants [000c-000d r--]",
+		f.Error())
+}
+
+func TestReadProtection(t *testing.T) {
+	c := Computer{}
+	c.LoadMemory(t_protected)
+	c.SetMemoryMap(MemoryMap{{"secret", 0x0B, 0x0B, Write}})
+	c.Step()
+	assert.True(t, c.Halted())
+	assert.Equal(t, Read, c.Fault().(*Fault).Access)
+	assert.Equal(t, Address(0x0A), c.Fault().(*Fault).Address)
+}
+
+func TestExecuteProtection(t *testing.T) {
+	c := Computer{}
+	c.LoadMemory(t_protected)
+	c.SetMemoryMap(MemoryMap{{"data", 0x06, 0x0D, Read | Write}})
+	c.Step()
+	assert.True(t, c.Halted())
+	assert.Equal(t, Execute, c.Fault().(*Fault).Access)
+	assert.Equal(t, Operand(0), c.Peek(0x0C))
+}
@@ -0,0 +1,136 @@
+package vm
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////
+//
+// symbol tables
+//
+// A symbol table maps names to addresses. The assembler exports one
+// for each program so that any tool (the VM, a tracer, a debugger...)
+// can show symbolic names instead of raw addresses.
+//
+// Two formats are supported:
+//
+// - JSON: a list of {"name": ..., "address": ...} objects.
+//
+// - sym: a plain text format, one symbol per line, with the address
+//   in hexadecimal followed by the name. Empty lines and lines starting
+//   with ';' are ignored:
+//
+//       ; symbols for mult.bin
+//       0008 __ONE
+//       000a __ZERO
+
+// Symbol associates a name with an address
+type Symbol struct {
+	Name    string  `json:"name"`
+	Address Address `json:"address"`
+}
+
+// SymbolTable a list of symbols sorted by address and name
+type SymbolTable []Symbol
+
+// NewSymbolTable creates a symbol table from a map of names to
+// addresses.
+func NewSymbolTable(symbols map[string]Address) SymbolTable {
+	res := make(SymbolTable, 0, len(symbols))
+	for name, address := range symbols {
+		res = append(res, Symbol{name, address})
+	}
+	res.sort()
+	return res
+}
+
+func (self SymbolTable) sort() {
+	sort.Slice(self, func(i, j int) bool {
+		if self[i].Address != self[j].Address {
+			return self[i].Address < self[j].Address
+		}
+		return self[i].Name < self[j].Name
+	})
+}
+
+// Lookup returns the address of the symbol name
+func (self SymbolTable) Lookup(name string) (Address, bool) {
+	for _, s := range self {
+		if s.Name == name {
+			return s.Address, true
+		}
+	}
+	return 0, false
+}
+
+// Names returns the names of the symbols pointing to the address a,
+// sorted alphabetically.
+func (self SymbolTable) Names(a Address) []string {
+	var res []string
+	i := sort.Search(len(self), func(i int) bool { return self[i].Address >= a })
+	for ; i < len(self) && self[i].Address == a; i++ {
+		res = append(res, self[i].Name)
+	}
+	return res
+}
+
+// WriteJSON writes the symbol table to w in JSON format
+func (self SymbolTable) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(self)
+}
+
+// WriteSym writes the symbol table to w in sym format
+func (self SymbolTable) WriteSym(w io.Writer) error {
+	for _, s := range self {
+		if _, err := fmt.Fprintf(w, "%04x %s\n", s.Address, s.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadSymbolsJSON reads a symbol table in JSON format
+func ReadSymbolsJSON(r io.Reader) (SymbolTable, error) {
+	var res SymbolTable
+	if err := json.NewDecoder(r).Decode(&res); err != nil {
+		return nil, err
+	}
+	res.sort()
+	return res, nil
+}
+
+// ReadSymbols reads a symbol table in sym format
+func ReadSymbols(r io.Reader) (SymbolTable, error) {
+	var res SymbolTable
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected '<address> <name>'", lineno)
+		}
+		a, err := strconv.ParseUint(fields[0], 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad address %q", lineno, fields[0])
+		}
+		res = append(res, Symbol{fields[1], Address(a)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	res.sort()
+	return res, nil
+}
@@ -0,0 +1,62 @@
+package vm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSymbolTableSortsByAddressAndName(t *testing.T) {
+	s := NewSymbolTable(map[string]Address{"c": 2, "b": 1, "a": 2})
+	assert.Equal(t, SymbolTable{{"b", 1}, {"a", 2}, {"c", 2}}, s)
+}
+
+func TestSymbolTableLookup(t *testing.T) {
+	s := NewSymbolTable(map[string]Address{"foo": 0x10, "bar": 0x20})
+	a, ok := s.Lookup("bar")
+	assert.True(t, ok)
+	assert.Equal(t, Address(0x20), a)
+	_, ok = s.Lookup("baz")
+	assert.False(t, ok)
+}
+
+func TestSymbolTableNames(t *testing.T) {
+	s := NewSymbolTable(map[string]Address{"foo": 0x10, "bar": 0x10, "baz": 0x20})
+	assert.Equal(t, []string{"bar", "foo"}, s.Names(0x10))
+	assert.Equal(t, []string{"baz"}, s.Names(0x20))
+	assert.Nil(t, s.Names(0x30))
+}
+
+func TestSymbolTableJSON(t *testing.T) {
+	s := NewSymbolTable(map[string]Address{"foo": 0x10, "bar": 0xFFFE})
+	var buf bytes.Buffer
+	assert.Nil(t, s.WriteJSON(&buf))
+	r, err := ReadSymbolsJSON(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, s, r)
+}
+
+func TestSymbolTableSym(t *testing.T) {
+	s := NewSymbolTable(map[string]Address{"foo": 0x10, "bar": 0xFFFE})
+	var buf bytes.Buffer
+	assert.Nil(t, s.WriteSym(&buf))
+	assert.Equal(t, "0010 foo\nfffe bar\n", buf.String())
+	r, err := ReadSymbols(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, s, r)
+}
+
+func TestReadSymbolsSkipsCommentsAndBlankLines(t *testing.T) {
+	r, err := ReadSymbols(strings.NewReader("; comment\n\n  0008 __ONE\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, SymbolTable{{"__ONE", 8}}, r)
+}
+
+func TestReadSymbolsReportsErrors(t *testing.T) {
+	_, err := ReadSymbols(strings.NewReader("0008 __ONE\n0008\n"))
+	assert.Equal(t, "line 2: expected '<address> <name>'", err.Error())
+	_, err = ReadSymbols(strings.NewReader("xyz __ONE\n"))
+	assert.Equal(t, "line 1: bad address \"xyz\"", err.Error())
+}
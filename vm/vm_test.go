@@ -23,10 +23,20 @@ func TestLoadMemory(t *testing.T) {
 	c := Computer{}
 	c.LoadMemory(memory)
 	for i := uint(0); i < MemorySize; i++ {
-		assert.Equal(t, copy[i], c.memory[i])
+		assert.Equal(t, copy[i], c.memory.get(Address(i)))
 	}
 }
 
+func TestReadMemory(t *testing.T) {
+	c := Computer{}
+	c.LoadMemory([]uint8{0xFA, 0xBA, 0xDA, 0xFF})
+	data := make([]uint8, 3)
+	c.ReadMemory(1, data)
+	assert.Equal(t, []uint8{0xBA, 0xDA, 0xFF}, data)
+	c.SetIP(0x10)
+	assert.Equal(t, Address(0x10), c.IP())
+}
+
 func TestFetchAddress(t *testing.T) {
 	c := Computer{}
 	c.LoadMemory([]uint8{0xFA, 0xBA, 0xDA, 0xFF})
@@ -47,7 +57,28 @@ func TestPutOperand(t *testing.T) {
 	c.LoadMemory([]uint8{0x00, 0x00, 0x00, 0x00})
 	c.putOperand(0, Operand(0x0102))
 	c.putOperand(2, Operand(0x0304))
-	assert.Equal(t, c.memory[:4], []uint8{0x01, 0x02, 0x03, 0x04})
+	assert.Equal(t, []uint8{c.memory.get(0), c.memory.get(1), c.memory.get(2), c.memory.get(3)}, []uint8{0x01, 0x02, 0x03, 0x04})
+}
+
+func TestClone(t *testing.T) {
+	c := Computer{}
+	c.LoadMemory([]uint8{0x00, 0x10, 0x00, 0x12, 0x00, 0x10, 0xFF, 0xFF})
+	c.putOperand(0x10, 5)
+	c.putOperand(0x12, 1)
+	c.Run(10)
+	clone := c.Clone()
+	clone.Guard(0x20, 0x21)
+	clone.putOperand(0x10, 7)
+	clone.SetIP(0)
+	clone.Run(10)
+	assert.Equal(t, Operand(4), c.fetchOperand(0x10))
+	assert.Equal(t, Operand(6), clone.fetchOperand(0x10))
+	assert.Empty(t, c.guards)
+
+	// copies share the memory
+	copy := c
+	copy.putOperand(0x10, 7)
+	assert.Equal(t, Operand(7), c.fetchOperand(0x10))
 }
 
 func TestStep(t *testing.T) {
@@ -181,7 +212,7 @@ func TestStep(t *testing.T) {
 
 		assert.Equal(t, d.eip, c.ip, "IP mismatch")
 		for j, v := range d.emem {
-			assert.Equal(t, v, c.memory[j], "Memory mismatch")
+			assert.Equal(t, v, c.memory.get(Address(j)), "Memory mismatch")
 		}
 	}
 }
@@ -203,3 +234,50 @@ func TestHalt(t *testing.T) {
 	assert.Equal(t, MaxAddress, c.ip)
 	assert.True(t, c.Halted())
 }
+
+func TestGuard(t *testing.T) {
+	memory := []uint8{
+		0x00, 0x08, // a
+		0x00, 0x0A, // b
+		0x00, 0x0C, // c
+		0x00, 0x00, // d
+		0x00, 0x05, // *a
+		0x00, 0x02, // *b
+		0x00, 0x00, // *c
+	}
+	c := Computer{}
+	c.LoadMemory(memory)
+	c.Guard(0x0D, 0x10)
+	c.Step()
+
+	assert.True(t, c.Halted())
+	assert.Equal(t, Address(0), c.ip)
+	assert.Equal(t, uint8(0), c.memory.get(0x0D))
+	assert.Equal(t, &Fault{IP: 0, Address: 0x0C, Reason: "write to guarded address"}, c.Fault())
+	assert.Equal(t, "write to guarded address at 000c, instruction at 0000", c.Fault().Error())
+
+	// halted computers don't step
+	c.Step()
+	assert.Equal(t, Address(0), c.ip)
+}
+
+func TestGuardAllowsWritesOutsideTheRange(t *testing.T) {
+	memory := []uint8{
+		0x00, 0x08, // a
+		0x00, 0x0A, // b
+		0x00, 0x0C, // c
+		0x00, 0x00, // d
+		0x00, 0x05, // *a
+		0x00, 0x02, // *b
+		0x00, 0x00, // *c
+	}
+	c := Computer{}
+	c.LoadMemory(memory)
+	c.Guard(0x0E, 0x10)
+	c.Guard(0x00, 0x0B)
+	c.Step()
+
+	assert.False(t, c.Halted())
+	assert.Nil(t, c.Fault())
+	assert.Equal(t, Operand(3), c.Peek(0x0C))
+}